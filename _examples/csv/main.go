@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+	"github.com/alecthomas/repr"
+)
+
+// A lexer for CSV (RFC 4180). Quoted fields may contain commas, newlines and doubled quotes,
+// which are unescaped by the UnquoteDoubled filter.
+var csvLexer = lexer.Must(lexer.Regexp(
+	`(?P<Quoted>"(?:[^"]|"")*")` +
+		`|(?P<Text>[^,"\r\n]+)` +
+		`|(?P<Newline>\r?\n)` +
+		`|(?P<Comma>,)`,
+))
+
+type CSV struct {
+	Records []*Record `{ @@ }`
+}
+
+type Record struct {
+	// The first field of a record may be empty, so the lookahead asserts that a record follows. The
+	// final record need not be terminated by a newline.
+	Fields []*Field `&( Text | Quoted | "," | Newline ) @@ { "," @@ } ( Newline | EOF )`
+}
+
+type Field struct {
+	Value string `[ @(Text | Quoted) ]`
+}
+
+var parser = participle.MustBuild(&CSV{}, participle.Lexer(csvLexer), participle.UnquoteDoubled("Quoted"))
+
+func main() {
+	csv := &CSV{}
+	err := parser.Parse(os.Stdin, csv)
+	if err != nil {
+		panic(err)
+	}
+	repr.Println(csv, repr.Indent("  "), repr.OmitEmpty(true))
+}
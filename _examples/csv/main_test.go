@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	csv := &CSV{}
+	err := parser.ParseString("a,\"b,c\",\"d\"\"e\"\n\"\",f\n", csv)
+	require.NoError(t, err)
+	require.Equal(t, &CSV{Records: []*Record{
+		{Fields: []*Field{{Value: "a"}, {Value: "b,c"}, {Value: `d"e`}}},
+		{Fields: []*Field{{Value: ""}, {Value: "f"}}},
+	}}, csv)
+}
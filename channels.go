@@ -0,0 +1,54 @@
+package participle
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Find the channel fields of the root struct captured by the grammar, which may only be captured
+// into by the root struct.
+func (p *Parser) findChannels() error {
+	root := indirectType(p.typ)
+	seen := map[string]bool{}
+	p.channels = nil
+	return visitWithin(p.root, func(owner *strct, n node) error {
+		c, ok := n.(*capture)
+		if !ok {
+			return nil
+		}
+		for _, field := range append([]structLexerField{c.field}, c.also...) {
+			if field.Type.Kind() != reflect.Chan {
+				continue
+			}
+			if owner.typ != root || field.setter != "" {
+				return fmt.Errorf("%s.%s: channel fields can only be captured into by the root struct", ruleName(owner.typ), field.Name)
+			}
+			if field.Type.ChanDir()&reflect.SendDir == 0 {
+				return fmt.Errorf("%s.%s: can not send to a receive-only channel", ruleName(owner.typ), field.Name)
+			}
+			if !seen[field.Name] {
+				seen[field.Name] = true
+				p.channels = append(p.channels, field.Index)
+			}
+		}
+		return nil
+	})
+}
+
+// Give the channel fields of the target of the parse to v, the root struct being parsed.
+func copyChannels(target, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Chan && f.CanSet() {
+			f.Set(target.Field(i))
+		}
+	}
+}
+
+// Close the channel fields captured into by the grammar, once parsing has finished.
+func (p *Parser) closeChannels(target reflect.Value) {
+	for _, index := range p.channels {
+		if f := target.FieldByIndex(index); !f.IsNil() {
+			f.Close()
+		}
+	}
+}
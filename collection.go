@@ -0,0 +1,190 @@
+package participle
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A collection maintains the elements of a slice field tagged with the "set" or "sorted" opts,
+// eg.
+//
+//	Tags []string `parser:"{ @Ident }" opts:"set,sorted"`
+//
+// Elements captured into a set that are equal to an existing element are dropped, while those
+// captured into a sorted slice are inserted in ascending order, after any equal elements.
+// Elements of string, numeric and boolean types are compared by value. Struct elements are
+// compared by the field given with "key=<field>", or by a function registered with Comparator().
+type collection struct {
+	set, sorted bool
+	// Index of the field of struct elements they are compared by, if any.
+	key []int
+	// Compares elements, registered with Comparator(), if any.
+	compare func(a, b interface{}) int
+}
+
+// Returns true if values of type t can be compared by a collection without a Comparator().
+func isOrdered(t reflect.Type) bool {
+	switch indirectType(t).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Insert the elements of f from index from into the elements before them, returning the elements
+// that were kept.
+//
+// The elements of unsorted sets are found by key in their index in sets, if they can be. Otherwise
+// a new slice is built, as a parse backtracking over the capture restores the previous slice,
+// which must not be modified.
+func (c *collection) insert(f reflect.Value, from int, sets map[uintptr]map[interface{}]int) []reflect.Value {
+	if c.set && !c.sorted && c.compare == nil && sets != nil && f.CanAddr() {
+		return c.insertIndexed(f, from, sets)
+	}
+	out := reflect.MakeSlice(f.Type(), from, f.Len())
+	reflect.Copy(out, f.Slice(0, from))
+	kept := []reflect.Value{}
+	for i := from; i < f.Len(); i++ {
+		element := f.Index(i)
+		at := out.Len()
+		if c.sorted {
+			at = sort.Search(out.Len(), func(j int) bool { return c.compareValues(out.Index(j), element) > 0 })
+		}
+		if c.set && c.contains(out, element, at) {
+			continue
+		}
+		out = reflect.Append(out, element)
+		reflect.Copy(out.Slice(at+1, out.Len()), out.Slice(at, out.Len()-1))
+		out.Index(at).Set(element)
+		kept = append(kept, element)
+	}
+	f.Set(out)
+	return kept
+}
+
+// Insert the elements of f from index from into the unsorted set before them, finding duplicates
+// by the index of its elements in sets, keyed by the address of f.
+//
+// Elements are only appended, leaving any previous slice restored by backtracking as it was. The
+// index may still refer to elements that were backtracked over, so is checked against f.
+func (c *collection) insertIndexed(f reflect.Value, from int, sets map[uintptr]map[interface{}]int) []reflect.Value {
+	index, ok := sets[f.UnsafeAddr()]
+	if !ok {
+		index = make(map[interface{}]int, from)
+		sets[f.UnsafeAddr()] = index
+		for i := 0; i < from; i++ {
+			index[c.indexKey(f.Index(i))] = i
+		}
+	}
+	out := f.Slice(0, from)
+	kept := []reflect.Value{}
+	for i := from; i < f.Len(); i++ {
+		element := f.Index(i)
+		key := c.indexKey(element)
+		if at, ok := index[key]; ok && at < out.Len() && c.compareValues(out.Index(at), element) == 0 {
+			continue
+		}
+		index[key] = out.Len()
+		out = reflect.Append(out, element)
+		kept = append(kept, out.Index(out.Len()-1))
+	}
+	f.Set(out)
+	return kept
+}
+
+// Returns the key of v in the index of an unsorted set, nil for a nil pointer.
+func (c *collection) indexKey(v reflect.Value) interface{} {
+	if v = c.keyOf(v); v.IsValid() {
+		return v.Interface()
+	}
+	return nil
+}
+
+// Returns true if elements contains an element equal to element, which would be inserted at.
+func (c *collection) contains(elements, element reflect.Value, at int) bool {
+	if c.sorted {
+		return at > 0 && c.compareValues(elements.Index(at-1), element) == 0
+	}
+	for i := 0; i < elements.Len(); i++ {
+		if c.compareValues(elements.Index(i), element) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *collection) compareValues(a, b reflect.Value) int {
+	if c.compare != nil {
+		return c.compare(a.Interface(), b.Interface())
+	}
+	a, b = c.keyOf(a), c.keyOf(b)
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		// Nil pointers are ordered first.
+		return boolToInt(a.IsValid()) - boolToInt(b.IsValid())
+	case a.Kind() == reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case a.Kind() == reflect.Bool:
+		return boolToInt(a.Bool()) - boolToInt(b.Bool())
+	case a.Kind() >= reflect.Int && a.Kind() <= reflect.Int64:
+		return compareOrdered(a.Int() < b.Int(), a.Int() > b.Int())
+	case a.Kind() >= reflect.Uint && a.Kind() <= reflect.Uint64:
+		return compareOrdered(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+	default:
+		return compareOrdered(a.Float() < b.Float(), a.Float() > b.Float())
+	}
+}
+
+// Returns the value that v is compared by, or an invalid value if it is a nil pointer.
+func (c *collection) keyOf(v reflect.Value) reflect.Value {
+	v = indirectValue(v)
+	if c.key != nil && v.IsValid() {
+		v = indirectValue(v.FieldByIndex(c.key))
+	}
+	return v
+}
+
+// Returns the value that v points to, or an invalid value if it is a nil pointer.
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Check that the elements of field can be compared by c, once any Comparator() is bound, and are
+// not numbered by their Index.
+func (c *collection) check(field structLexerField) error {
+	if hasIndexField(field.Type.Elem()) {
+		return fmt.Errorf("opts \"set\" and \"sorted\" can not be used with elements with an Index field, as elements are reordered or dropped after it is set")
+	}
+	if c.compare != nil || c.key != nil || isOrdered(field.Type.Elem()) {
+		return nil
+	}
+	return fmt.Errorf("opts \"set\" and \"sorted\" require elements of a string, numeric or boolean type, a \"key=<field>\" or a Comparator(), not %s", field.Type.Elem())
+}
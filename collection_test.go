@@ -0,0 +1,95 @@
+package participle
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionOptions(t *testing.T) {
+	type column struct {
+		Name string `@Ident`
+		Type string `@Ident`
+	}
+	type index struct {
+		Table  string `@Ident "."`
+		Column string `@Ident`
+	}
+	type grammar struct {
+		Tags    []string  `parser:"{ \"tag\" @Ident }" opts:"set"`
+		Ports   []int     `parser:"{ \"port\" @Int }" opts:"sorted"`
+		Names   []string  `parser:"{ \"name\" @Ident }" opts:"set,sorted"`
+		Columns []*column `parser:"{ \"column\" @@ }" opts:"sorted,key=Name"`
+		Indexes []index   `parser:"{ \"index\" @@ }" opts:"set"`
+	}
+	indexes := []interface{}{}
+	p := mustTestParser(t, &grammar{},
+		Comparator("grammar.Indexes", func(a, b interface{}) int {
+			x, y := a.(index), b.(index)
+			return strings.Compare(x.Table+"."+x.Column, y.Table+"."+y.Column)
+		}),
+		OnRepeat("grammar.Indexes", func(element interface{}) { indexes = append(indexes, element) }))
+	actual := &grammar{}
+	err := p.ParseString(`
+		tag b tag a tag b
+		port 443 port 80 port 8080 port 80
+		name c name a name c name b
+		column id int column age int column created time column age text
+		index a.b index a.c index a.b
+	`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Tags:    []string{"b", "a"},
+		Ports:   []int{80, 80, 443, 8080},
+		Names:   []string{"a", "b", "c"},
+		Columns: []*column{{"age", "int"}, {"age", "text"}, {"created", "time"}, {"id", "int"}},
+		Indexes: []index{{"a", "b"}, {"a", "c"}},
+	}, actual)
+	require.Equal(t, []interface{}{index{"a", "b"}, index{"a", "c"}}, indexes)
+
+	// Elements captured by a branch that is backtracked over are not in the set.
+	type backtracked struct {
+		Tags []string `parser:"{ @Ident \"!\" | @Ident \";\" }" opts:"set"`
+	}
+	actualBacktracked := &backtracked{}
+	err = mustTestParser(t, &backtracked{}, NoLookahead()).ParseString(`a ; b ; a ; c !`, actualBacktracked)
+	require.NoError(t, err)
+	require.Equal(t, &backtracked{Tags: []string{"a", "b", "c"}}, actualBacktracked)
+
+	type numbered struct {
+		Index int
+		Name  string `@Ident`
+	}
+	type table struct {
+		Columns []*column `parser:"{ @@ }" opts:"sorted"`
+	}
+	type catalog struct {
+		Tables []*numbered `parser:"{ @@ }" opts:"sorted,key=Name"`
+	}
+	compare := func(a, b interface{}) int { return 0 }
+	invalid := []struct {
+		grammar interface{}
+		options []Option
+		err     string
+	}{
+		{&struct {
+			A string `parser:"@Ident" opts:"set"`
+		}{}, nil, `A: opts "set" requires a slice field`},
+		{&struct {
+			A []string `parser:"{ @Ident }" opts:"key=Name"`
+		}{}, nil, `A: opts "key" requires "set" or "sorted"`},
+		{&struct {
+			A []*column `parser:"{ @@ }" opts:"set,key=Size"`
+		}{}, nil, `A: opts "key" requires a field Size of the elements of A`},
+		{&table{}, nil, `table.Columns: opts "set" and "sorted" require elements of a string, numeric or boolean type, a "key=<field>" or a Comparator(), not *participle.column`},
+		{&catalog{}, nil, `catalog.Tables: opts "set" and "sorted" can not be used with elements with an Index field, as elements are reordered or dropped after it is set`},
+		{&index{}, []Option{Comparator("index.Table", compare)},
+			`Comparator() for "index.Table" requires a field tagged with opts "set" or "sorted"`},
+		{&index{}, []Option{Comparator("index.Missing", compare)}, `Comparator() for unknown captured field "index.Missing"`},
+	}
+	for _, test := range invalid {
+		_, err := Build(test.grammar, test.options...)
+		require.EqualError(t, err, test.err)
+	}
+}
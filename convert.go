@@ -0,0 +1,165 @@
+package participle
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// Converter converts the tokens matched by a capture into a value of the captured field's type.
+//
+// The tokens carry their positions, so that a Converter can report precise errors by returning
+// a *lexer.Error, eg. from lexer.Errorf(). Any other error is reported at the position of the
+// first token.
+type Converter func(tokens []lexer.Token) (interface{}, error)
+
+// Convert registers converter for captures into fields of the same type as "of", or pointers or
+// slices of it, replacing the default conversion from token values. eg.
+//
+//	participle.Convert(Duration(0), func(tokens []lexer.Token) (interface{}, error) {
+//		d, err := time.ParseDuration(tokens[0].Value)
+//		if err != nil {
+//			return nil, lexer.Errorf(tokens[0].Pos, "invalid duration %q", tokens[0].Value)
+//		}
+//		return Duration(d), nil
+//	})
+//
+// The converter is called once each time the capture matches, with all of the tokens it matched.
+// Structs with a converter may be captured without @@.
+func Convert(of interface{}, converter Converter) Option {
+	return func(p *Parser) error {
+		t := reflect.TypeOf(of)
+		if t == nil {
+			return fmt.Errorf("Convert() requires a value of the converted type")
+		}
+		p.converters[t] = converter
+		return nil
+	}
+}
+
+// RegisterStructConverter registers fn for captures into fields of the struct type t, or pointers
+// or slices of it, populating the fields of a new struct from the matched tokens, eg.
+//
+//	participle.RegisterStructConverter(reflect.TypeOf(RGB{}), func(tokens []lexer.Token, dst reflect.Value) error {
+//		rgb, err := hex.DecodeString(strings.TrimPrefix(tokens[0].Value, "#"))
+//		if err != nil || len(rgb) != 3 {
+//			return lexer.Errorf(tokens[0].Pos, "invalid colour %q", tokens[0].Value)
+//		}
+//		dst.Set(reflect.ValueOf(RGB{R: rgb[0], G: rgb[1], B: rgb[2]}))
+//		return nil
+//	})
+//
+// dst is a settable zero value of t. As with Convert(), fn is called once each time the capture
+// matches, and the struct may be captured without @@.
+func RegisterStructConverter(t reflect.Type, fn func(tokens []lexer.Token, dst reflect.Value) error) Option {
+	return func(p *Parser) error {
+		if t == nil || t.Kind() != reflect.Struct {
+			return fmt.Errorf("RegisterStructConverter() requires a struct type, not %v", t)
+		}
+		p.converters[t] = func(tokens []lexer.Token) (interface{}, error) {
+			dst := reflect.New(t).Elem()
+			if err := fn(tokens, dst); err != nil {
+				return nil, err
+			}
+			return dst.Interface(), nil
+		}
+		return nil
+	}
+}
+
+// RegisterFactory registers factory for captures into fields of type t, or pointers or slices of
+// it, constructing each value from the concatenated values of the matched tokens, eg. for
+// immutable types whose fields are unexported:
+//
+//	participle.RegisterFactory(reflect.TypeOf(money.Amount{}), func(value string) (interface{}, error) {
+//		return money.Parse(value)
+//	})
+//
+// The value returned by factory may be of any type assignable to t, such as an implementation
+// of an interface t.
+func RegisterFactory(t reflect.Type, factory func(value string) (interface{}, error)) Option {
+	return func(p *Parser) error {
+		if t == nil {
+			return fmt.Errorf("RegisterFactory() requires a type")
+		}
+		p.converters[t] = func(tokens []lexer.Token) (interface{}, error) {
+			value := ""
+			for _, token := range tokens {
+				value += token.Value
+			}
+			out, err := factory(value)
+			if err != nil {
+				return nil, err
+			}
+			if out == nil || !reflect.TypeOf(out).AssignableTo(t) {
+				return nil, fmt.Errorf("factory for %s returned %T, which is not assignable to it", t, out)
+			}
+			return out, nil
+		}
+		return nil
+	}
+}
+
+// ValuesConverter adapts a function converting the values of the captured tokens, such as an
+// implementation of the Capture interface, into a Converter.
+func ValuesConverter(convert func(values []string) (interface{}, error)) Converter {
+	return func(tokens []lexer.Token) (interface{}, error) {
+		values := make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			values = append(values, token.Value)
+		}
+		return convert(values)
+	}
+}
+
+// Delegate parses the values of the tokens captured into values of the type of of, or pointers or
+// slices of it, with sub, eg. for a language embedded within string or block tokens. sub's
+// grammar must be of the same type.
+//
+// The embedded input is parsed as if it began at the position of the first token, or after its
+// opening quote if it is an unquoted string (see Unquote()), so the positions of its tokens, and
+// of any errors, are relative to the outer input. Escape sequences within a quoted string are
+// shorter once unquoted, so positions following one on the same line are shifted. Errors are
+// reported with the production stack of the embedded grammar within that of the outer grammar.
+// The options are applied to each parse, after the base position.
+func Delegate(of interface{}, sub *Parser, options ...ParseOption) Option {
+	return func(p *Parser) error {
+		return Convert(of, func(tokens []lexer.Token) (interface{}, error) {
+			source := ""
+			for _, token := range tokens {
+				source += token.Value
+			}
+			base := tokens[0].Pos
+			if p.quotedTypes[tokens[0].Type] {
+				base.Column++
+				base.Offset++
+			}
+			options := append([]ParseOption{WithBasePosition(base)}, options...)
+			v, err := sub.parseNew(strings.NewReader(source), options)
+			if err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(v).Elem().Interface(), nil
+		})(p)
+	}
+}
+
+// Convert the tokens matched by a capture at pos into values for setField().
+func convertTokens(converter Converter, pos lexer.Position, tokens []lexer.Token) ([]reflect.Value, error) {
+	value, err := converter(tokens)
+	if err != nil {
+		if !isPositioned(err) {
+			err = lexer.Errorf(pos, "%s", err)
+		}
+		return nil, err
+	}
+	if value == nil {
+		return []reflect.Value{}, nil
+	}
+	// Addressable, so that it can also be assigned to a pointer field.
+	v := reflect.New(reflect.TypeOf(value)).Elem()
+	v.Set(reflect.ValueOf(value))
+	return []reflect.Value{v}, nil
+}
@@ -0,0 +1,71 @@
+package participle
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// CSTNode is a node in a concrete syntax tree, a lossless record of the structure of a parse.
+//
+// Rule nodes have Rule set to the name of the grammar struct they were parsed from, and hold the
+// rules and tokens matched by that struct in Children. Leaf nodes hold a single Token, with
+// Elided set if the token was dropped from the token stream before reaching the grammar.
+type CSTNode struct {
+	Rule     string
+	Token    lexer.Token
+	Elided   bool
+	Children []*CSTNode
+}
+
+// WithCST populates cst with the concrete syntax tree of the parse, in parallel to the AST.
+func WithCST(cst *CSTNode) ParseOption {
+	return func(p *parseContext) {
+		p.cst = cst
+	}
+}
+
+// Tokens returns all tokens under this node, in source order.
+func (c *CSTNode) Tokens() []lexer.Token {
+	if c.Rule == "" {
+		return []lexer.Token{c.Token}
+	}
+	out := []lexer.Token{}
+	for _, child := range c.Children {
+		out = append(out, child.Tokens()...)
+	}
+	return out
+}
+
+func (c *CSTNode) addElided(tokens []lexer.Token) {
+	for _, token := range tokens {
+		c.Children = append(c.Children, &CSTNode{Token: token, Elided: true})
+	}
+}
+
+// Copy the completed CST into cst, attaching any trailing elided tokens to the root.
+func (p parseContext) finishCST(cst *CSTNode) {
+	root := p.cst
+	if len(root.Children) == 1 && root.Children[0].Rule != "" {
+		root = root.Children[0]
+	}
+	root.addElided(p.elided[p.Cursor()])
+	*cst = *root
+}
+
+// Package paths qualifying the type arguments of an instantiated generic type name.
+var typeArgumentPackage = regexp.MustCompile(`[^\[\],*\s]+\.`)
+
+func ruleName(t reflect.Type) string {
+	t = indirectType(t)
+	if name := t.Name(); name != "" {
+		if strings.Contains(name, "[") {
+			// eg. List[github.com/user/pkg.Item] is named List[Item].
+			return typeArgumentPackage.ReplaceAllString(name, "")
+		}
+		return name
+	}
+	return t.String()
+}
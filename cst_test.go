@@ -0,0 +1,51 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+func TestCST(t *testing.T) {
+	type value struct {
+		Int int `@Int`
+	}
+	type assignment struct {
+		Key   string `@Ident "="`
+		Value *value `@@`
+	}
+	type grammar struct {
+		Assignments []*assignment `{ @@ }`
+	}
+
+	def := lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<Comment>#[^\n]*)|(?P<Int>\d+)|(?P<Ident>\w+)|(?P<Punct>=)`))
+	p := mustTestParser(t, &grammar{}, Lexer(def), Elide("Whitespace", "Comment"))
+
+	source := "a = 1 # one\nb = 2\n"
+	cst := &CSTNode{}
+	actual := &grammar{}
+	err := p.ParseString(source, actual, WithCST(cst))
+	require.NoError(t, err)
+	require.Len(t, actual.Assignments, 2)
+
+	require.Equal(t, "grammar", cst.Rule)
+	require.Len(t, cst.Children, 3)
+	require.Equal(t, "assignment", cst.Children[0].Rule)
+	require.Equal(t, "value", cst.Children[0].Children[3].Rule)
+	// Elided tokens are attached before the token they precede.
+	second := cst.Children[1]
+	require.True(t, second.Children[1].Elided)
+	require.Equal(t, "# one", second.Children[1].Token.Value)
+	require.Equal(t, "b", second.Children[3].Token.Value)
+	// Trailing elided tokens are attached to the root.
+	require.True(t, cst.Children[2].Elided)
+
+	// The CST is lossless, so concatenating all tokens reproduces the source.
+	text := ""
+	for _, token := range cst.Tokens() {
+		text += token.Value
+	}
+	require.Equal(t, source, text)
+}
@@ -6,14 +6,34 @@
 //
 //     - `@<expr>` Capture expression into the field.
 //     - `@@` Recursively capture using the fields own type.
+//     - `@@:<type>` Capture the named member of the union registered for the interface field with `Union()`.
+//     - `@<expr> -> <field>` Capture expression into the named field rather than the current one.
+//     - `@#<expr>` Increment the integer field each time the expression matches, discarding the matched values.
+//     - `@=<expr>` Capture the source text spanned by the expression, including elided tokens, into a string field.
+//     - `@( ... )` Capture a new element of the struct field, or struct slice field, each time the group matches, with captures in the group naming fields of the element with `-> <field>`.
 //     - `<identifier>` Match named lexer token.
+//     - `(<identifier> | <identifier> ...)` Match any of the named lexer tokens, as a single reference.
+//     - `$<name>` Match an identifier in the keyword set provided at parse time with `WithKeywords(<name>, ...)`.
 //     - `{ ... }` Match 0 or more times.
+//     - `{ ... / <separator> }` Match 0 or more times, separated by the separator.
+//     - `{ ... / <separator> -> <field> }` Match 0 or more times, separated by the separator, which is captured into the field.
 //     - `( ... )` Group.
+//     - `&<expr>` Match if the expression matches, without consuming input or capturing.
+//     - `!` Commit to the enclosing branch, reporting any later failure rather than backtracking.
+//     - `~<expr>` Match each token up to, but excluding, the first that begins a match of the expression, or EOF.
+//     - `ε` Match the empty string, as the last alternative of a disjunction, eg. `( A | B | ε )`.
+//     - `#<column>` or `#<min>-<max>` Match if the next token starts at the column, or within the range of columns, without consuming input.
 //     - `[ ... ]` Optional.
+//     - `[ ... ] -> <field>` Optional, setting the `bool` or `*bool` field to whether it matched.
+//     - `[ ... | default ... ]` Match the default, which may be empty, if the other alternatives do not match.
+//     - `< ... | ... >` Match each alternative at most once, in any order.
+//     - `^( ... | ... )` Match exactly one alternative, exactly once, within the enclosing repetition.
+//     - `%{ ... | ...? | ...* | ...+ }` Match members in any order, each exactly once, at most once (`?`), any number of times (`*`) or at least once (`+`).
 //     - `"..."[:<identifier>]` Match the literal, optionally specifying the exact lexer token
 //       type to match.
 //     - `<expr> <expr> ...` Match expressions.
 //     - `<expr> | <expr>` Match one of the alternatives.
+//     - `<expr> | ?<flag> <expr>` Match the alternative only if the flag is enabled at parse time with `WithFlags(<flag>)`.
 //
 // Here's an example of an EBNF grammar.
 //
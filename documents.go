@@ -0,0 +1,139 @@
+package participle
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// DocumentErrors is returned by ParseDocuments when one or more documents fail to parse.
+//
+// It is indexed by document, with a nil entry for each document that parsed successfully.
+type DocumentErrors []error
+
+func (d DocumentErrors) Error() string {
+	out := []string{}
+	for i, err := range d {
+		if err != nil {
+			out = append(out, fmt.Sprintf("document %d: %s", i, err))
+		}
+	}
+	return strings.Join(out, "; ")
+}
+
+// ParseDocuments parses a stream of independent documents separated by tokens with the value
+// separator, appending each to documents, which must be a pointer to a slice of the grammar type
+// passed to participle.Build(). Unquoted strings are never separators (see Unquote()).
+//
+// Each document is parsed from its own tokens, so a failure in one does not affect the others.
+// Positions are relative to the whole stream. Empty documents, such as those before a leading
+// separator, are skipped.
+//
+// If any document fails to parse, its partially populated value is still appended and a
+// DocumentErrors is returned.
+func (p *Parser) ParseDocuments(r io.Reader, separator string, documents interface{}, options ...ParseOption) error {
+	rv := reflect.ValueOf(documents)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem() != p.typ {
+		return fmt.Errorf("must parse into value of type *[]%s not %T", p.typ, documents)
+	}
+	slice := rv.Elem()
+	errs := DocumentErrors{}
+	failed := false
+	err := p.eachDocument(r, separator, options, func(document reflect.Value, err error) error {
+		slice.Set(reflect.Append(slice, document))
+		errs = append(errs, err)
+		failed = failed || err != nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+// ParseDocumentsFunc parses a stream of independent documents separated by a token with the value
+// separator, as with ParseDocuments, passing each document to fn as soon as it has been parsed.
+//
+// Each document is a newly allocated value of the grammar type passed to participle.Build(), and
+// no part of it, including captured slices, is shared with or modified by the parsing of later
+// documents. Documents may therefore be handed off to other goroutines from fn.
+//
+// Parsing stops at the first document that fails to parse, or the first error returned by fn,
+// and that error is returned.
+func (p *Parser) ParseDocumentsFunc(r io.Reader, separator string, fn func(document interface{}) error, options ...ParseOption) error {
+	return p.eachDocument(r, separator, options, func(document reflect.Value, err error) error {
+		if err != nil {
+			return err
+		}
+		return fn(document.Interface())
+	})
+}
+
+// Parse each document in r, passing it and any parse error to fn.
+func (p *Parser) eachDocument(r io.Reader, separator string, options []ParseOption, fn func(document reflect.Value, err error) error) error {
+	if err := p.resolve(); err != nil {
+		return err
+	}
+	r, options, err := p.bufferSource(r, options)
+	if err != nil {
+		return err
+	}
+	baseLexer, err := p.lex.Lex(r)
+	if err != nil {
+		return err
+	}
+	lex := lexer.Buffer(baseLexer)
+	for {
+		tokens, end, err := nextDocument(lex, separator, p.quotedTypes)
+		if err != nil {
+			return err
+		}
+		if len(tokens) > 0 {
+			document := reflect.New(p.typ.Elem())
+			lex, err := p.parseLexer(&tokenLexer{tokens: tokens, eof: lexer.EOFToken(end.Pos)}, document.Interface(), options, false)
+			p.release(lex)
+			if err := fn(document, err); err != nil {
+				return err
+			}
+		}
+		if end.EOF() {
+			return nil
+		}
+	}
+}
+
+// Consume the tokens of the next document, returning them along with the separator or EOF that
+// terminated it.
+func nextDocument(lex *lexer.BufferedLexer, separator string, quoted map[rune]bool) (tokens []lexer.Token, end lexer.Token, err error) {
+	for {
+		token, err := lex.Next()
+		if err != nil {
+			return nil, token, err
+		}
+		if token.EOF() || isValue(quoted, token, separator) {
+			return tokens, token, nil
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+// A Lexer over a fixed set of tokens.
+type tokenLexer struct {
+	tokens []lexer.Token
+	eof    lexer.Token
+}
+
+func (t *tokenLexer) Next() (lexer.Token, error) {
+	if len(t.tokens) == 0 {
+		return t.eof, nil
+	}
+	token := t.tokens[0]
+	t.tokens = t.tokens[1:]
+	return token, nil
+}
@@ -0,0 +1,96 @@
+package participle
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+func TestParseDocuments(t *testing.T) {
+	type assignment struct {
+		Pos   lexer.Position
+		Key   string `@Ident "="`
+		Value int    `@Int`
+	}
+	type document struct {
+		Assignments []*assignment `{ @@ }`
+	}
+	p := mustTestParser(t, &document{})
+
+	documents := []*document{}
+	err := p.ParseDocuments(strings.NewReader("; a = 1 b = 2 ;\nc = 3"), ";", &documents)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	require.Len(t, documents[0].Assignments, 2)
+	require.Equal(t, "c", documents[1].Assignments[0].Key)
+	require.Equal(t, 2, documents[1].Assignments[0].Pos.Line)
+
+	documents = []*document{}
+	err = p.ParseDocuments(strings.NewReader("a = 1 ; b = ; c = 3"), ";", &documents)
+	require.Error(t, err)
+	errs, ok := err.(DocumentErrors)
+	require.True(t, ok)
+	require.Len(t, errs, 3)
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	require.NoError(t, errs[2])
+	require.Equal(t, 3, documents[2].Assignments[0].Value)
+
+	err = p.ParseDocuments(strings.NewReader(""), ";", &[]document{})
+	require.Error(t, err)
+
+	// Unquoted strings are not separators.
+	type values struct {
+		Values []string `{ @Ident | @String }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Ident>[a-z]+)|(?P<String>"[^"]*")|(?P<Punct>;)`))
+	for _, options := range [][]Option{nil, {Lexer(lex), Unquote()}} {
+		p = mustTestParser(t, &values{}, options...)
+		valueDocuments := []*values{}
+		err = p.ParseDocuments(strings.NewReader(`a ";" b ; c`), ";", &valueDocuments)
+		require.NoError(t, err)
+		require.Equal(t, []*values{{Values: []string{"a", ";", "b"}}, {Values: []string{"c"}}}, valueDocuments)
+	}
+}
+
+func TestParseDocumentsFunc(t *testing.T) {
+	type document struct {
+		Values []int `{ @Int }`
+	}
+	p := mustTestParser(t, &document{})
+
+	// Consumers read each document concurrently with the parsing of later documents.
+	delivered := make(chan *document, 3)
+	done := make(chan [][]int)
+	go func() {
+		received := [][]int{}
+		for document := range delivered {
+			received = append(received, document.Values)
+		}
+		done <- received
+	}()
+	documents := []*document{}
+	err := p.ParseDocumentsFunc(strings.NewReader("1 2 3 ; 4 5 ; 6"), ";", func(v interface{}) error {
+		document := v.(*document)
+		documents = append(documents, document)
+		delivered <- document
+		return nil
+	})
+	require.NoError(t, err)
+	close(delivered)
+	require.Equal(t, [][]int{{1, 2, 3}, {4, 5}, {6}}, <-done)
+	require.Equal(t, []int{1, 2, 3}, documents[0].Values)
+	require.Equal(t, []int{4, 5}, documents[1].Values)
+	require.True(t, &documents[0].Values[0] != &documents[1].Values[0])
+
+	count := 0
+	err = p.ParseDocumentsFunc(strings.NewReader("1 ; a ; 3"), ";", func(v interface{}) error {
+		count++
+		return nil
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, count)
+}
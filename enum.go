@@ -0,0 +1,85 @@
+package participle
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// The set of values a field type registered with Enum() may capture.
+type enum struct {
+	name    string
+	values  []string
+	allowed map[string]bool
+}
+
+func newEnum(t reflect.Type, values []string) *enum {
+	e := &enum{name: t.Name(), values: values, allowed: map[string]bool{}}
+	for _, value := range values {
+		e.allowed[value] = true
+	}
+	return e
+}
+
+func (e *enum) validate(pos lexer.Position, values []reflect.Value) error {
+	for _, v := range values {
+		if v.Kind() != reflect.String {
+			continue
+		}
+		if !e.allowed[v.String()] {
+			quoted := make([]string, 0, len(e.values))
+			for _, value := range e.values {
+				quoted = append(quoted, fmt.Sprintf("%q", value))
+			}
+			return lexer.Errorf(pos, "invalid %s %q, expected one of %s", e.name, v.String(), strings.Join(quoted, ", "))
+		}
+	}
+	return nil
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	return (kind >= reflect.Int && kind <= reflect.Int64) || (kind >= reflect.Uint && kind <= reflect.Uint64)
+}
+
+// Converts the literal matched by a capture into its value registered with RegisterEnum().
+func enumConverter(t reflect.Type, values map[string]int) Converter {
+	return func(tokens []lexer.Token) (interface{}, error) {
+		value := ""
+		for _, token := range tokens {
+			value += token.Value
+		}
+		n, ok := values[value]
+		if !ok {
+			return nil, lexer.Errorf(tokens[0].Pos, "invalid %s %q", t.Name(), value)
+		}
+		return reflect.ValueOf(n).Convert(t).Interface(), nil
+	}
+}
+
+// Returns an error if a capture into a type registered with RegisterEnum() can match a literal
+// that has no value.
+func (p *Parser) checkEnumValues() error {
+	if len(p.enumValues) == 0 {
+		return nil
+	}
+	return visitCaptures(p.root, func(rule string, c *capture) error {
+		t := indirectType(c.field.Type)
+		values, ok := p.enumValues[t]
+		if !ok || c.convert == nil {
+			return nil
+		}
+		return visit(c.node, func(n node, next func() error) error {
+			switch n := n.(type) {
+			case *strct:
+				return nil
+			case *literal:
+				if _, ok := values[n.s]; !ok {
+					return fmt.Errorf("%s.%s: literal %q has no value registered with RegisterEnum() for %s", rule, c.field.Name, n.s, t)
+				}
+			}
+			return next()
+		})
+	})
+}
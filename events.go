@@ -0,0 +1,172 @@
+package participle
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// EventHandler receives the structure of a parse as a flat stream of events, from ParseEvents().
+//
+// Each grammar struct matched is reported by StartRule, followed by the events for the tokens and
+// structs it matched, then EndRule. Rules are named as in FirstSets().
+type EventHandler interface {
+	// StartRule is called with the position of the first token of a rule.
+	StartRule(name string, pos lexer.Position)
+	// Token is called for each token consumed by the grammar, excluding elided tokens.
+	Token(token lexer.Token)
+	// EndRule is called with the position of the token following a rule.
+	EndRule(name string, pos lexer.Position)
+}
+
+// ParseEvents parses r, reporting the structure of the parse to handler as it proceeds rather
+// than returning it as a value.
+//
+// A rule is only reported once a token within it is consumed, or once it has matched if it is
+// empty, so that rules attempted but not matched are not reported. Events are delivered as the
+// input is parsed, except with NoLookahead() or Recover(), where events are held back until they
+// can no longer be discarded by backtracking: the end of the parse or of each statement
+// respectively. If parsing fails, the events up to the failure will have been delivered.
+//
+// The input is parsed as by Parse(), so the same input is accepted, but captured values are neither
+// converted nor assigned, so no AST is built and memory use does not grow with the size of the
+// input. Errors from converting values, such as an integer out of range, and from Require() are
+// therefore not reported.
+func (p *Parser) ParseEvents(r io.Reader, handler EventHandler, options ...ParseOption) error {
+	if err := p.resolve(); err != nil {
+		return err
+	}
+	if p.typ.Kind() != reflect.Ptr {
+		return fmt.Errorf("grammar must be a pointer to a struct, not %s", p.typ)
+	}
+	options = append(options[:len(options):len(options)], func(ctx *parseContext) {
+		ctx.events = &eventRecorder{handler: handler}
+	})
+	return p.Parse(r, reflect.New(p.typ.Elem()).Interface(), options...)
+}
+
+// ParseEventsString is a convenience around ParseEvents().
+func (p *Parser) ParseEventsString(s string, handler EventHandler, options ...ParseOption) error {
+	return p.ParseEvents(strings.NewReader(s), handler, options...)
+}
+
+type eventKind int
+
+const (
+	startRuleEvent eventKind = iota
+	tokenEvent
+	endRuleEvent
+)
+
+type event struct {
+	kind  eventKind
+	name  string
+	token lexer.Token
+}
+
+// A rule that has been entered but not yet exited.
+type openRule struct {
+	name    string
+	pos     lexer.Position
+	started bool
+}
+
+// Translates the progress of a parse into events for an EventHandler.
+type eventRecorder struct {
+	handler EventHandler
+	open    []openRule
+	// If true, events are buffered until flush() rather than delivered immediately.
+	buffered bool
+	events   []event
+	// The value parsed into for each grammar struct type. Nothing is assigned to the structs of an
+	// event parse, so a single value serves for every match of a type.
+	values map[reflect.Type]reflect.Value
+}
+
+// The value to parse a grammar struct of type t into.
+func (e *eventRecorder) value(t reflect.Type) reflect.Value {
+	v, ok := e.values[t]
+	if !ok {
+		if e.values == nil {
+			e.values = map[reflect.Type]reflect.Value{}
+		}
+		v = reflect.New(t).Elem()
+		e.values[t] = v
+	}
+	return v
+}
+
+// Enter a rule at pos, returning its depth for exit().
+func (e *eventRecorder) enter(name string, pos lexer.Position) int {
+	e.open = append(e.open, openRule{name: name, pos: pos})
+	return len(e.open)
+}
+
+// Exit the rule at depth, reporting it if it matched.
+func (e *eventRecorder) exit(depth int, matched bool, end lexer.Position) {
+	if len(e.open) < depth {
+		// Discarded by backtracking.
+		return
+	}
+	e.open = e.open[:depth]
+	if matched {
+		e.start()
+		e.emit(event{kind: endRuleEvent, name: e.open[depth-1].name, token: lexer.Token{Pos: end}})
+	}
+	e.open = e.open[:depth-1]
+}
+
+func (e *eventRecorder) token(token lexer.Token) {
+	e.start()
+	e.emit(event{kind: tokenEvent, token: token})
+}
+
+// Report the start of each open rule not yet reported.
+func (e *eventRecorder) start() {
+	for i := range e.open {
+		if !e.open[i].started {
+			e.open[i].started = true
+			e.emit(event{kind: startRuleEvent, name: e.open[i].name, token: lexer.Token{Pos: e.open[i].pos}})
+		}
+	}
+}
+
+func (e *eventRecorder) emit(ev event) {
+	if e.buffered {
+		e.events = append(e.events, ev)
+		return
+	}
+	e.deliver(ev)
+}
+
+func (e *eventRecorder) deliver(ev event) {
+	switch ev.kind {
+	case startRuleEvent:
+		e.handler.StartRule(ev.name, ev.token.Pos)
+	case tokenEvent:
+		e.handler.Token(ev.token)
+	case endRuleEvent:
+		e.handler.EndRule(ev.name, ev.token.Pos)
+	}
+}
+
+// Deliver any buffered events.
+func (e *eventRecorder) flush() {
+	for _, ev := range e.events {
+		e.deliver(ev)
+	}
+	e.events = e.events[:0]
+}
+
+// Record the state of the recorder, returning a function that restores it.
+func (e *eventRecorder) checkpoint() (restore func()) {
+	open := append([]openRule(nil), e.open...)
+	events := len(e.events)
+	return func() {
+		e.open = append(e.open[:0], open...)
+		e.events = e.events[:events]
+	}
+}
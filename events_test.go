@@ -0,0 +1,112 @@
+package participle
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+type eventLog []string
+
+func (e *eventLog) StartRule(name string, pos lexer.Position) {
+	*e = append(*e, fmt.Sprintf("start %s %d", name, pos.Offset))
+}
+
+func (e *eventLog) Token(token lexer.Token) { *e = append(*e, token.Value) }
+
+func (e *eventLog) EndRule(name string, pos lexer.Position) {
+	*e = append(*e, fmt.Sprintf("end %s %d", name, pos.Offset))
+}
+
+// Samples the live heap when the given numbers of tokens have been reported.
+type heapSampler struct {
+	tokens  int
+	samples map[int]uint64
+}
+
+func (h *heapSampler) StartRule(name string, pos lexer.Position) {}
+
+func (h *heapSampler) Token(token lexer.Token) {
+	h.tokens++
+	if _, ok := h.samples[h.tokens]; ok {
+		runtime.GC()
+		stats := runtime.MemStats{}
+		runtime.ReadMemStats(&stats)
+		h.samples[h.tokens] = stats.HeapAlloc
+	}
+}
+
+func (h *heapSampler) EndRule(name string, pos lexer.Position) {}
+
+func TestParseEvents(t *testing.T) {
+	type call struct {
+		Name string `@Ident "(" ")"`
+	}
+	type assign struct {
+		Name  string `@Ident "="`
+		Value int    `@Int`
+	}
+	type statement struct {
+		Call   *call   `  @@`
+		Assign *assign `| @@`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ ";" }`
+	}
+	expected := eventLog{
+		"start grammar 0",
+		"start statement 0", "start call 0", "f", "(", ")", "end call 3", "end statement 3", ";",
+		"start statement 5", "start assign 5", "a", "=", "1", "end assign 10", "end statement 10", ";",
+		"end grammar 11",
+	}
+	for _, options := range [][]Option{{UseLookahead(), MaxLookaheadTable(2)}, {NoLookahead()}, {LongestMatch()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		events := eventLog{}
+		err := p.ParseEventsString(`f(); a = 1;`, &events)
+		require.NoError(t, err)
+		require.Equal(t, expected, events)
+	}
+
+	p := mustTestParser(t, &grammar{}, UseLookahead(), MaxLookaheadTable(2))
+	events := eventLog{}
+	err := p.ParseEventsString(`f(); a = ;`, &events)
+	require.EqualError(t, err, `<source>:1:10: unexpected ";" (expected <int>)`)
+	require.Equal(t, eventLog{
+		"start grammar 0",
+		"start statement 0", "start call 0", "f", "(", ")", "end call 3", "end statement 3", ";",
+		"start statement 5", "start assign 5", "a", "=",
+	}, events)
+
+	p = mustTestParser(t, &grammar{}, Recover(";"))
+	events = eventLog{}
+	err = p.ParseEventsString(`a = ; f();`, &events)
+	require.Error(t, err)
+	require.Equal(t, eventLog{
+		"start grammar 0",
+		"start statement 6", "start call 6", "f", "(", ")", "end call 9", "end statement 9", ";",
+		"end grammar 10",
+	}, events)
+}
+
+func TestParseEventsDoesNotRetainValues(t *testing.T) {
+	type assign struct {
+		Name  string `@Ident "="`
+		Value int    `@Int`
+	}
+	type grammar struct {
+		Statements []*assign `{ @@ ";" }`
+	}
+	p := mustTestParser(t, &grammar{})
+	input := strings.Repeat("abc = 123;\n", 50000)
+	sampler := &heapSampler{samples: map[int]uint64{4000: 0, 200000: 0}}
+	err := p.ParseEventsString(input, sampler)
+	require.NoError(t, err)
+	// The further 48000 statements would retain megabytes if parsed into a value.
+	growth := int64(sampler.samples[200000]) - int64(sampler.samples[4000])
+	require.True(t, growth < 256*1024, "heap grew by %d bytes", growth)
+}
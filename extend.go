@@ -0,0 +1,137 @@
+package participle
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// Extend adds grammar as an additional alternative to the grammar of the struct named rule.
+//
+// The alternative is parsed as if it were part of rule's grammar, with captures assigned to
+// field unless an explicit target is given with "-> <field>". For example, given:
+//
+// 		type Value struct {
+// 			Int   int    `  @Int`
+// 			Str   string `| @String`
+// 			Ident string
+// 		}
+//
+// the following allows a Value to also be an identifier:
+//
+// 		err := parser.Extend("Value", "Ident", `@Ident`)
+//
+// Only the lookahead tables affected by the new alternative are rebuilt. Extend must not be
+// called concurrently with parsing.
+func (p *Parser) Extend(rule, field, grammar string) error {
+	if err := p.resolve(); err != nil {
+		return err
+	}
+	var target *strct
+	_ = visit(p.root, func(n node, next func() error) error {
+		if s, ok := n.(*strct); ok && target == nil && ruleName(s.typ) == rule {
+			target = s
+		}
+		return next()
+	})
+	if target == nil {
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	f, err := lookupField(target.typ, field)
+	if err != nil {
+		return err
+	}
+	slexer := &structLexer{s: target.typ, indexes: [][]int{f.Index}, lexer: lexer.Upgrade(lexer.LexString(grammar))}
+	branch, err := p.generator.parseDisjunction(slexer)
+	if err != nil {
+		return fmt.Errorf("%s: %s", rule, err)
+	}
+	if branch == nil {
+		return fmt.Errorf("%s: no grammar found in extension", rule)
+	}
+	if token, _ := slexer.Peek(); !token.EOF() {
+		return fmt.Errorf("%s: unexpected input %q in extension", rule, token.Value)
+	}
+
+	// Lookahead tables of the rule and everything that can reach it may change, everything
+	// else is left as is.
+	unaffected := unaffectedBy(p.root, target)
+
+	expr := target.expr
+	var nodes []node
+	var flags []string
+	if d, ok := target.expr.(*disjunction); ok {
+		nodes, flags = d.nodes, d.flags
+		d.nodes = append(d.nodes[:len(d.nodes):len(d.nodes)], branch)
+		if d.flags != nil {
+			d.flags = append(d.flags[:len(d.flags):len(d.flags)], "")
+		}
+	} else {
+		target.expr = &disjunction{nodes: []node{target.expr, branch}}
+	}
+	if err := p.finaliseExtension(unaffected); err != nil {
+		// Take the extension back out, leaving the parser as it was.
+		target.expr = expr
+		if d, ok := expr.(*disjunction); ok {
+			d.nodes, d.flags = nodes, flags
+		}
+		if rerr := p.finaliseExtension(unaffectedBy(p.root, target)); rerr != nil {
+			return fmt.Errorf("%s: %s (restoring grammar: %s)", rule, err, rerr)
+		}
+		return fmt.Errorf("%s: %s", rule, err)
+	}
+	return nil
+}
+
+// Checks and binds the extended grammar as Build does, then rebuilds the lookahead tables of all
+// but the unaffected nodes.
+func (p *Parser) finaliseExtension(unaffected map[node]bool) error {
+	if err := p.finalise(); err != nil {
+		return err
+	}
+	if p.useLookahead && !p.backtrack {
+		b := &lookaheadBuilder{seen: unaffected, max: p.maxLookahead, report: p.reportLookahead, root: p.root}
+		return b.apply(p.root)
+	}
+	return nil
+}
+
+// Returns the nodes whose lookahead tables do not depend on the grammar of target.
+func unaffectedBy(root node, target *strct) map[node]bool {
+	out := map[node]bool{}
+	_ = visit(root, func(n node, next func() error) error {
+		out[n] = true
+		return next()
+	})
+	for n := range ancestors(root, target) {
+		delete(out, n)
+	}
+	delete(out, target.expr)
+	return out
+}
+
+// Returns the set of nodes reachable from root that can reach target, including target.
+func ancestors(root, target node) map[node]bool {
+	parents := map[node][]node{}
+	_ = visit(root, func(n node, next func() error) error {
+		for _, child := range children(n) {
+			if child != nil {
+				parents[child] = append(parents[child], n)
+			}
+		}
+		return next()
+	})
+	out := map[node]bool{target: true}
+	queue := []node{target}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, parent := range parents[n] {
+			if !out[parent] {
+				out[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return out
+}
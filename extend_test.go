@@ -0,0 +1,88 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtend(t *testing.T) {
+	type value struct {
+		Int   int    `  @Int`
+		Str   string `| @String`
+		Ident string
+		Neg   bool
+	}
+	type list struct {
+		Values []*value `"[" { @@ } "]"`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &list{}, options...)
+		err := p.ParseString(`[1 foo]`, &list{})
+		require.Error(t, err)
+
+		err = p.Extend("value", "Ident", `@Ident`)
+		require.NoError(t, err)
+		err = p.Extend("value", "Neg", `@"-" @Int -> Int`)
+		require.NoError(t, err)
+
+		actual := &list{}
+		err = p.ParseString(`[1 "a" foo - 2]`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &list{Values: []*value{{Int: 1}, {Str: "a"}, {Ident: "foo"}, {Int: 2, Neg: true}}}, actual)
+
+		require.Error(t, p.Extend("missing", "Ident", `@Ident`))
+		require.Error(t, p.Extend("value", "Missing", `@Ident`))
+		require.Error(t, p.Extend("value", "Ident", `@Ident ]`))
+	}
+}
+
+func TestExtendRejectsNullableRepetition(t *testing.T) {
+	type value struct {
+		Int   int `  @Int`
+		Names []string
+	}
+	type list struct {
+		Values []*value `"[" { @@ } "]"`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &list{}, options...)
+		err := p.Extend("value", "Names", `"x" { [ @Ident ] }`)
+		require.Error(t, err)
+
+		actual := &list{}
+		err = p.ParseString(`[1 2]`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &list{Values: []*value{{Int: 1}, {Int: 2}}}, actual)
+		require.Error(t, p.ParseString(`[1 x]`, &list{}))
+	}
+}
+
+func TestExtendAppliesOptions(t *testing.T) {
+	type value struct {
+		Int int `  @Int`
+		Neg bool
+	}
+	type list struct {
+		Values []*value `"[" { @@ } "]"`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		options = append(options, Annotate("value.Int", "number"), ErrorMessage("value", "Int", "expected a number"))
+		p := mustTestParser(t, &list{}, options...)
+		err := p.Extend("value", "Neg", `@"-" @Int -> Int`)
+		require.NoError(t, err)
+
+		annotations := []Annotation{}
+		actual := &list{}
+		err = p.ParseString(`[1 - 2]`, actual, WithAnnotationHook(func(annotation Annotation) {
+			annotations = append(annotations, annotation)
+		}))
+		require.NoError(t, err)
+		require.Equal(t, &list{Values: []*value{{Int: 1}, {Int: 2, Neg: true}}}, actual)
+		require.Len(t, annotations, 2)
+		require.Equal(t, "number", annotations[1].Metadata)
+
+		err = p.ParseString(`[1 - x]`, &list{})
+		require.EqualError(t, err, `<source>:1:6: expected a number`)
+	}
+}
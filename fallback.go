@@ -0,0 +1,66 @@
+package participle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// FallbackError is returned by ParseWithFallback() when neither parser succeeds.
+type FallbackError struct {
+	// Primary is the error from the primary parser.
+	Primary error
+	// Fallback is the error from the fallback parser.
+	Fallback error
+}
+
+func (f *FallbackError) Error() string {
+	return fmt.Sprintf("%s (fallback: %s)", f.Primary, f.Fallback)
+}
+
+// ParseWithFallback parses r with primary and, if that fails, parses it again from the start with
+// fallback, eg. a more lenient grammar for tolerant tooling.
+//
+// It returns a pointer to a new value of the grammar type of whichever parser succeeded, which can
+// be distinguished with a type switch. The input is buffered so that it can be lexed twice, and
+// options are applied to each parse. If both fail, a *FallbackError is returned.
+func ParseWithFallback(primary, fallback *Parser, r io.Reader, options ...ParseOption) (interface{}, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	name := lexer.NameOfReader(r)
+	v, primaryErr := primary.parseNew(&namedReader{Reader: bytes.NewReader(source), name: name}, options)
+	if primaryErr == nil {
+		return v, nil
+	}
+	v, fallbackErr := fallback.parseNew(&namedReader{Reader: bytes.NewReader(source), name: name}, options)
+	if fallbackErr == nil {
+		return v, nil
+	}
+	return nil, &FallbackError{Primary: primaryErr, Fallback: fallbackErr}
+}
+
+// Parse r into a new value of the grammar type.
+func (p *Parser) parseNew(r io.Reader, options []ParseOption) (interface{}, error) {
+	if p.typ.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("grammar must be a pointer to a struct, not %s", p.typ)
+	}
+	v := reflect.New(p.typ.Elem()).Interface()
+	if err := p.Parse(r, v, options...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// A reader retaining the name of the reader it buffers, for positions.
+type namedReader struct {
+	*bytes.Reader
+	name string
+}
+
+func (n *namedReader) Name() string { return n.name }
@@ -0,0 +1,99 @@
+package participle
+
+import (
+	"reflect"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// FindNode returns the innermost struct in the tree rooted at ast whose span contains the byte
+// offset, and the rule names of the structs spanning it from the root down to that struct.
+//
+// Spans are recorded by the Pos and EndPos fields of each struct, as a span starts at Pos and
+// ends before EndPos. Structs without both fields are searched but are never themselves found.
+// The node returned is a pointer to the struct, or nil if no struct spans the offset.
+//
+// This can be used to implement eg. hover or "go to definition" in an editor.
+func FindNode(ast interface{}, offset int) (path []string, node interface{}) {
+	return findNode(reflect.ValueOf(ast), offset)
+}
+
+// Search v for the innermost struct spanning offset.
+//
+// As the spans of sibling structs do not overlap, the structs spanning offset are each within the
+// last, so together form the path to the innermost.
+func findNode(v reflect.Value, offset int) (path []string, node interface{}) {
+	walkAST(v, func(v reflect.Value) bool {
+		start, end, ok := span(v)
+		if !ok {
+			return true
+		}
+		if offset < start.Offset || offset >= end.Offset {
+			return false
+		}
+		path = append(path, ruleName(v.Type()))
+		node = addressOf(v)
+		return true
+	})
+	if node == nil {
+		return nil, nil
+	}
+	return path, node
+}
+
+// Calls fn for each struct reachable from v through pointers, interfaces, slices, arrays and
+// exported fields, before the structs within it, which are skipped if fn returns false. Each
+// pointer is followed at most once.
+func walkAST(v reflect.Value, fn func(reflect.Value) bool) {
+	seen := map[uintptr]bool{}
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if v.IsNil() || seen[v.Pointer()] {
+				return
+			}
+			seen[v.Pointer()] = true
+			walk(v.Elem())
+
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+
+		case reflect.Struct:
+			if !fn(v) {
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).PkgPath == "" {
+					walk(v.Field(i))
+				}
+			}
+		}
+	}
+	walk(v)
+}
+
+// Returns a pointer to the struct v if it is addressable, or else its value.
+func addressOf(v reflect.Value) interface{} {
+	if v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	return v.Interface()
+}
+
+// Returns the span of a struct, if it has Pos and EndPos fields.
+func span(v reflect.Value) (start, end lexer.Position, ok bool) {
+	pos := v.FieldByName("Pos")
+	endPos := v.FieldByName("EndPos")
+	if !pos.IsValid() || pos.Type() != positionType || !endPos.IsValid() || endPos.Type() != positionType {
+		return start, end, false
+	}
+	return pos.Interface().(lexer.Position), endPos.Interface().(lexer.Position), true
+}
@@ -1,5 +1,7 @@
 module github.com/alecthomas/participle
 
+go 1.18
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -3,7 +3,12 @@ package participle
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"text/scanner"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/alecthomas/participle/lexer"
 )
@@ -12,6 +17,19 @@ type generatorContext struct {
 	lexer.Definition
 	typeNodes    map[reflect.Type]node
 	symbolsToIDs map[rune]string
+	enums        map[reflect.Type]*enum
+	converters   map[reflect.Type]Converter
+	unions       map[reflect.Type][]reflect.Type
+	unionTags    map[reflect.Type][]string
+	precedence   map[reflect.Type][]Operator
+	join         stringJoin
+	numbers      *numberFormat
+	// Fold a sign preceding numeric references captured into signed fields.
+	signedNumbers bool
+	// True if the grammar captures source text with @=, requiring the input to be buffered.
+	rawCaptures bool
+	// The element type of the innermost enclosing @( ... ) group, if any.
+	element reflect.Type
 }
 
 func newGeneratorContext(lex lexer.Definition) *generatorContext {
@@ -22,6 +40,16 @@ func newGeneratorContext(lex lexer.Definition) *generatorContext {
 	}
 }
 
+// Sorted names of the token types defined by the lexer, for use in errors.
+func (g *generatorContext) symbolNames() string {
+	names := []string{}
+	for name := range g.Symbols() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 // Takes a type and builds a tree of nodes out of it.
 func (g *generatorContext) parseType(t reflect.Type) (_ node, returnedError error) {
 	rt := t
@@ -35,6 +63,26 @@ func (g *generatorContext) parseType(t reflect.Type) (_ node, returnedError erro
 	if reflect.PtrTo(rt).Implements(parseableType) {
 		return &parseable{rt}, nil
 	}
+	if members, ok := g.unions[t]; ok {
+		out := &union{typ: t, members: members}
+		g.typeNodes[t] = out
+		for i, member := range members {
+			n, err := g.parseMember(t, i, member)
+			if err != nil {
+				return nil, err
+			}
+			out.addMember(n, g.unionTag(t, i))
+		}
+		return out, nil
+	}
+	if operators, ok := g.precedence[t]; ok {
+		out := newPrecedence(t, operators)
+		g.typeNodes[t] = out
+		if err := g.parseStruct(out.expr); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
 	switch t.Kind() {
 	case reflect.Slice, reflect.Ptr:
 		t = indirectType(t.Elem())
@@ -44,60 +92,99 @@ func (g *generatorContext) parseType(t reflect.Type) (_ node, returnedError erro
 		fallthrough
 
 	case reflect.Struct:
-		slexer, err := lexStruct(t)
-		if err != nil {
-			return nil, err
-		}
 		out := &strct{typ: t}
 		g.typeNodes[t] = out // Ensure we avoid infinite recursion.
-		if slexer.NumField() == 0 {
-			return nil, fmt.Errorf("can not parse into empty struct %s", t)
-		}
-		defer decorate(&returnedError, func() string { return slexer.Field().Name })
-		e, err := g.parseDisjunction(slexer)
-		if err != nil {
+		if err := g.parseStruct(out); err != nil {
 			return nil, err
 		}
-		if e == nil {
-			return nil, fmt.Errorf("no grammar found in %s", t)
-		}
-		if token, _ := slexer.Peek(); !token.EOF() {
-			return nil, fmt.Errorf("unexpected input %q", token.Value)
-		}
-		out.expr = e
 		return out, nil
 	}
 	return nil, fmt.Errorf("%s should be a struct or should implement the Parseable interface", t)
 }
 
+// Parse the grammar of a struct from its tags.
+func (g *generatorContext) parseStruct(out *strct) (returnedError error) {
+	slexer, err := lexStruct(out.typ)
+	if err != nil {
+		return err
+	}
+	if slexer.NumField() == 0 {
+		return fmt.Errorf("can not parse into empty struct %s", out.typ)
+	}
+	defer decorate(&returnedError, func() string { return slexer.Field().Name })
+	e, err := g.parseDisjunction(slexer)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return fmt.Errorf("no grammar found in %s", out.typ)
+	}
+	if token, _ := slexer.Peek(); !token.EOF() {
+		return fmt.Errorf("unexpected input %q", token.Value)
+	}
+	out.expr = e
+	return nil
+}
+
 func (g *generatorContext) parseDisjunction(slexer *structLexer) (node, error) {
 	out := &disjunction{}
+	flagged := false
 	for {
+		flag, err := g.parseFlag(slexer)
+		if err != nil {
+			return nil, err
+		}
 		n, err := g.parseSequence(slexer)
 		if err != nil {
 			return nil, err
 		}
 		out.nodes = append(out.nodes, n)
+		out.flags = append(out.flags, flag)
+		flagged = flagged || flag != ""
 		if token, _ := slexer.Peek(); token.Type != '|' {
 			break
 		}
+		if _, ok := n.(*epsilon); ok {
+			return nil, fmt.Errorf("ε must be the last alternative of a disjunction")
+		}
 		_, err = slexer.Next() // |
 		if err != nil {
 			return nil, err
 		}
 	}
-	if len(out.nodes) == 1 {
+	if !flagged {
+		out.flags = nil
+	}
+	if len(out.nodes) == 1 && !flagged {
 		return out.nodes[0], nil
 	}
 	return out, nil
 }
 
+// Parse an optional "?<flag>" prefix of an alternative, which is only matched if the flag is
+// enabled with WithFlags().
+func (g *generatorContext) parseFlag(slexer *structLexer) (string, error) {
+	if token, _ := slexer.Peek(); token.Type != '?' {
+		return "", nil
+	}
+	_, _ = slexer.Next() // ?
+	token, err := slexer.Next()
+	if err != nil {
+		return "", err
+	}
+	if token.Type != scanner.Ident {
+		return "", fmt.Errorf("expected flag name after ? but got %q", token)
+	}
+	return token.Value, nil
+}
+
 func (g *generatorContext) parseSequence(slexer *structLexer) (node, error) {
 	head := &sequence{}
 	cursor := head
 loop:
 	for {
-		if token, err := slexer.Peek(); err != nil {
+		token, err := slexer.Peek()
+		if err != nil {
 			return nil, err
 		} else if token.Type == lexer.EOF {
 			break loop
@@ -109,11 +196,15 @@ loop:
 		if term == nil {
 			break loop
 		}
+		versioned, err := g.parseVersion(slexer, term, token.Pos.Line-1)
+		if err != nil {
+			return nil, err
+		}
 		if cursor.node == nil {
 			cursor.head = true
-			cursor.node = term
+			cursor.node = versioned
 		} else {
-			cursor.next = &sequence{node: term}
+			cursor.next = &sequence{node: versioned}
 			cursor = cursor.next
 		}
 
@@ -142,6 +233,41 @@ loop:
 	return head, nil
 }
 
+// Wrap term, parsed from the tags of fields start to the current field, in a versioned node if
+// the fields are tagged with since:"<version>".
+func (g *generatorContext) parseVersion(slexer *structLexer, term node, start int) (node, error) {
+	since, ok, err := fieldVersion(slexer.GetField(start))
+	if err != nil {
+		return nil, err
+	}
+	if start != slexer.field {
+		_, endOk, err := fieldVersion(slexer.Field())
+		if err != nil {
+			return nil, err
+		}
+		if ok || endOk {
+			return nil, fmt.Errorf("the grammar of a field tagged with since must consist of complete terms")
+		}
+	}
+	if !ok {
+		return term, nil
+	}
+	return &versioned{node: term, since: since}, nil
+}
+
+// Returns the version parsed from the since tag of field, if it has one.
+func fieldVersion(field structLexerField) (int, bool, error) {
+	tag, ok := field.Tag.Lookup("since")
+	if !ok {
+		return 0, false, nil
+	}
+	since, err := strconv.Atoi(tag)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid since %q, expected an integer version", tag)
+	}
+	return since, true, nil
+}
+
 func (g *generatorContext) parseTerm(slexer *structLexer) (node, error) {
 	r, err := slexer.Peek()
 	if err != nil {
@@ -158,7 +284,28 @@ func (g *generatorContext) parseTerm(slexer *structLexer) (node, error) {
 		return g.parseRepetition(slexer)
 	case '(':
 		return g.parseGroup(slexer)
+	case '<':
+		return g.parseUnordered(slexer)
+	case '^':
+		return g.parseExclusive(slexer)
+	case '%':
+		return g.parseRecord(slexer)
+	case '$':
+		return g.parseKeywordSet(slexer)
+	case '&':
+		return g.parsePositiveLookahead(slexer)
+	case '!':
+		_, _ = slexer.Next()
+		return &cut{}, nil
+	case '#':
+		return g.parseColumn(slexer)
+	case '~':
+		return g.parseNegation(slexer)
 	case scanner.Ident:
+		if r.Value == "ε" {
+			_, _ = slexer.Next()
+			return &epsilon{}, nil
+		}
 		return g.parseReference(slexer)
 	case lexer.EOF:
 		_, _ = slexer.Next()
@@ -169,6 +316,17 @@ func (g *generatorContext) parseTerm(slexer *structLexer) (node, error) {
 }
 
 // @<expression> captures <expression> into the current field.
+//
+// An explicit target field may be given with "@<expression> -> <field>".
+//
+// "@#<expression>" instead increments the current integer field each time <expression> matches.
+//
+// "@=<expression>" instead captures the source text spanned by <expression> into a string field.
+//
+// "@<expression> /<regex>/" instead assigns the named groups of <regex>, matched against the
+// captured value, to the fields of the same names. A "/" is otherwise the separator of a
+// repetition, so only begins a regex if it is closed within the tag of the current field and the
+// text between contains a named group.
 func (g *generatorContext) parseCapture(slexer *structLexer) (node, error) {
 	_, _ = slexer.Next()
 	token, err := slexer.Peek()
@@ -176,22 +334,353 @@ func (g *generatorContext) parseCapture(slexer *structLexer) (node, error) {
 		return nil, err
 	}
 	field := slexer.Field()
+	also, err := alsoFields(slexer.s, field)
+	if err != nil {
+		return nil, err
+	}
+	if field, err = captureTarget(slexer.s, field); err != nil {
+		return nil, err
+	}
 	if token.Type == '@' {
 		_, _ = slexer.Next()
-		n, err := g.parseType(field.Type)
+		member, err := g.parseUnionMember(slexer)
+		if err != nil {
+			return nil, err
+		}
+		if field, err = g.parseCaptureTarget(slexer, field); err != nil {
+			return nil, err
+		}
+		var n node
+		if member != "" {
+			n, err = g.parseMemberType(field.Type, member)
+		} else {
+			n, err = g.parseType(field.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		validator, collection, err := parseFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		return &capture{field: field, also: also, enum: g.enums[indirectType(field.Type)], join: g.join, numbers: g.numbers,
+			validator: validator, collection: collection, node: n}, nil
+	}
+	if token.Type == '#' {
+		_, _ = slexer.Next()
+		return g.parseCount(slexer, field, also)
+	}
+	if token.Type == '=' {
+		_, _ = slexer.Next()
+		return g.parseRaw(slexer, field, also)
+	}
+	// Within @( ... ), "@( ... ) -> <field>" captures a group into a field of the element.
+	if t := indirectType(field.Type); token.Type == '(' && g.element == nil && t.Kind() == reflect.Struct && !g.isCapturedStruct(field.Type) {
+		return g.parseElement(slexer, field, also, t)
+	}
+	var n node
+	if token.Type == '[' {
+		// In "@[ <expression> ] -> <field>" the target belongs to the capture.
+		n, err = g.parseOptionalBody(slexer)
+	} else {
+		n, err = g.parseTerm(slexer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pattern, ok := slexer.PeekDelimited("/"); ok && hasNamedGroup(pattern) {
+		slexer.SkipDelimited("/")
+		groups, err := parseSubCaptures(g.targetStruct(slexer), pattern)
 		if err != nil {
 			return nil, err
 		}
-		return &capture{field, n}, nil
+		return &capture{field: field, also: also, join: g.join, numbers: g.numbers, groups: groups, node: n}, nil
+	}
+	if field, err = g.parseCaptureTarget(slexer, field); err != nil {
+		return nil, err
+	}
+	if indirectType(field.Type).Kind() == reflect.Struct && !g.isCapturedStruct(field.Type) {
+		return nil, fmt.Errorf("structs can only be parsed with @@, @( ... ), by implementing the Capture interface or with Convert()")
+	}
+	if ref, ok := n.(*reference); ok && g.signedNumbers && isSignedKind(indirectType(field.Type).Kind()) {
+		n = newSignedNumber(ref)
+	}
+	validator, collection, err := parseFieldOptions(field)
+	if err != nil {
+		return nil, err
+	}
+	char, chars := g.Symbols()["Char"]
+	return &capture{field: field, also: also, enum: g.enums[indirectType(field.Type)], convert: g.converters[indirectType(field.Type)],
+		chars: chars && isCharType(field.Type), char: char,
+		join: g.join, numbers: g.numbers, validator: validator, collection: collection, node: n}, nil
+}
+
+// Parse an optional ":<type>" following @@, returning the name of the union member.
+func (g *generatorContext) parseUnionMember(slexer *structLexer) (string, error) {
+	token, err := slexer.Peek()
+	if err != nil || token.Type != ':' {
+		return "", err
+	}
+	_, _ = slexer.Next() // :
+	token, err = slexer.Next()
+	if err != nil {
+		return "", err
+	}
+	if token.Type != scanner.Ident {
+		return "", fmt.Errorf("expected type name after @@: but got %q", token)
 	}
-	if indirectType(field.Type).Kind() == reflect.Struct && !field.Type.Implements(captureType) {
-		return nil, fmt.Errorf("structs can only be parsed with @@ or by implementing the Capture interface")
+	return token.Value, nil
+}
+
+// Parse the union member named member of the interface type t.
+func (g *generatorContext) parseMemberType(t reflect.Type, member string) (node, error) {
+	iface := indirectType(t)
+	members, ok := g.unions[iface]
+	if !ok {
+		return nil, fmt.Errorf("@@:%s requires a Union() to be registered for %s", member, iface)
 	}
+	for i, mt := range members {
+		if indirectType(mt).Name() != member {
+			continue
+		}
+		n, err := g.parseMember(iface, i, mt)
+		if err != nil {
+			return nil, err
+		}
+		out := &union{typ: iface, members: []reflect.Type{mt}}
+		out.addMember(n, g.unionTag(iface, i))
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s is not a member of the union %s", member, iface)
+}
+
+// Parse the i'th member of the union iface, preceded by its discriminator if the union is tagged.
+func (g *generatorContext) parseMember(iface reflect.Type, i int, member reflect.Type) (node, error) {
+	n, err := g.parseType(member)
+	if err != nil {
+		return nil, err
+	}
+	tag := g.unionTag(iface, i)
+	if tag == "" {
+		return n, nil
+	}
+	return &sequence{head: true, node: &literal{s: tag, t: lexer.EOF}, next: &sequence{node: n}}, nil
+}
+
+// The discriminator of the i'th member of the union iface, or "" if the union is not tagged.
+func (g *generatorContext) unionTag(iface reflect.Type, i int) string {
+	if tags, ok := g.unionTags[iface]; ok {
+		return tags[i]
+	}
+	return ""
+}
+
+func (g *generatorContext) parseRaw(slexer *structLexer, field structLexerField, also []structLexerField) (node, error) {
 	n, err := g.parseTerm(slexer)
 	if err != nil {
 		return nil, err
 	}
-	return &capture{field, n}, nil
+	if n == nil {
+		return nil, fmt.Errorf("expected expression after @=")
+	}
+	if field, err = g.parseCaptureTarget(slexer, field); err != nil {
+		return nil, err
+	}
+	for _, f := range append([]structLexerField{field}, also...) {
+		if indirectType(f.Type).Kind() != reflect.String {
+			return nil, fmt.Errorf("@= can only capture into string fields, not %s", f.Type)
+		}
+	}
+	g.rawCaptures = true
+	validator, collection, err := parseFieldOptions(field)
+	if err != nil {
+		return nil, err
+	}
+	return &capture{field: field, also: also, raw: true, join: g.join, numbers: g.numbers, validator: validator, collection: collection,
+		node: n}, nil
+}
+
+func (g *generatorContext) parseCount(slexer *structLexer, field structLexerField, also []structLexerField) (node, error) {
+	n, err := g.parseTerm(slexer)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("expected expression after @#")
+	}
+	if field, err = g.parseCaptureTarget(slexer, field); err != nil {
+		return nil, err
+	}
+	for _, f := range append([]structLexerField{field}, also...) {
+		if f.setter != "" {
+			return nil, fmt.Errorf("@# can not count into setter %s", f.setter)
+		}
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("@# can only count into integer fields, not %s", f.Type)
+		}
+	}
+	validator, collection, err := parseFieldOptions(field)
+	if err != nil {
+		return nil, err
+	}
+	return &capture{field: field, also: also, count: true, validator: validator, collection: collection, node: n}, nil
+}
+
+func isSignedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Returns true if values of type t are converted from captured tokens, despite being structs.
+func (g *generatorContext) isCapturedStruct(t reflect.Type) bool {
+	elem := indirectType(t)
+	return reflect.PtrTo(elem).Implements(captureType) || elem == bigIntType || elem == bigFloatType || g.converters[elem] != nil
+}
+
+// @( <expression> ) into a struct, or a pointer or slice of structs, parses the group into a new
+// element of the struct type each time it matches.
+//
+// Captures within the group are assigned to fields of the element, which must be named with
+// "-> <field>", eg.
+//
+// 		Pairs []Pair `{ @( @Ident -> Key "=" @Int -> Value ) }`
+func (g *generatorContext) parseElement(slexer *structLexer, field structLexerField, also []structLexerField, elem reflect.Type) (node, error) {
+	outer := g.element
+	g.element = elem
+	n, err := g.parseTerm(slexer)
+	g.element = outer
+	if err != nil {
+		return nil, err
+	}
+	target, err := g.parseCaptureTarget(slexer, field)
+	if err != nil {
+		return nil, err
+	}
+	if indirectType(target.Type) != elem {
+		return nil, fmt.Errorf("@( ... ) parses elements of %s which can not be captured into %s", elem, target.Type)
+	}
+	return &capture{field: target, also: also, node: &strct{typ: elem, expr: n}}, nil
+}
+
+// Parse an optional "-> <field>" capture target, returning field if one is not present.
+func (g *generatorContext) parseCaptureTarget(slexer *structLexer, field structLexerField) (structLexerField, error) {
+	token, err := slexer.Peek()
+	if err != nil {
+		return field, err
+	}
+	if token.Type != '-' {
+		if g.element != nil {
+			return field, fmt.Errorf("captures within @( ... ) must name a field of %s with -> <field>", g.element)
+		}
+		return field, nil
+	}
+	_, _ = slexer.Next() // -
+	if token, err = slexer.Next(); err != nil {
+		return field, err
+	} else if token.Type != '>' {
+		return field, fmt.Errorf("expected -> but got %q", "-"+token.Value)
+	}
+	token, err = slexer.Next()
+	if err != nil {
+		return field, err
+	}
+	if token.Type != scanner.Ident {
+		return field, fmt.Errorf("expected field name after -> but got %q", token)
+	}
+	return lookupTarget(g.targetStruct(slexer), token.Value)
+}
+
+// The struct whose fields "-> <field>" names, which within @( ... ) is the element being parsed
+// rather than the struct whose tags are being lexed.
+func (g *generatorContext) targetStruct(slexer *structLexer) reflect.Type {
+	if g.element != nil {
+		return g.element
+	}
+	return slexer.s
+}
+
+// Resolve the additional fields listed in the "also" tag of a field.
+//
+// eg.
+//
+// 		Raw   string `parser:"@Int" also:"Value"`
+// 		Value int
+func alsoFields(s reflect.Type, field structLexerField) ([]structLexerField, error) {
+	tag, ok := field.Tag.Lookup("also")
+	if !ok {
+		return nil, nil
+	}
+	out := []structLexerField{}
+	for _, name := range strings.Split(tag, ",") {
+		f, err := lookupTarget(s, strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("also:%q: %s", tag, err)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// Find the field or setter method targeted by name.
+//
+// If the struct has no exported field called name, a method "Set<name>" on the struct pointer is
+// used instead if present.
+func lookupTarget(s reflect.Type, name string) (structLexerField, error) {
+	f, err := lookupField(s, name)
+	if err == nil {
+		return f, nil
+	}
+	setter, ok, serr := lookupSetter(s, name)
+	if serr != nil {
+		return structLexerField{}, serr
+	} else if !ok {
+		return structLexerField{}, err
+	}
+	return setter, nil
+}
+
+// Resolve the target of a capture into field, which is the setter for field if it is unexported.
+func captureTarget(s reflect.Type, field structLexerField) (structLexerField, error) {
+	if field.PkgPath == "" {
+		return field, nil
+	}
+	r, size := utf8.DecodeRuneInString(field.Name)
+	setter, ok, err := lookupSetter(s, string(unicode.ToUpper(r))+field.Name[size:])
+	if err != nil || !ok {
+		return field, err
+	}
+	return setter, nil
+}
+
+// Find the method "Set<name>" on *s, which must accept a single value and optionally return an error.
+func lookupSetter(s reflect.Type, name string) (structLexerField, bool, error) {
+	method, ok := reflect.PtrTo(s).MethodByName("Set" + name)
+	if !ok {
+		return structLexerField{}, false, nil
+	}
+	mt := method.Type
+	if mt.NumIn() != 2 || mt.NumOut() > 1 || (mt.NumOut() == 1 && mt.Out(0) != errorType) {
+		return structLexerField{}, false, fmt.Errorf("setter %s.%s must accept a single value and return nothing or an error", s, method.Name)
+	}
+	return structLexerField{StructField: reflect.StructField{Name: name, Type: mt.In(1)}, setter: method.Name}, true, nil
+}
+
+// Find a settable field by name.
+func lookupField(s reflect.Type, name string) (structLexerField, error) {
+	f, ok := s.FieldByName(name)
+	if !ok {
+		return structLexerField{}, fmt.Errorf("unknown field %q in %s", name, s)
+	}
+	if f.PkgPath != "" {
+		return structLexerField{}, fmt.Errorf("field %q in %s is not exported", name, s)
+	}
+	return structLexerField{StructField: f, Index: f.Index}, nil
 }
 
 // A reference in the form <identifier> refers to a named token from the lexer.
@@ -205,19 +694,91 @@ func (g *generatorContext) parseReference(slexer *structLexer) (node, error) { /
 	}
 	typ, ok := g.Symbols()[token.Value]
 	if !ok {
-		return nil, fmt.Errorf("unknown token type %q", token)
+		return nil, fmt.Errorf("unknown token type %q, lexer defines %s", token, g.symbolNames())
 	}
 	return &reference{typ: typ, identifier: token.Value}, nil
 }
 
+// $<name> matches an identifier in the keyword set provided by WithKeywords(<name>, ...).
+func (g *generatorContext) parseKeywordSet(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // $
+	token, err := slexer.Next()
+	if err != nil {
+		return nil, err
+	}
+	if token.Type != scanner.Ident {
+		return nil, fmt.Errorf("expected keyword set name after $ but got %q", token)
+	}
+	typ, ok := g.Symbols()["Ident"]
+	if !ok {
+		return nil, fmt.Errorf("keyword set $%s requires an Ident token type, lexer defines %s", token.Value, g.symbolNames())
+	}
+	return &keywordSet{name: token.Value, typ: typ}, nil
+}
+
 // [ <expression> ] optionally matches <expression>.
+//
+// "[ <expression> ] -> <field>" additionally sets the bool or *bool <field> to whether
+// <expression> matched.
+//
+// "[ <expression> | default <expression> ]" instead matches the default expression, which may be
+// empty, if the others do not match.
 func (g *generatorContext) parseOptional(slexer *structLexer) (node, error) {
-	_, _ = slexer.Next() // [
-	disj, err := g.parseDisjunction(slexer)
+	n, err := g.parseOptionalBody(slexer)
+	if err != nil {
+		return nil, err
+	}
+	token, err := slexer.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if token.Type != '-' {
+		return n, nil
+	}
+	optional, ok := n.(*optional)
+	if !ok {
+		return nil, fmt.Errorf("optional presence can not be captured for an optional with a default")
+	}
+	field, err := g.parseCaptureTarget(slexer, slexer.Field())
 	if err != nil {
 		return nil, err
 	}
-	optional := &optional{node: disj}
+	if field.setter != "" {
+		return nil, fmt.Errorf("optional presence can not be captured into setter %s", field.setter)
+	}
+	if indirectType(field.Type).Kind() != reflect.Bool {
+		return nil, fmt.Errorf("optional presence can only be captured into bool fields, not %s", field.Type)
+	}
+	optional.present = &field
+	return optional, nil
+}
+
+// Parse the bracketed body of an optional, returning an optional or, if it has a default, a
+// disjunction ending with the default.
+func (g *generatorContext) parseOptionalBody(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // [
+	alternatives := &disjunction{}
+	var fallback node
+	for {
+		if token, err := slexer.Peek(); err != nil {
+			return nil, err
+		} else if token.Type == scanner.Ident && token.Value == "default" {
+			_, _ = slexer.Next()
+			if fallback, err = g.parseSequence(slexer); err != nil {
+				return nil, err
+			}
+			break
+		}
+		n, err := g.parseSequence(slexer)
+		if err != nil {
+			return nil, err
+		}
+		alternatives.nodes = append(alternatives.nodes, n)
+		if token, _ := slexer.Peek(); token.Type != '|' {
+			break
+		}
+		_, _ = slexer.Next() // |
+	}
 	next, err := slexer.Next()
 	if err != nil {
 		return nil, err
@@ -225,19 +786,41 @@ func (g *generatorContext) parseOptional(slexer *structLexer) (node, error) {
 	if next.Type != ']' {
 		return nil, fmt.Errorf("expected ] but got %q", next)
 	}
-	return optional, nil
+	var body node = alternatives
+	switch {
+	case len(alternatives.nodes) == 0:
+		return nil, fmt.Errorf("expected expression before default")
+	case len(alternatives.nodes) == 1:
+		body = alternatives.nodes[0]
+	}
+	if fallback == nil {
+		// An empty default is equivalent to skipping the optional.
+		return &optional{node: body}, nil
+	}
+	return &disjunction{nodes: []node{body, fallback}}, nil
 }
 
 // { <expression> } matches 0 or more repititions of <expression>
+//
+// "{ <expression> / <separator> }" instead matches 0 or more repetitions of <expression> separated
+// by <separator>, and "{ <expression> / <separator> -> <field> }" also captures each separator into
+// <field>, so that n elements are captured along with the n-1 separators between them.
 func (g *generatorContext) parseRepetition(slexer *structLexer) (node, error) {
 	_, _ = slexer.Next() // {
 	disj, err := g.parseDisjunction(slexer)
 	if err != nil {
 		return nil, err
 	}
-	n := &repetition{
+	var n node = &repetition{
 		node: disj,
 	}
+	if token, err := slexer.Peek(); err != nil {
+		return nil, err
+	} else if token.Type == '/' {
+		if n, err = g.parseSeparated(slexer, disj); err != nil {
+			return nil, err
+		}
+	}
 	next, err := slexer.Next()
 	if err != nil {
 		return nil, err
@@ -248,6 +831,38 @@ func (g *generatorContext) parseRepetition(slexer *structLexer) (node, error) {
 	return n, nil
 }
 
+// Parse "/ <separator> [-> <field>]", returning the equivalent of
+// "[ <element> { <separator> <element> } ]".
+func (g *generatorContext) parseSeparated(slexer *structLexer, element node) (node, error) {
+	_, _ = slexer.Next() // /
+	if element == nil {
+		return nil, fmt.Errorf("expected expression before /")
+	}
+	separator, err := g.parseDisjunction(slexer)
+	if err != nil {
+		return nil, err
+	}
+	if separator == nil {
+		return nil, fmt.Errorf("expected separator after /")
+	}
+	if token, err := slexer.Peek(); err != nil {
+		return nil, err
+	} else if token.Type == '-' {
+		field, err := g.parseCaptureTarget(slexer, slexer.Field())
+		if err != nil {
+			return nil, err
+		}
+		if indirectType(field.Type).Kind() == reflect.Struct && !g.isCapturedStruct(field.Type) {
+			return nil, fmt.Errorf("separators can not be captured into %s", field.Type)
+		}
+		separator = &capture{field: field, enum: g.enums[indirectType(field.Type)], convert: g.converters[indirectType(field.Type)],
+			join: g.join, numbers: g.numbers, node: separator}
+	}
+	return &optional{node: &sequence{head: true, node: element, next: &sequence{node: &repetition{
+		node: &sequence{head: true, node: separator, next: &sequence{node: element}},
+	}}}}, nil
+}
+
 // ( <expression> ) groups a sub-expression
 func (g *generatorContext) parseGroup(slexer *structLexer) (node, error) {
 	_, _ = slexer.Next() // (
@@ -262,9 +877,190 @@ func (g *generatorContext) parseGroup(slexer *structLexer) (node, error) {
 	if next.Type != ')' {
 		return nil, fmt.Errorf("expected ) but got %q", next)
 	}
+	if ref := referenceSet(disj); ref != nil {
+		return ref, nil
+	}
 	return disj, nil
 }
 
+// Collapse a disjunction consisting only of references into a single set reference, or return nil.
+func referenceSet(n node) *reference {
+	disj, ok := n.(*disjunction)
+	if !ok {
+		return nil
+	}
+	out := &reference{}
+	identifiers := []string{}
+	for i, c := range disj.nodes {
+		ref, ok := c.(*reference)
+		if !ok {
+			return nil
+		}
+		if i == 0 {
+			out.typ = ref.typ
+		} else {
+			out.set = append(out.set, ref.typ)
+		}
+		out.set = append(out.set, ref.set...)
+		identifiers = append(identifiers, ref.identifier)
+	}
+	out.identifier = strings.Join(identifiers, "|")
+	return out
+}
+
+// < <expression> | <expression> ... > matches each alternative at most once, in any order.
+func (g *generatorContext) parseUnordered(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // <
+	disj, err := g.parseDisjunction(slexer)
+	if err != nil {
+		return nil, err
+	}
+	next, err := slexer.Next() // >
+	if err != nil {
+		return nil, err
+	}
+	if next.Type != '>' {
+		return nil, fmt.Errorf("expected > but got %q", next)
+	}
+	if d, ok := disj.(*disjunction); ok {
+		return &unordered{nodes: d.nodes}, nil
+	}
+	return &unordered{nodes: []node{disj}}, nil
+}
+
+// ^( <expression> | <expression> ... ) matches exactly one alternative, once, within the
+// enclosing repetition.
+func (g *generatorContext) parseExclusive(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // ^
+	if token, err := slexer.Peek(); err != nil {
+		return nil, err
+	} else if token.Type != '(' {
+		return nil, fmt.Errorf("expected ( after ^ but got %q", token)
+	}
+	disj, err := g.parseGroup(slexer)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := disj.(*disjunction); ok {
+		return &exclusive{*d}, nil
+	}
+	return &exclusive{disjunction{nodes: []node{disj}}}, nil
+}
+
+// &<expression> matches if <expression> matches, without consuming any input.
+func (g *generatorContext) parsePositiveLookahead(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // &
+	n, err := g.parseTerm(slexer)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("expected expression after &")
+	}
+	return &positiveLookahead{node: n}, nil
+}
+
+// ~<expression> matches each token up to the next that begins a match of <expression>, or EOF.
+func (g *generatorContext) parseNegation(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // ~
+	n, err := g.parseTerm(slexer)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("expected expression after ~")
+	}
+	return &negation{stop: &positiveLookahead{node: n}}, nil
+}
+
+// #<column> or #<min>-<max> matches if the next token starts at the column, or within the
+// inclusive range of columns, without consuming any input.
+func (g *generatorContext) parseColumn(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // #
+	min, err := parseColumnNumber(slexer)
+	if err != nil {
+		return nil, err
+	}
+	max := min
+	token, err := slexer.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if token.Type == '-' {
+		_, _ = slexer.Next()
+		max, err = parseColumnNumber(slexer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if min < 1 || max < min {
+		return nil, fmt.Errorf("invalid column range %d-%d", min, max)
+	}
+	return &column{min: min, max: max}, nil
+}
+
+func parseColumnNumber(slexer *structLexer) (int, error) {
+	token, err := slexer.Next()
+	if err != nil {
+		return 0, err
+	}
+	if token.Type != scanner.Int {
+		return 0, fmt.Errorf("expected column number after # but got %q", token)
+	}
+	return strconv.Atoi(token.Value)
+}
+
+// %{ <expression> [?*+] | <expression> [?*+] ... } matches members in any order, each within
+// the bounds of its cardinality suffix.
+func (g *generatorContext) parseRecord(slexer *structLexer) (node, error) {
+	_, _ = slexer.Next() // %
+	if token, err := slexer.Next(); err != nil {
+		return nil, err
+	} else if token.Type != '{' {
+		return nil, fmt.Errorf("expected { after %% but got %q", token)
+	}
+	out := &record{}
+	for {
+		n, err := g.parseSequence(slexer)
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			return nil, fmt.Errorf("expected record member")
+		}
+		card := cardinality{min: 1, max: 1}
+		token, err := slexer.Peek()
+		if err != nil {
+			return nil, err
+		}
+		switch token.Type {
+		case '?':
+			card = cardinality{min: 0, max: 1}
+		case '*':
+			card = cardinality{min: 0, max: -1}
+		case '+':
+			card = cardinality{min: 1, max: -1}
+		}
+		if card.min != 1 || card.max != 1 {
+			_, _ = slexer.Next()
+		}
+		out.nodes = append(out.nodes, n)
+		out.cardinality = append(out.cardinality, card)
+		token, err = slexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch token.Type {
+		case '|':
+			continue
+		case '}':
+			return out, nil
+		default:
+			return nil, fmt.Errorf("expected | or } but got %q", token)
+		}
+	}
+}
+
 // A literal string.
 //
 // Note that for this to match, the tokeniser must be able to produce this string. For example,
@@ -295,7 +1091,7 @@ func (g *generatorContext) parseLiteral(lex *structLexer) (node, error) { // nol
 		var ok bool
 		t, ok = g.Symbols()[token.Value]
 		if !ok {
-			return nil, fmt.Errorf("unknown token type %q in literal type constraint", token)
+			return nil, fmt.Errorf("unknown token type %q in literal type constraint, lexer defines %s", token, g.symbolNames())
 		}
 	}
 	return &literal{s: s, t: t, tt: g.symbolsToIDs[t]}, nil
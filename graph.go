@@ -0,0 +1,230 @@
+package participle
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// GrammarKind is the kind of a GrammarNode.
+type GrammarKind string
+
+// Kinds of grammar nodes.
+const (
+	// GrammarStruct is a grammar struct named by Name, with its expression as the single child.
+	GrammarStruct GrammarKind = "struct"
+	// GrammarParseable is a type named by Name implementing the Parseable interface.
+	GrammarParseable GrammarKind = "parseable"
+	// GrammarUnion is an interface named by Name registered with Union(), with a child per member.
+	GrammarUnion GrammarKind = "union"
+	// GrammarDisjunction matches one of its children.
+	GrammarDisjunction GrammarKind = "disjunction"
+	// GrammarSequence matches each of its children in order.
+	GrammarSequence GrammarKind = "sequence"
+	// GrammarCapture captures its single child into Field, and any fields in Also.
+	GrammarCapture GrammarKind = "capture"
+	// GrammarOptional matches its single child zero or one times.
+	GrammarOptional GrammarKind = "optional"
+	// GrammarRepetition matches its single child zero or more times.
+	GrammarRepetition GrammarKind = "repetition"
+	// GrammarUnordered matches each of its children at most once, in any order.
+	GrammarUnordered GrammarKind = "unordered"
+	// GrammarExclusive matches exactly one of its children, once, within the enclosing repetition.
+	GrammarExclusive GrammarKind = "exclusive"
+	// GrammarRecord matches its children in any order, each within the bounds of its Cardinality.
+	GrammarRecord GrammarKind = "record"
+	// GrammarLookahead matches its single child without consuming input.
+	GrammarLookahead GrammarKind = "lookahead"
+	// GrammarCut commits to the enclosing branch without consuming input.
+	GrammarCut GrammarKind = "cut"
+	// GrammarEpsilon matches the empty string.
+	GrammarEpsilon GrammarKind = "epsilon"
+	// GrammarNegation matches tokens up to the first that begins a match of its single child, or EOF.
+	GrammarNegation GrammarKind = "negation"
+	// GrammarColumn matches if the next token starts at a column from Min to Max, without consuming input.
+	GrammarColumn GrammarKind = "column"
+	// GrammarLiteral matches a token with the value Value and, if Token is set, of that type.
+	GrammarLiteral GrammarKind = "literal"
+	// GrammarReference matches a token whose type is one of Tokens.
+	GrammarReference GrammarKind = "reference"
+	// GrammarKeywordSet matches a token of type Token in the keyword set named by Name.
+	GrammarKeywordSet GrammarKind = "keywordset"
+	// GrammarSignedNumber matches a token whose type is one of Tokens, optionally preceded by a sign.
+	GrammarSignedNumber GrammarKind = "signednumber"
+)
+
+// GrammarNode is an exported representation of a node in the compiled grammar.
+//
+// Only the fields relevant to Kind are set. Struct nodes are shared by every reference to the
+// struct, so the graph contains cycles for recursive grammars.
+type GrammarNode struct {
+	Kind GrammarKind
+	// Name of the type for struct, parseable and union nodes, or of the keyword set.
+	Name string
+	// Value of a literal.
+	Value string
+	// Token type of a literal or keyword set.
+	Token string
+	// Token types of a reference or signed number.
+	Tokens []string
+	// Field a capture assigns to, and any additional fields.
+	Field string
+	Also  []string
+	// Count is true if a capture counts matches rather than capturing values.
+	Count bool
+	// Cardinality of a record member: "" (exactly once), "?", "*" or "+".
+	Cardinality string
+	// Inclusive range of columns matched by a column node.
+	Min, Max int
+	// Metadata attached to a struct or captured field with Annotate().
+	Metadata interface{}
+	Children []*GrammarNode
+}
+
+// Grammar returns an exported representation of the compiled grammar, rooted at the grammar struct.
+func (p *Parser) Grammar() GrammarNode {
+	if err := p.resolve(); err != nil {
+		return GrammarNode{}
+	}
+	g := &grammarExporter{seen: map[node]*GrammarNode{}, symbols: p.generator.symbolsToIDs}
+	return *g.export(p.root)
+}
+
+type grammarExporter struct {
+	seen    map[node]*GrammarNode
+	symbols map[rune]string
+}
+
+func (g *grammarExporter) export(n node) *GrammarNode {
+	if out, ok := g.seen[n]; ok {
+		return out
+	}
+	switch n := n.(type) {
+	case *strct:
+		out := &GrammarNode{Kind: GrammarStruct, Name: ruleName(n.typ)}
+		if n.annotation != nil {
+			out.Metadata = n.annotation.Metadata
+		}
+		g.seen[n] = out
+		out.Children = []*GrammarNode{g.export(n.expr)}
+		return out
+
+	case *union:
+		out := &GrammarNode{Kind: GrammarUnion, Name: n.typ.String()}
+		g.seen[n] = out
+		out.Children = g.exportAll(n.nodes)
+		return out
+
+	case *precedence:
+		return g.export(n.grammar)
+
+	case *parseable:
+		return &GrammarNode{Kind: GrammarParseable, Name: n.t.String()}
+
+	case *disjunction:
+		return &GrammarNode{Kind: GrammarDisjunction, Children: g.exportAll(n.nodes)}
+
+	case *exclusive:
+		return &GrammarNode{Kind: GrammarExclusive, Children: g.exportAll(n.nodes)}
+
+	case *unordered:
+		return &GrammarNode{Kind: GrammarUnordered, Children: g.exportAll(n.nodes)}
+
+	case *record:
+		out := &GrammarNode{Kind: GrammarRecord, Children: g.exportAll(n.nodes)}
+		for i, child := range out.Children {
+			// Copy the member so that the cardinality does not leak into shared nodes.
+			member := *child
+			member.Cardinality = n.cardinality[i].String()
+			out.Children[i] = &member
+		}
+		return out
+
+	case *sequence:
+		out := &GrammarNode{Kind: GrammarSequence}
+		for c := n; c != nil; c = c.next {
+			child := g.export(c.node)
+			if _, ok := c.node.(*sequence); !ok && child.Kind == GrammarSequence {
+				// The remainder of the sequence owned by an optional or repetition.
+				out.Children = append(out.Children, child.Children...)
+			} else {
+				out.Children = append(out.Children, child)
+			}
+		}
+		return out
+
+	case *capture:
+		out := &GrammarNode{Kind: GrammarCapture, Field: n.field.Name, Count: n.count, Children: []*GrammarNode{g.export(n.node)}}
+		for _, f := range n.also {
+			out.Also = append(out.Also, f.Name)
+		}
+		if n.annotation != nil {
+			out.Metadata = n.annotation.Metadata
+		}
+		return out
+
+	case *optional:
+		return g.then(&GrammarNode{Kind: GrammarOptional, Children: []*GrammarNode{g.export(n.node)}}, n.next)
+
+	case *repetition:
+		return g.then(&GrammarNode{Kind: GrammarRepetition, Children: []*GrammarNode{g.export(n.node)}}, n.next)
+
+	case *positiveLookahead:
+		return &GrammarNode{Kind: GrammarLookahead, Children: []*GrammarNode{g.export(n.node)}}
+
+	case *versioned:
+		return g.export(n.node)
+
+	case *cut:
+		return &GrammarNode{Kind: GrammarCut}
+
+	case *epsilon:
+		return &GrammarNode{Kind: GrammarEpsilon}
+
+	case *negation:
+		return &GrammarNode{Kind: GrammarNegation, Children: []*GrammarNode{g.export(n.stop.node)}}
+
+	case *column:
+		return &GrammarNode{Kind: GrammarColumn, Min: n.min, Max: n.max}
+
+	case *literal:
+		out := &GrammarNode{Kind: GrammarLiteral, Value: n.s}
+		if n.t != lexer.EOF {
+			out.Token = n.tt
+		}
+		return out
+
+	case *reference:
+		return &GrammarNode{Kind: GrammarReference, Tokens: strings.Split(n.identifier, "|")}
+
+	case *keywordSet:
+		return &GrammarNode{Kind: GrammarKeywordSet, Name: n.name, Token: g.symbols[n.typ]}
+
+	case *signedNumber:
+		return &GrammarNode{Kind: GrammarSignedNumber, Tokens: strings.Split(n.number.identifier, "|")}
+
+	default:
+		panic(fmt.Sprintf("unsupported node type %T", n))
+	}
+}
+
+func (g *grammarExporter) exportAll(nodes []node) []*GrammarNode {
+	out := make([]*GrammarNode, 0, len(nodes))
+	for _, n := range nodes {
+		out = append(out, g.export(n))
+	}
+	return out
+}
+
+// Optional and repetition nodes own the remainder of their sequence.
+func (g *grammarExporter) then(head *GrammarNode, next node) *GrammarNode {
+	if next == nil {
+		return head
+	}
+	tail := g.export(next)
+	if tail.Kind == GrammarSequence {
+		return &GrammarNode{Kind: GrammarSequence, Children: append([]*GrammarNode{head}, tail.Children...)}
+	}
+	return &GrammarNode{Kind: GrammarSequence, Children: []*GrammarNode{head, tail}}
+}
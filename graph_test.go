@@ -0,0 +1,37 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrammarGraph(t *testing.T) {
+	type graphExpr struct {
+		Number int          `  @Int`
+		Nested []*graphExpr `| "(" { @@ } ")"`
+	}
+	p, err := Build(&graphExpr{})
+	require.NoError(t, err)
+
+	root := p.Grammar()
+	require.Equal(t, GrammarStruct, root.Kind)
+	require.Equal(t, "graphExpr", root.Name)
+	disjunction := root.Children[0]
+	require.Equal(t, GrammarDisjunction, disjunction.Kind)
+	require.Equal(t, &GrammarNode{Kind: GrammarCapture, Field: "Number", Children: []*GrammarNode{
+		{Kind: GrammarReference, Tokens: []string{"Int"}},
+	}}, disjunction.Children[0])
+
+	nested := disjunction.Children[1]
+	require.Equal(t, GrammarSequence, nested.Kind)
+	require.Len(t, nested.Children, 3)
+	require.Equal(t, &GrammarNode{Kind: GrammarLiteral, Value: "("}, nested.Children[0])
+	require.Equal(t, GrammarRepetition, nested.Children[1].Kind)
+	capture := nested.Children[1].Children[0]
+	require.Equal(t, "Nested", capture.Field)
+	require.Equal(t, &GrammarNode{Kind: GrammarLiteral, Value: ")"}, nested.Children[2])
+	// Recursive references share the struct node.
+	require.Equal(t, GrammarStruct, capture.Children[0].Kind)
+	require.True(t, capture.Children[0].Children[0] == disjunction)
+}
@@ -0,0 +1,14 @@
+package participle
+
+// Interval holds the bounds of a range of values, eg. "1..10" or "a-z".
+//
+// Each bound is captured into its own field of the interval with a group capture, so that a bound
+// may consist of several tokens, such as a sign and a number. For example:
+//
+//	Bounds *participle.Interval[int] `@( @(["-"] Int) -> Start ".." @(["-"] Int) -> End )`
+//
+// Start and End are converted as if they were captured into fields of type T.
+type Interval[T any] struct {
+	Start T
+	End   T
+}
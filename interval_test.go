@@ -0,0 +1,49 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+func TestInterval(t *testing.T) {
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Ident>[a-z]+)|(?P<Int>\d+)|(?P<Punct>\.\.|[-,])`))
+	type bounds struct {
+		Start int `@Int ".."`
+		End   int `@Int`
+	}
+	p := mustTestParser(t, &bounds{}, Lexer(lex))
+	actual := &bounds{}
+	err := p.ParseString(`1..10`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &bounds{Start: 1, End: 10}, actual)
+
+	type intervals struct {
+		Numbers *Interval[int]      `@( @(["-"] Int) -> Start ".." @(["-"] Int) -> End ) ","`
+		Letters *Interval[string]   `@( @Ident -> Start "-" @Ident -> End ) ","`
+		More    []*Interval[uint16] `{ @( @Int -> Start ".." @Int -> End ) }`
+	}
+	p = mustTestParser(t, &intervals{}, Lexer(lex))
+	actualIntervals := &intervals{}
+	err = p.ParseString(`1..10, a-z, 2..3 4..5`, actualIntervals)
+	require.NoError(t, err)
+	require.Equal(t, &intervals{
+		Numbers: &Interval[int]{Start: 1, End: 10},
+		Letters: &Interval[string]{Start: "a", End: "z"},
+		More:    []*Interval[uint16]{{Start: 2, End: 3}, {Start: 4, End: 5}},
+	}, actualIntervals)
+
+	actualIntervals = &intervals{}
+	err = p.ParseString(`-1..5, a-z,`, actualIntervals)
+	require.NoError(t, err)
+	require.Equal(t, &Interval[int]{Start: -1, End: 5}, actualIntervals.Numbers)
+	actualIntervals = &intervals{}
+	err = p.ParseString(`-10..-2, a-z,`, actualIntervals)
+	require.NoError(t, err)
+	require.Equal(t, &Interval[int]{Start: -10, End: -2}, actualIntervals.Numbers)
+
+	err = p.ParseString(`1..10, a-z, 1..99999`, &intervals{})
+	require.Error(t, err)
+}
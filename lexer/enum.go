@@ -0,0 +1,49 @@
+package lexer
+
+import (
+	"fmt"
+)
+
+// An integer enumeration of token types, named by its String() method.
+type enum interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16
+	String() string
+}
+
+// EnumSymbols returns the Symbols() of a lexer whose token types are the values of a Go constant
+// enumeration from first to last inclusive, each named by its String() method, along with "EOF".
+//
+// This allows a lexer producing tokens of eg.
+//
+//	type Kind int
+//
+//	const (
+//		Ident Kind = iota
+//		Number
+//		Punct
+//	)
+//
+// to be referenced by name from the grammar, with the map returned by EnumSymbols(Ident, Punct).
+// An error is returned if a value has no name, or shares its name or rune with another symbol.
+func EnumSymbols[T enum](first, last T) (map[string]rune, error) {
+	if first > last {
+		return nil, fmt.Errorf("invalid enumeration range %d-%d", first, last)
+	}
+	symbols := map[string]rune{"EOF": EOF}
+	for value := first; ; value++ {
+		name := value.String()
+		if name == "" {
+			return nil, fmt.Errorf("token type %d has no name", value)
+		}
+		if _, ok := symbols[name]; ok {
+			return nil, fmt.Errorf("token type %d has the same name %q as another symbol", value, name)
+		}
+		if rune(value) == EOF {
+			return nil, fmt.Errorf("token type %s has the same value as EOF", name)
+		}
+		symbols[name] = rune(value)
+		if value == last {
+			return symbols, nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package lexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testKind int
+
+const (
+	testIdent testKind = iota
+	testNumber
+	testPunct
+	testDuplicate
+	testUnnamed
+)
+
+func (k testKind) String() string {
+	switch k {
+	case testIdent:
+		return "Ident"
+	case testNumber:
+		return "Number"
+	case testPunct:
+		return "Punct"
+	case testDuplicate:
+		return "Ident"
+	}
+	return ""
+}
+
+func TestEnumSymbols(t *testing.T) {
+	symbols, err := EnumSymbols(testIdent, testPunct)
+	require.NoError(t, err)
+	require.Equal(t, map[string]rune{"EOF": EOF, "Ident": 0, "Number": 1, "Punct": 2}, symbols)
+
+	_, err = EnumSymbols(testIdent, testDuplicate)
+	require.EqualError(t, err, `token type 3 has the same name "Ident" as another symbol`)
+
+	_, err = EnumSymbols(testUnnamed, testUnnamed)
+	require.EqualError(t, err, `token type 4 has no name`)
+
+	_, err = EnumSymbols(testPunct, testIdent)
+	require.EqualError(t, err, `invalid enumeration range 2-0`)
+}
@@ -0,0 +1,131 @@
+package lexer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type fenceDefinition struct {
+	Definition
+	symbols map[string]rune
+	typ     rune
+	open    string
+	close   string
+}
+
+// Fences wraps a lexer definition so that text between the delimiters open and close is not
+// lexed, but returned verbatim as the value of a single token with the type symbol.
+//
+// This allows the interior of eg. Markdown code blocks, which need not consist of valid tokens, to
+// be captured with:
+//
+//	Code string `@Fence`
+//
+// given Fences(def, "Fence", "```", "```"). The token is positioned at the opening delimiter and
+// its value excludes both delimiters. Delimiters are recognised anywhere in the input, and an
+// opening delimiter without a closing delimiter is an error.
+func Fences(def Definition, symbol, open, close string) (Definition, error) {
+	if open == "" || close == "" {
+		return nil, fmt.Errorf("fence delimiters must not be empty")
+	}
+	symbols := map[string]rune{}
+	next := EOF
+	for name, rn := range def.Symbols() {
+		symbols[name] = rn
+		if rn < next {
+			next = rn
+		}
+	}
+	if _, ok := symbols[symbol]; ok {
+		return nil, fmt.Errorf("lexer already defines symbol %q", symbol)
+	}
+	d := &fenceDefinition{Definition: def, symbols: symbols, typ: next - 1, open: open, close: close}
+	symbols[symbol] = d.typ
+	return d, nil
+}
+
+func (d *fenceDefinition) Symbols() map[string]rune {
+	return d.symbols
+}
+
+// TabWidth returns the tab width of the wrapped definition, as fence positions are computed
+// consistently with the positions of its tokens.
+func (d *fenceDefinition) TabWidth() int {
+	return TabWidthOf(d.Definition)
+}
+
+func (d *fenceDefinition) Lex(r io.Reader) (Lexer, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &fenceLexer{def: d, source: string(source), pos: Position{Filename: NameOfReader(r), Line: 1, Column: 1}}, nil
+}
+
+type fenceLexer struct {
+	def    *fenceDefinition
+	source string
+	// Position of the remainder of the source not yet split into segments.
+	pos Position
+	// Lexer for the segment of the source preceding the next fence, if any.
+	segment Lexer
+	// The fence following the current segment, or the error if it is not terminated.
+	fence *Token
+	err   error
+	done  bool
+}
+
+func (l *fenceLexer) Next() (Token, error) {
+	for {
+		if l.segment != nil {
+			t, err := l.segment.Next()
+			if err != nil || !t.EOF() || l.done {
+				return t, err
+			}
+			l.segment = nil
+			if l.err != nil {
+				return Token{}, l.err
+			}
+			if l.fence != nil {
+				fence := *l.fence
+				l.fence = nil
+				return fence, nil
+			}
+		}
+		if err := l.split(); err != nil {
+			return Token{}, err
+		}
+	}
+}
+
+// Split the source up to the next fence into a segment to lex, followed by the fence itself.
+func (l *fenceLexer) split() error {
+	rest := l.source[l.pos.Offset:]
+	text := rest
+	start := strings.Index(rest, l.def.open)
+	if start == -1 {
+		l.done = true
+	} else {
+		text = rest[:start]
+	}
+	lexer, err := l.def.Definition.Lex(strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	l.segment = Rebase(lexer, l.pos)
+	l.pos = l.pos.Advance(text, l.def.TabWidth())
+	if l.done {
+		return nil
+	}
+	interior := rest[start+len(l.def.open):]
+	end := strings.Index(interior, l.def.close)
+	if end == -1 {
+		l.err = Errorf(l.pos, "unterminated %q", l.def.open)
+		return nil
+	}
+	l.fence = &Token{Type: l.def.typ, Value: interior[:end], Pos: l.pos}
+	l.pos = l.pos.Advance(l.def.open+interior[:end]+l.def.close, l.def.TabWidth())
+	return nil
+}
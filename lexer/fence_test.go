@@ -0,0 +1,46 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFences(t *testing.T) {
+	def, err := Fences(Must(Regexp(`(?P<Ident>\w+)|(\s+)`)), "Fence", "```", "```")
+	require.NoError(t, err)
+	symbols := def.Symbols()
+	lex, err := def.Lex(strings.NewReader("a ```\n  { not tokens\n``` b\nc```x```"))
+	require.NoError(t, err)
+	tokens, err := ConsumeAll(lex)
+	require.NoError(t, err)
+	require.Equal(t, []Token{
+		{Type: symbols["Ident"], Value: "a", Pos: Position{Offset: 0, Line: 1, Column: 1}},
+		{Type: symbols["Fence"], Value: "\n  { not tokens\n", Pos: Position{Offset: 2, Line: 1, Column: 3}},
+		{Type: symbols["Ident"], Value: "b", Pos: Position{Offset: 25, Line: 3, Column: 5}},
+		{Type: symbols["Ident"], Value: "c", Pos: Position{Offset: 27, Line: 4, Column: 1}},
+		{Type: symbols["Fence"], Value: "x", Pos: Position{Offset: 28, Line: 4, Column: 2}},
+		{Type: EOF, Pos: Position{Offset: 35, Line: 4, Column: 9}},
+	}, tokens)
+
+	lex, err = def.Lex(strings.NewReader("a\n```b"))
+	require.NoError(t, err)
+	token, err := lex.Next()
+	require.NoError(t, err)
+	require.Equal(t, "a", token.Value)
+	_, err = lex.Next()
+	require.EqualError(t, err, "<source>:2:1: unterminated \"```\"")
+
+	tabbed, err := Fences(TextScanner(TabWidth(4)), "Fence", "<<", ">>")
+	require.NoError(t, err)
+	lex, err = tabbed.Lex(strings.NewReader("a\tb\t<<\tx>>c"))
+	require.NoError(t, err)
+	tokens, err = ConsumeAll(lex)
+	require.NoError(t, err)
+	require.Equal(t, Position{Offset: 4, Line: 1, Column: 9}, tokens[2].Pos)
+	require.Equal(t, Position{Offset: 10, Line: 1, Column: 16}, tokens[3].Pos)
+
+	_, err = Fences(def, "Fence", "<<", ">>")
+	require.EqualError(t, err, `lexer already defines symbol "Fence"`)
+}
@@ -0,0 +1,127 @@
+package lexer
+
+import (
+	"fmt"
+	"io"
+)
+
+type indentationDefinition struct {
+	Definition
+	symbols map[string]rune
+	indent  rune
+	dedent  rune
+	ignore  []string
+}
+
+// Indentation wraps a lexer definition, inserting an "Indent" token before the first token of
+// each line that is indented further than the line preceding it, and a "Dedent" token for each
+// level of indentation closed by a line that is indented less.
+//
+// Indentation is measured by the column of the first token on each line, so the wrapped lexer
+// may discard whitespace as usual. A line that is indented less than the preceding line must
+// return to the indentation of an enclosing line. Any levels still open at the end of the input
+// are closed by Dedent tokens preceding EOF. Tokens with one of the symbols in ignore, eg.
+// comments, neither affect nor are affected by indentation.
+//
+// This allows blocks to be matched with, eg.
+//
+//	Statements []*Statement `Indent { @@ } Dedent`
+func Indentation(def Definition, ignore ...string) (Definition, error) {
+	symbols := map[string]rune{}
+	next := EOF
+	for symbol, rn := range def.Symbols() {
+		symbols[symbol] = rn
+		if rn < next {
+			next = rn
+		}
+	}
+	for _, symbol := range ignore {
+		if _, ok := symbols[symbol]; !ok {
+			return nil, fmt.Errorf("lexer does not support symbol %q", symbol)
+		}
+	}
+	for _, symbol := range []string{"Indent", "Dedent"} {
+		if _, ok := symbols[symbol]; ok {
+			return nil, fmt.Errorf("lexer already defines symbol %q", symbol)
+		}
+	}
+	d := &indentationDefinition{Definition: def, symbols: symbols, indent: next - 1, dedent: next - 2, ignore: ignore}
+	symbols["Indent"] = d.indent
+	symbols["Dedent"] = d.dedent
+	return d, nil
+}
+
+func (d *indentationDefinition) Symbols() map[string]rune {
+	return d.symbols
+}
+
+func (d *indentationDefinition) Lex(r io.Reader) (Lexer, error) {
+	lexer, err := d.Definition.Lex(r)
+	if err != nil {
+		return nil, err
+	}
+	ignore := map[rune]bool{}
+	for _, symbol := range d.ignore {
+		ignore[d.symbols[symbol]] = true
+	}
+	return &indentationLexer{lexer: lexer, def: d, ignore: ignore}, nil
+}
+
+type indentationLexer struct {
+	lexer  Lexer
+	def    *indentationDefinition
+	ignore map[rune]bool
+	// Columns of the currently open levels of indentation, outermost first.
+	levels  []int
+	line    int
+	pending []Token
+}
+
+func (l *indentationLexer) Next() (Token, error) {
+	if len(l.pending) == 0 {
+		t, err := l.lexer.Next()
+		if err != nil {
+			return t, err
+		}
+		if err := l.scan(t); err != nil {
+			return Token{}, err
+		}
+	}
+	t := l.pending[0]
+	l.pending = l.pending[1:]
+	return t, nil
+}
+
+// Queue t, preceded by any Indent or Dedent tokens it implies.
+func (l *indentationLexer) scan(t Token) error {
+	switch {
+	case t.EOF():
+		for len(l.levels) > 1 {
+			l.levels = l.levels[:len(l.levels)-1]
+			l.pending = append(l.pending, Token{Type: l.def.dedent, Value: "<dedent>", Pos: t.Pos})
+		}
+
+	case l.ignore[t.Type] || (l.levels != nil && t.Pos.Line <= l.line):
+
+	case l.levels == nil:
+		l.levels = []int{t.Pos.Column}
+
+	case t.Pos.Column > l.levels[len(l.levels)-1]:
+		l.levels = append(l.levels, t.Pos.Column)
+		l.pending = append(l.pending, Token{Type: l.def.indent, Value: "<indent>", Pos: t.Pos})
+
+	default:
+		for len(l.levels) > 1 && t.Pos.Column < l.levels[len(l.levels)-1] {
+			l.levels = l.levels[:len(l.levels)-1]
+			l.pending = append(l.pending, Token{Type: l.def.dedent, Value: "<dedent>", Pos: t.Pos})
+		}
+		if t.Pos.Column != l.levels[len(l.levels)-1] {
+			return Errorf(t.Pos, "unindent does not match any outer indentation level")
+		}
+	}
+	if !l.ignore[t.Type] {
+		l.line = t.Pos.Line
+	}
+	l.pending = append(l.pending, t)
+	return nil
+}
@@ -0,0 +1,47 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndentation(t *testing.T) {
+	def, err := Indentation(Must(Regexp(`(?P<Ident>\w+)|(?P<Comment>#[^\n]*)|(\s+)`)), "Comment")
+	require.NoError(t, err)
+	symbols := def.Symbols()
+	lex, err := def.Lex(strings.NewReader(`
+a
+  b
+    c
+   # comment
+  d
+e
+  f
+`))
+	require.NoError(t, err)
+	tokens, err := ConsumeAll(lex)
+	require.NoError(t, err)
+	names := SymbolsByRune(def)
+	actual := []string{}
+	for _, token := range tokens {
+		if token.Type == symbols["Ident"] || token.Type == symbols["Comment"] {
+			actual = append(actual, token.Value)
+		} else {
+			actual = append(actual, names[token.Type])
+		}
+	}
+	require.Equal(t, []string{
+		"a", "Indent", "b", "Indent", "c", "# comment", "Dedent", "d", "Dedent", "e",
+		"Indent", "f", "Dedent", "EOF",
+	}, actual)
+
+	lex, err = def.Lex(strings.NewReader("a\n    b\n  c\n"))
+	require.NoError(t, err)
+	_, err = ConsumeAll(lex)
+	require.EqualError(t, err, "<source>:3:3: unindent does not match any outer indentation level")
+
+	_, err = Indentation(def)
+	require.EqualError(t, err, `lexer already defines symbol "Indent"`)
+}
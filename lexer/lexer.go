@@ -104,6 +104,26 @@ func (p Position) String() string {
 	return fmt.Sprintf("%s:%d:%d", filename, p.Line, p.Column)
 }
 
+// Advance returns the position following text, beginning at p.
+//
+// A tab advances the column to the next multiple of tabWidth, as for a TextScanner lexer
+// configured with TabWidth(). A tabWidth of 1 or less counts a tab as a single column.
+func (p Position) Advance(text string, tabWidth int) Position {
+	for _, r := range text {
+		switch {
+		case r == '\n':
+			p.Line++
+			p.Column = 1
+		case r == '\t' && tabWidth > 1:
+			p.Column = ((p.Column-1)/tabWidth+1)*tabWidth + 1
+		default:
+			p.Column++
+		}
+	}
+	p.Offset += len(text)
+	return p
+}
+
 // A Token returned by a Lexer.
 type Token struct {
 	// Type of token. This is the value keyed by symbol as returned by Definition.Symbols().
@@ -133,6 +153,43 @@ func (t Token) GoString() string {
 	return fmt.Sprintf("Token{%d, %q}", t.Type, t.Value)
 }
 
+// Rebase returns a Lexer reporting the positions of tokens, and of errors, from lexer relative to
+// base, as if its input appeared at base within a larger file.
+//
+// Offsets and lines are advanced by those of base, and columns on the first line by the column of
+// base. The filename of base, if any, replaces that of each position.
+func Rebase(lexer Lexer, base Position) Lexer {
+	return &rebasingLexer{lexer: lexer, base: base}
+}
+
+type rebasingLexer struct {
+	lexer Lexer
+	base  Position
+}
+
+func (r *rebasingLexer) Next() (Token, error) {
+	t, err := r.lexer.Next()
+	if lerr, ok := err.(*Error); ok {
+		return t, &Error{Message: lerr.Message, Pos: r.rebase(lerr.Pos)}
+	}
+	t.Pos = r.rebase(t.Pos)
+	return t, err
+}
+
+func (r *rebasingLexer) rebase(pos Position) Position {
+	if r.base.Filename != "" {
+		pos.Filename = r.base.Filename
+	}
+	pos.Offset += r.base.Offset
+	if pos.Line <= 1 && r.base.Column > 1 {
+		pos.Column += r.base.Column - 1
+	}
+	if r.base.Line > 1 {
+		pos.Line += r.base.Line - 1
+	}
+	return pos
+}
+
 // MakeSymbolTable builds a lookup table for checking token ID existence.
 //
 // For each symbolic name in "types", the returned map will contain the corresponding token ID as a key.
@@ -1,5 +1,7 @@
 package lexer
 
+import "fmt"
+
 // Upgrade a Lexer to a PeekingLexer with arbitrary lookahead.
 func Upgrade(lexer Lexer) PeekingLexer {
 	if peeking, ok := lexer.(PeekingLexer); ok {
@@ -35,3 +37,170 @@ func (l *lookaheadLexer) Next() (Token, error) {
 	}
 	return l.Lexer.Next()
 }
+
+// BufferedLexer is a PeekingLexer that buffers the tokens read from the underlying Lexer, and
+// tracks the position of its cursor within that token stream.
+//
+// Tokens before the cursor are discarded as it advances, except for the token immediately before
+// it and those from the oldest cursor retained by Hold(), so that memory use is bounded by the
+// extent of any backtracking rather than by the length of the input.
+type BufferedLexer struct {
+	lexer  Lexer
+	cursor int
+	tokens []Token
+	// Index within the token stream of tokens[0].
+	offset int
+	// Cursors retained by Hold() that have not been released.
+	held []int
+	// Called with the new offset when tokens are discarded, provided by OnDiscard().
+	onDiscard func(offset int)
+	eof       bool
+}
+
+// Buffer a Lexer, providing arbitrary lookahead and a cursor into the token stream.
+func Buffer(lexer Lexer) *BufferedLexer {
+	return &BufferedLexer{lexer: lexer}
+}
+
+// Reset the BufferedLexer to read from lexer, reusing its token buffer.
+//
+// Any tokens buffered from the previous Lexer are discarded and the cursor is returned to the start.
+func (b *BufferedLexer) Reset(lexer Lexer) {
+	b.lexer = lexer
+	b.cursor = 0
+	b.tokens = b.tokens[:0]
+	b.offset = 0
+	b.held = b.held[:0]
+	b.onDiscard = nil
+	b.eof = false
+}
+
+// OnDiscard calls fn each time tokens are discarded, with the index within the token stream of
+// the oldest token still buffered, eg. to discard state kept for the tokens before it. It is
+// cleared by Reset().
+func (b *BufferedLexer) OnDiscard(fn func(offset int)) {
+	b.onDiscard = fn
+}
+
+// Cursor returns the index within the token stream of the next token to be returned by Next().
+func (b *BufferedLexer) Cursor() int {
+	return b.cursor
+}
+
+// Hold retains the tokens from the cursor onwards until release is called, so that the cursor may
+// be passed to Restore() or Range().
+func (b *BufferedLexer) Hold() (cursor int, release func()) {
+	cursor = b.cursor
+	b.held = append(b.held, cursor)
+	return cursor, func() {
+		for i := len(b.held) - 1; i >= 0; i-- {
+			if b.held[i] == cursor {
+				b.held = append(b.held[:i], b.held[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Discard tokens that can no longer be returned to.
+//
+// Tokens are discarded in bulk once at least half of the buffer precedes the cursor, so that each
+// token is moved only a bounded number of times.
+func (b *BufferedLexer) discard() {
+	keep := b.cursor - 1
+	for _, cursor := range b.held {
+		if cursor < keep {
+			keep = cursor
+		}
+	}
+	n := keep - b.offset
+	if n < 64 || n < len(b.tokens)/2 {
+		return
+	}
+	b.tokens = b.tokens[:copy(b.tokens, b.tokens[n:])]
+	b.offset = keep
+	if b.onDiscard != nil {
+		b.onDiscard(keep)
+	}
+}
+
+func (b *BufferedLexer) fill(n int) error {
+	for !b.eof && b.offset+len(b.tokens) <= b.cursor+n {
+		t, err := b.lexer.Next()
+		if err != nil {
+			return err
+		}
+		b.tokens = append(b.tokens, t)
+		b.eof = t.EOF()
+	}
+	return nil
+}
+
+// Peek at the n'th token after the cursor.
+//
+// Peeking beyond the end of the stream returns the EOF token.
+func (b *BufferedLexer) Peek(n int) (Token, error) {
+	if err := b.fill(n); err != nil {
+		return Token{}, err
+	}
+	if i := b.cursor + n - b.offset; i < len(b.tokens) {
+		return b.tokens[i], nil
+	}
+	return b.tokens[len(b.tokens)-1], nil
+}
+
+// Next consumes and returns the token at the cursor.
+//
+// The cursor does not advance beyond the EOF token.
+func (b *BufferedLexer) Next() (Token, error) {
+	t, err := b.Peek(0)
+	if err != nil {
+		return t, err
+	}
+	if !t.EOF() {
+		b.cursor++
+		b.discard()
+	}
+	return t, nil
+}
+
+// Restore the cursor to a position previously returned by Cursor(), allowing the tokens after
+// it to be consumed again.
+//
+// A cursor before the token preceding the current cursor must be retained by Hold(), otherwise
+// it is restored only if its tokens happen not to have been discarded yet, and an error is
+// returned if they have.
+func (b *BufferedLexer) Restore(cursor int) error {
+	if cursor < b.offset || cursor > b.offset+len(b.tokens) {
+		return fmt.Errorf("can not restore cursor %d, outside the buffered tokens %d to %d", cursor, b.offset, b.offset+len(b.tokens))
+	}
+	b.cursor = cursor
+	return nil
+}
+
+// Drain consumes and returns all remaining tokens, excluding the terminating EOF token.
+func (b *BufferedLexer) Drain() ([]Token, error) {
+	out := []Token{}
+	for {
+		t, err := b.Next()
+		if err != nil {
+			return nil, err
+		}
+		if t.EOF() {
+			return out, nil
+		}
+		out = append(out, t)
+	}
+}
+
+// Range returns the tokens from cursor start up to, but excluding, cursor end, as returned by
+// Cursor().
+//
+// As with Restore(), a start before the token preceding the current cursor must be retained by
+// Hold().
+func (b *BufferedLexer) Range(start, end int) []Token {
+	if start < b.offset || start > end || end > b.offset+len(b.tokens) {
+		panic("cursor out of range")
+	}
+	return append([]Token(nil), b.tokens[start-b.offset:end-b.offset]...)
+}
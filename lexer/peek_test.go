@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -42,3 +43,94 @@ func mustNext(t *testing.T, lexer Lexer) Token {
 	require.NoError(t, err)
 	return token
 }
+
+func TestBuffer(t *testing.T) {
+	t0 := Token{Type: 1, Value: "moo"}
+	t1 := Token{Type: 2, Value: "blah"}
+	l := Buffer(&staticLexer{tokens: []Token{t0, t1}})
+	require.Equal(t, 0, l.Cursor())
+	require.Equal(t, t1, mustPeek(t, l, 1))
+	require.Equal(t, t0, mustNext(t, l))
+	require.Equal(t, 1, l.Cursor())
+	require.Equal(t, t1, mustNext(t, l))
+	require.True(t, mustPeek(t, l, 3).EOF())
+	require.True(t, mustNext(t, l).EOF())
+	require.True(t, mustNext(t, l).EOF())
+	require.Equal(t, 2, l.Cursor())
+}
+
+func TestBufferReset(t *testing.T) {
+	t0 := Token{Type: 1, Value: "moo"}
+	t1 := Token{Type: 2, Value: "blah"}
+	l := Buffer(&staticLexer{tokens: []Token{t0, t1}})
+	require.Equal(t, t0, mustNext(t, l))
+	require.True(t, mustPeek(t, l, 2).EOF())
+
+	t2 := Token{Type: 3, Value: "baa"}
+	l.Reset(&staticLexer{tokens: []Token{t2}})
+	require.Equal(t, 0, l.Cursor())
+	require.Equal(t, t2, mustPeek(t, l, 0))
+	require.True(t, mustPeek(t, l, 1).EOF())
+	require.Equal(t, t2, mustNext(t, l))
+	require.True(t, mustNext(t, l).EOF())
+	require.Equal(t, 1, l.Cursor())
+}
+
+func TestBufferDrain(t *testing.T) {
+	t0 := Token{Type: 1, Value: "moo"}
+	t1 := Token{Type: 2, Value: "blah"}
+	l := Buffer(&staticLexer{tokens: []Token{t0, t1}})
+	require.Equal(t, t0, mustNext(t, l))
+	tokens, err := l.Drain()
+	require.NoError(t, err)
+	require.Equal(t, []Token{t1}, tokens)
+	require.True(t, mustPeek(t, l, 0).EOF())
+	tokens, err = l.Drain()
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestBufferRestore(t *testing.T) {
+	t0 := Token{Type: 1, Value: "moo"}
+	t1 := Token{Type: 2, Value: "blah"}
+	l := Buffer(&staticLexer{tokens: []Token{t0, t1}})
+	cursor := l.Cursor()
+	require.Equal(t, t0, mustNext(t, l))
+	require.Equal(t, t1, mustNext(t, l))
+	require.NoError(t, l.Restore(cursor))
+	require.Equal(t, t0, mustNext(t, l))
+	require.Error(t, l.Restore(10))
+}
+
+func TestBufferDiscard(t *testing.T) {
+	tokens := []Token{}
+	for i := 0; i < 1000; i++ {
+		tokens = append(tokens, Token{Type: 1, Value: fmt.Sprint(i)})
+	}
+	l := Buffer(&staticLexer{tokens: tokens})
+	discarded := 0
+	l.OnDiscard(func(offset int) { discarded = offset })
+	for i := 0; i < 500; i++ {
+		mustNext(t, l)
+	}
+	require.True(t, len(l.tokens) < 200, "%d tokens buffered", len(l.tokens))
+	require.Equal(t, tokens[499:500], l.Range(499, 500))
+	require.Error(t, l.Restore(0))
+	require.Equal(t, l.offset, discarded)
+	require.True(t, discarded > 300, "discarded up to %d", discarded)
+
+	cursor, release := l.Hold()
+	for i := 500; i < 1000; i++ {
+		mustNext(t, l)
+	}
+	require.Equal(t, tokens[500:510], l.Range(cursor, cursor+10))
+	require.NoError(t, l.Restore(cursor))
+	require.Equal(t, tokens[500], mustNext(t, l))
+	release()
+	for i := 501; i < 1000; i++ {
+		mustNext(t, l)
+	}
+	require.True(t, len(l.tokens) < 200, "%d tokens buffered", len(l.tokens))
+	require.True(t, mustNext(t, l).EOF())
+	require.Equal(t, 1000, l.Cursor())
+}
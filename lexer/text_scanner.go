@@ -18,14 +18,83 @@ var (
 	DefaultDefinition = TextScannerLexer
 )
 
-type defaultDefinition struct{}
+// Whitespace is the token type of whitespace emitted by a TextScanner lexer configured with
+// WhitespaceTokens.
+const Whitespace rune = scanner.Comment - 1
+
+// TextScannerOption configures a text/scanner based lexer Definition.
+type TextScannerOption func(*defaultDefinition)
+
+// WhitespaceTokens causes a TextScanner lexer to emit whitespace as tokens of type "Whitespace"
+// rather than skipping it.
+//
+// If coalesce is true, each run of consecutive whitespace characters is emitted as a single token,
+// otherwise each whitespace character is emitted as its own token. Whitespace tokens will
+// typically be dropped with participle.Elide("Whitespace").
+func WhitespaceTokens(coalesce bool) TextScannerOption {
+	return func(d *defaultDefinition) {
+		d.whitespace = true
+		d.coalesce = coalesce
+	}
+}
+
+// TabWidth sets the width used to compute Position.Column for tab characters.
+//
+// A tab advances the column to the next multiple of width. The default width of 1 counts a tab
+// as a single column, the same as text/scanner.
+func TabWidth(width int) TextScannerOption {
+	return func(d *defaultDefinition) {
+		d.tabWidth = width
+	}
+}
+
+// TabWidthOf returns the width used for tab characters in the positions of tokens lexed by def,
+// as set with TabWidth(), or 1 if def does not provide it.
+func TabWidthOf(def Definition) int {
+	if d, ok := def.(interface{ TabWidth() int }); ok && d.TabWidth() > 1 {
+		return d.TabWidth()
+	}
+	return 1
+}
+
+// TextScanner creates a lexer Definition based on text/scanner, configured with options.
+//
+// TextScanner() with no options is equivalent to TextScannerLexer.
+func TextScanner(options ...TextScannerOption) Definition {
+	d := &defaultDefinition{}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+type defaultDefinition struct {
+	whitespace bool
+	coalesce   bool
+	tabWidth   int
+}
 
 func (d *defaultDefinition) Lex(r io.Reader) (Lexer, error) {
-	return Lex(r), nil
+	if !d.whitespace && d.tabWidth <= 1 {
+		return Lex(r), nil
+	}
+	var columns *columnTracker
+	if d.tabWidth > 1 {
+		columns = &columnTracker{tabWidth: d.tabWidth, column: 1}
+		r = io.TeeReader(r, columns)
+	}
+	lexer := Lex(r).(*textScannerLexer)
+	lexer.columns = columns
+	if d.whitespace {
+		lexer.scanner.Whitespace = 0
+		lexer.whitespace = true
+		lexer.coalesce = d.coalesce
+	}
+	return lexer, nil
 }
 
 func (d *defaultDefinition) Symbols() map[string]rune {
-	return map[string]rune{
+	symbols := map[string]rune{
 		"EOF":       scanner.EOF,
 		"Char":      scanner.Char,
 		"Ident":     scanner.Ident,
@@ -35,12 +104,29 @@ func (d *defaultDefinition) Symbols() map[string]rune {
 		"RawString": scanner.RawString,
 		"Comment":   scanner.Comment,
 	}
+	if d.whitespace {
+		symbols["Whitespace"] = Whitespace
+	}
+	return symbols
+}
+
+// TabWidth returns the width used to compute Position.Column for tab characters.
+func (d *defaultDefinition) TabWidth() int {
+	return d.tabWidth
+}
+
+// Unquoted returns the types of tokens that are unquoted by the lexer.
+func (d *defaultDefinition) Unquoted() []string {
+	return []string{"Char", "String", "RawString"}
 }
 
 // textScannerLexer is a Lexer based on text/scanner.Scanner
 type textScannerLexer struct {
-	scanner  *scanner.Scanner
-	filename string
+	scanner    *scanner.Scanner
+	filename   string
+	whitespace bool
+	coalesce   bool
+	columns    *columnTracker
 }
 
 // Lex an io.Reader with text/scanner.Scanner.
@@ -51,9 +137,10 @@ type textScannerLexer struct {
 func Lex(r io.Reader) Lexer {
 	lexer := lexWithScanner(r, &scanner.Scanner{})
 	lexer.scanner.Error = func(s *scanner.Scanner, msg string) {
-		// This is to support single quoted strings. Hacky.
-		if msg != "illegal char literal" {
-			panic(Errorf(Position(lexer.scanner.Pos()), msg))
+		// This is to support single quoted strings. Hacky. Newer versions of text/scanner
+		// report "invalid" rather than "illegal".
+		if msg != "illegal char literal" && msg != "invalid char literal" {
+			panic(Errorf(Position(lexer.scanner.Pos()), "%s", msg))
 		}
 	}
 	return lexer
@@ -90,6 +177,15 @@ func (t *textScannerLexer) Next() (Token, error) {
 	text := t.scanner.TokenText()
 	pos := Position(t.scanner.Position)
 	pos.Filename = t.filename
+	if t.columns != nil {
+		pos.Column = t.columns.columnAt(pos.Offset)
+	}
+	if t.whitespace && isWhitespace(typ) {
+		typ = Whitespace
+		for t.coalesce && isWhitespace(t.scanner.Peek()) {
+			text += string(t.scanner.Next())
+		}
+	}
 	return textScannerTransform(Token{
 		Type:  typ,
 		Value: text,
@@ -97,6 +193,48 @@ func (t *textScannerLexer) Next() (Token, error) {
 	})
 }
 
+func isWhitespace(r rune) bool {
+	return r >= 0 && r < 64 && scanner.GoWhitespace&(1<<uint(r)) != 0
+}
+
+// columnTracker records the input consumed by a text/scanner.Scanner in order to compute columns
+// with tabs expanded.
+type columnTracker struct {
+	tabWidth int
+	buf      []byte
+	// Offset and column of the first byte in buf.
+	offset int
+	column int
+}
+
+func (c *columnTracker) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+	return len(b), nil
+}
+
+// Returns the column of the given byte offset. Offsets must be monotonically increasing.
+func (c *columnTracker) columnAt(offset int) int {
+	n := offset - c.offset
+	if n > len(c.buf) {
+		n = len(c.buf)
+	}
+	for i := 0; i < n; {
+		rn, size := utf8.DecodeRune(c.buf[i:])
+		switch rn {
+		case '\n':
+			c.column = 1
+		case '\t':
+			c.column = ((c.column-1)/c.tabWidth+1)*c.tabWidth + 1
+		default:
+			c.column++
+		}
+		i += size
+	}
+	c.buf = c.buf[n:]
+	c.offset += n
+	return c.column
+}
+
 func textScannerTransform(token Token) (Token, error) {
 	// Unquote strings.
 	switch token.Type {
@@ -54,3 +54,70 @@ func BenchmarkTextScannerLexer(b *testing.B) {
 		_, _ = r.Seek(0, 0)
 	}
 }
+
+func TestTextScannerWhitespaceTokens(t *testing.T) {
+	def := TextScanner(WhitespaceTokens(true))
+	require.Equal(t, Whitespace, def.Symbols()["Whitespace"])
+	lex, err := def.Lex(strings.NewReader("a  \n\tb c"))
+	require.NoError(t, err)
+	tokens, err := ConsumeAll(lex)
+	require.NoError(t, err)
+	require.Equal(t, []Token{
+		{Type: scanner.Ident, Value: "a", Pos: Position{Offset: 0, Line: 1, Column: 1}},
+		{Type: Whitespace, Value: "  \n\t", Pos: Position{Offset: 1, Line: 1, Column: 2}},
+		{Type: scanner.Ident, Value: "b", Pos: Position{Offset: 5, Line: 2, Column: 2}},
+		{Type: Whitespace, Value: " ", Pos: Position{Offset: 6, Line: 2, Column: 3}},
+		{Type: scanner.Ident, Value: "c", Pos: Position{Offset: 7, Line: 2, Column: 4}},
+		{Type: EOF, Pos: Position{Offset: 8, Line: 2, Column: 5}},
+	}, tokens)
+
+	def = TextScanner(WhitespaceTokens(false))
+	lex, err = def.Lex(strings.NewReader("a  b"))
+	require.NoError(t, err)
+	tokens, err = ConsumeAll(lex)
+	require.NoError(t, err)
+	require.Equal(t, []Token{
+		{Type: scanner.Ident, Value: "a", Pos: Position{Offset: 0, Line: 1, Column: 1}},
+		{Type: Whitespace, Value: " ", Pos: Position{Offset: 1, Line: 1, Column: 2}},
+		{Type: Whitespace, Value: " ", Pos: Position{Offset: 2, Line: 1, Column: 3}},
+		{Type: scanner.Ident, Value: "b", Pos: Position{Offset: 3, Line: 1, Column: 4}},
+		{Type: EOF, Pos: Position{Offset: 4, Line: 1, Column: 5}},
+	}, tokens)
+
+	_, ok := TextScanner().Symbols()["Whitespace"]
+	require.False(t, ok)
+}
+
+func TestTextScannerTabWidth(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []TextScannerOption
+		columns []int
+	}{
+		{name: "Default", columns: []int{1, 3, 5, 1, 5}},
+		{name: "TabWidth4", options: []TextScannerOption{TabWidth(4)}, columns: []int{1, 5, 9, 1, 9}},
+		{name: "TabWidth8", options: []TextScannerOption{TabWidth(8)}, columns: []int{1, 9, 17, 1, 17}},
+		{name: "TabWidth4Coalesced", options: []TextScannerOption{TabWidth(4), WhitespaceTokens(true)}, columns: []int{1, 2, 5, 6, 9, 10, 1, 2, 9}},
+		{name: "TabWidth4PerChar", options: []TextScannerOption{TabWidth(4), WhitespaceTokens(false)}, columns: []int{1, 2, 5, 6, 9, 10, 1, 2, 3, 5, 9}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lex, err := TextScanner(test.options...).Lex(strings.NewReader("a\tb\tc\nd \t\te"))
+			require.NoError(t, err)
+			tokens, err := ConsumeAll(lex)
+			require.NoError(t, err)
+			columns := []int{}
+			for _, token := range tokens[:len(tokens)-1] {
+				columns = append(columns, token.Pos.Column)
+			}
+			require.Equal(t, test.columns, columns)
+		})
+	}
+}
+
+func TestPositionAdvance(t *testing.T) {
+	start := Position{Filename: "file", Offset: 2, Line: 1, Column: 3}
+	require.Equal(t, Position{Filename: "file", Offset: 8, Line: 2, Column: 3}, start.Advance("a\tb\n\tc", 1))
+	require.Equal(t, Position{Filename: "file", Offset: 8, Line: 2, Column: 6}, start.Advance("a\tb\n\tc", 4))
+	require.Equal(t, Position{Filename: "file", Offset: 4, Line: 1, Column: 9}, start.Advance("\t\t", 4))
+}
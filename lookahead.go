@@ -5,6 +5,7 @@ import (
 	"hash/fnv"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/alecthomas/participle/lexer"
 )
@@ -14,6 +15,8 @@ const lookaheadLimit = 32
 type lookahead struct {
 	root   int
 	tokens []lexer.Token
+	// Bit i is set if tokens[i] is a literal that does not match unquoted strings.
+	unquoted uint64
 }
 
 func (l lookahead) String() string {
@@ -32,22 +35,29 @@ func buildLookahead(nodes ...node) (table []lookahead, err error) {
 	l := &lookaheadWalker{limit: lookaheadLimit, seen: map[node]int{}}
 	for root, node := range nodes {
 		if node != nil {
-			l.push(root, node, nil)
+			l.push(node, lookahead{root: root})
 		}
 	}
 	depth := 0
 	for ; depth < lookaheadLimit; depth++ {
-		ambiguous := l.ambiguous()
+		ambiguous := append(l.identical(), l.overlapping()...)
 		if len(ambiguous) == 0 {
 			return l.collect(), nil
 		}
 		stepped := false
+		// A cursor may be ambiguous with several others, but must only be stepped once.
+		steps := map[*lookaheadCursor]bool{}
 		for _, group := range ambiguous {
 			for _, c := range group {
+				if steps[c] {
+					continue
+				}
+				steps[c] = true
 				// fmt.Printf("root=%d, depth=%d: %T %#v\n", c.root, c.depth, c.branch, c.token)
 				if l.step(c.branch, c) {
 					stepped = true
 				}
+				l.fork(c)
 			}
 			// fmt.Println()
 		}
@@ -55,6 +65,11 @@ func buildLookahead(nodes ...node) (table []lookahead, err error) {
 			break
 		}
 	}
+	if len(l.identical()) == 0 {
+		// Only cursors that overlap remain, eg. "PRINT" and Ident, which are ordered by collect()
+		// so that the literal is preferred.
+		return l.collect(), nil
+	}
 	// TODO: We should never fail to build lookahead.
 	return nil, fmt.Errorf("could not disambiguate after %d tokens of lookahead", depth)
 }
@@ -68,6 +83,47 @@ type lookaheadWalker struct {
 	seen    map[node]int
 	limit   int
 	cursors []*lookaheadCursor
+	// Alternative token types of set references stepped over by cursors.
+	forks []lookaheadFork
+}
+
+// An alternative token type at depth in the lookahead of a cursor.
+type lookaheadFork struct {
+	cursor *lookaheadCursor
+	depth  int
+	typ    rune
+}
+
+// Add a copy of cursor for each alternative token type of the set references it stepped over.
+//
+// This must occur once the cursor's branch has been updated by the step.
+func (l *lookaheadWalker) fork(cursor *lookaheadCursor) {
+	forks := l.forks[:0]
+	pending := []lookaheadFork{}
+	for _, f := range l.forks {
+		if f.cursor == cursor {
+			pending = append(pending, f)
+		} else {
+			forks = append(forks, f)
+		}
+	}
+	l.forks = forks
+	live := false
+	for _, c := range l.cursors {
+		live = live || c == cursor
+	}
+	if !live {
+		return
+	}
+	for _, f := range pending {
+		fork := &lookaheadCursor{branch: cursor.branch, lookahead: lookahead{
+			root:     cursor.root,
+			tokens:   append([]lexer.Token{}, cursor.tokens...),
+			unquoted: cursor.unquoted,
+		}}
+		fork.tokens[f.depth].Type = f.typ
+		l.cursors = append(l.cursors, fork)
+	}
 }
 
 func (l *lookaheadWalker) collect() []lookahead {
@@ -75,19 +131,27 @@ func (l *lookaheadWalker) collect() []lookahead {
 	for _, cursor := range l.cursors {
 		out = append(out, cursor.lookahead)
 	}
-	sort.Slice(out, func(i, j int) bool {
+	// Prefer the longest match, by number of tokens then by the length of the last token's value,
+	// so that eg. "<" "=" is selected over "<", and a literal over a token type.
+	sort.SliceStable(out, func(i, j int) bool {
 		n := len(out[i].tokens)
 		m := len(out[j].tokens)
-		if n > m {
-			return true
+		if n != m {
+			return n > m
+		}
+		if n > 0 {
+			a, b := len(out[i].tokens[n-1].Value), len(out[j].tokens[m-1].Value)
+			if a != b {
+				return a > b
+			}
 		}
-		return (n == m && len(out[i].tokens[n-1].Value) > len(out[j].tokens[m-1].Value)) || out[i].root < out[j].root
+		return out[i].root < out[j].root
 	})
 	return out
 }
 
-// Find cursors that are still ambiguous.
-func (l *lookaheadWalker) ambiguous() [][]*lookaheadCursor {
+// Find cursors that are still ambiguous because their lookahead is identical.
+func (l *lookaheadWalker) identical() [][]*lookaheadCursor {
 	grouped := map[uint64][]*lookaheadCursor{}
 	for _, cursor := range l.cursors {
 		key := cursor.hash()
@@ -102,16 +166,57 @@ func (l *lookaheadWalker) ambiguous() [][]*lookaheadCursor {
 	return out
 }
 
-func (l *lookaheadWalker) push(root int, node node, tokens []lexer.Token) {
+// Find cursors for different branches that are not identical but may still match the same input,
+// eg. "rows":Ident and Ident. These are ambiguous while either can be extended.
+func (l *lookaheadWalker) overlapping() [][]*lookaheadCursor {
+	out := [][]*lookaheadCursor{}
+	for i, a := range l.cursors {
+		for _, b := range l.cursors[i+1:] {
+			if a.root != b.root && (a.branch != nil || b.branch != nil) && a.hash() != b.hash() && a.overlaps(b.lookahead) {
+				out = append(out, []*lookaheadCursor{a, b})
+			}
+		}
+	}
+	return out
+}
+
+// Returns true if both lookaheads are the same length and could match the same tokens.
+//
+// An untyped literal, eg. "PRINT", does not overlap a reference such as Ident, as the literal is
+// preferred by collect(). Only a token matching any type and value, such as that of a negation,
+// overlaps tokens of other types.
+func (l lookahead) overlaps(other lookahead) bool {
+	if len(l.tokens) != len(other.tokens) {
+		return false
+	}
+	for i, t := range l.tokens {
+		o := other.tokens[i]
+		if t.Type != o.Type && !isWildcard(t) && !isWildcard(o) {
+			return false
+		}
+		if t.Value != o.Value && t.Value != "" && o.Value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func isWildcard(t lexer.Token) bool {
+	return t.Type == lexer.EOF && t.Value == ""
+}
+
+func (l *lookaheadWalker) push(node node, from lookahead) {
 	cursor := &lookaheadCursor{
 		branch: node,
 		lookahead: lookahead{
-			root:   root,
-			tokens: append([]lexer.Token{}, tokens...),
+			root:     from.root,
+			tokens:   append([]lexer.Token{}, from.tokens...),
+			unquoted: from.unquoted,
 		},
 	}
 	l.cursors = append(l.cursors, cursor)
 	l.step(node, cursor)
+	l.fork(cursor)
 }
 
 func (l *lookaheadWalker) remove(cursor *lookaheadCursor) {
@@ -131,19 +236,55 @@ func (l *lookaheadWalker) step(node node, cursor *lookaheadCursor) bool {
 	switch n := node.(type) {
 	case *disjunction:
 		for _, c := range n.nodes {
-			l.push(cursor.root, c, cursor.tokens)
+			l.push(c, cursor.lookahead)
+		}
+		l.remove(cursor)
+
+	case *unordered:
+		for _, c := range n.nodes {
+			l.push(c, cursor.lookahead)
+		}
+		l.remove(cursor)
+
+	case *record:
+		for _, c := range n.nodes {
+			l.push(c, cursor.lookahead)
 		}
 		l.remove(cursor)
 
+	case *exclusive:
+		l.step(&n.disjunction, cursor)
+
+	case *union:
+		l.step(&n.disjunction, cursor)
+
+	case *precedence:
+		l.step(n.grammar, cursor)
+
 	case *sequence:
 		if n != nil {
 			l.step(n.node, cursor)
-			cursor.branch = n.next
+			if _, ok := n.node.(*positiveLookahead); ok {
+				// The tokens that follow must match the assertion, so its lookahead is used.
+				break
+			}
+			if _, ok := n.node.(*negation); ok {
+				// The number of tokens matched is unknown, so nothing that follows can be looked at.
+				break
+			}
+			if n.next != nil {
+				cursor.branch = n.next
+			} else {
+				cursor.branch = nil
+			}
 		}
 
 	case *capture:
 		l.step(n.node, cursor)
 
+	case *versioned:
+		l.step(n.node, cursor)
+
 	case *strct:
 		l.step(n.expr, cursor)
 
@@ -154,15 +295,18 @@ func (l *lookaheadWalker) step(node node, cursor *lookaheadCursor) bool {
 		}
 
 	case *repetition:
-		l.push(cursor.root, n.node, cursor.tokens)
+		l.push(n.node, cursor.lookahead)
 		if n.next != nil {
-			l.push(cursor.root, n.next, cursor.tokens)
+			l.push(n.next, cursor.lookahead)
 		}
 		l.remove(cursor)
 
 	case *parseable:
 
 	case *literal:
+		if n.unquoted {
+			cursor.unquoted |= 1 << uint(len(cursor.tokens))
+		}
 		cursor.tokens = append(cursor.tokens, lexer.Token{Type: n.t, Value: n.s})
 		cursor.branch = nil
 		return true
@@ -170,6 +314,31 @@ func (l *lookaheadWalker) step(node node, cursor *lookaheadCursor) bool {
 	case *reference:
 		cursor.tokens = append(cursor.tokens, lexer.Token{Type: n.typ})
 		cursor.branch = nil
+		for _, typ := range n.set {
+			l.forks = append(l.forks, lookaheadFork{cursor: cursor, depth: len(cursor.tokens) - 1, typ: typ})
+		}
+
+	case *keywordSet:
+		cursor.tokens = append(cursor.tokens, lexer.Token{Type: n.typ})
+		cursor.branch = nil
+
+	case *signedNumber:
+		l.step(n.grammar, cursor)
+
+	case *positiveLookahead:
+		l.step(n.node, cursor)
+
+	case *negation:
+		// Matches any token, or none if the stop expression matches.
+		cursor.tokens = append(cursor.tokens, lexer.Token{Type: lexer.EOF})
+		cursor.branch = nil
+
+	case *cut, *column:
+		// Columns are not tracked by lookahead, so a column assertion is assumed to match.
+
+	case *epsilon:
+		// Consumes nothing, so a branch ending here has no further lookahead tokens and
+		// is selected for any input that the longer lookahead of other branches does not match.
 
 	default:
 		panic(fmt.Sprintf("unsupported node type %T", n))
@@ -178,21 +347,142 @@ func (l *lookaheadWalker) step(node node, cursor *lookaheadCursor) bool {
 	return true
 }
 
+// LookaheadTableSize describes the lookahead table computed for a single branch point.
+type LookaheadTableSize struct {
+	// Production is the name of the grammar struct containing the branch.
+	Production string
+	// Node is the grammar fragment the table disambiguates.
+	Node string
+	// Size is the number of entries in the table.
+	Size int
+}
+
+type lookaheadBuilder struct {
+	seen       map[node]bool
+	max        int
+	report     func(LookaheadTableSize)
+	production string
+	// Root of the grammar, and its FIRST and FOLLOW sets once computed.
+	root     node
+	analysis *grammarAnalysis
+}
+
 func applyLookahead(m node, seen map[node]bool) error {
-	if seen[m] {
+	b := &lookaheadBuilder{seen: seen, root: m}
+	return b.apply(m)
+}
+
+// Build the lookahead table for a branch point, enforcing the size limit and reporting its size.
+func (b *lookaheadBuilder) build(m node, nodes ...node) ([]lookahead, error) {
+	lookahead, err := buildLookahead(nodes...)
+	if err != nil {
+		o, ok := m.(*optional)
+		if ok {
+			lookahead, ok = b.resolveOptional(o)
+		}
+		if !ok {
+			return nil, Error(err.Error() + ": " + m.String())
+		}
+	}
+	size := LookaheadTableSize{Production: b.production, Node: m.String(), Size: len(lookahead)}
+	if b.report != nil {
+		b.report(size)
+	}
+	if b.max > 0 && size.Size > b.max {
+		return nil, Error(fmt.Sprintf("lookahead table for %s has %d entries, exceeding the limit of %d: %s",
+			size.Production, size.Size, b.max, size.Node))
+	}
+	return lookahead, nil
+}
+
+// Resolve an optional that is ambiguous with its continuation using FIRST and FOLLOW sets.
+//
+// The optional is matched only if the next token can begin it but cannot begin its continuation,
+// which is the remainder of its sequence followed by whatever can follow that.
+func (b *lookaheadBuilder) resolveOptional(n *optional) ([]lookahead, bool) {
+	if b.analysis == nil {
+		b.analysis = analyseGrammar(b.root)
+		b.analysis.computeFollow(b.root)
+	}
+	first, _ := b.analysis.firstOf(n.node)
+	if len(first) == 0 {
+		return nil, false
+	}
+	matches := []lexer.Token{}
+	for _, symbol := range first.sorted() {
+		matches = append(matches, b.analysis.tokens[symbol])
+	}
+	table := []lookahead{}
+	// Tokens that can begin the continuation take precedence over those of the optional.
+	for _, symbol := range b.analysis.continuation[n].sorted() {
+		token, ok := b.analysis.tokens[symbol]
+		if !ok {
+			continue
+		}
+		skip := lookahead{root: 1, tokens: []lexer.Token{token}}
+		for _, match := range matches {
+			if skip.overlaps(lookahead{tokens: []lexer.Token{match}}) {
+				table = append(table, skip)
+				break
+			}
+		}
+	}
+	for _, match := range matches {
+		table = append(table, lookahead{root: 0, tokens: []lexer.Token{match}})
+	}
+	return append(table, lookahead{root: 1}), true
+}
+
+func (b *lookaheadBuilder) apply(m node) error {
+	if b.seen[m] {
 		return nil
 	}
-	seen[m] = true
+	b.seen[m] = true
 	switch n := m.(type) {
 	case *disjunction:
-		lookahead, err := buildLookahead(n.nodes...)
-		if err == nil {
-			n.lookahead = lookahead
-		} else {
-			return Error(err.Error() + ": " + n.String())
+		lookahead, err := b.build(n, n.nodes...)
+		if err != nil {
+			return err
+		}
+		n.lookahead = lookahead
+		n.dispatch = buildDispatch(lookahead)
+		for _, c := range n.nodes {
+			err := b.apply(c)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *exclusive:
+		return b.apply(&n.disjunction)
+
+	case *union:
+		return b.apply(&n.disjunction)
+
+	case *precedence:
+		return b.apply(n.grammar)
+
+	case *unordered:
+		lookahead, err := b.build(n, n.nodes...)
+		if err != nil {
+			return err
+		}
+		n.lookahead = lookahead
+		for _, c := range n.nodes {
+			err := b.apply(c)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *record:
+		lookahead, err := b.build(n, n.nodes...)
+		if err != nil {
+			return err
 		}
+		n.lookahead = lookahead
 		for _, c := range n.nodes {
-			err := applyLookahead(c, seen)
+			err := b.apply(c)
 			if err != nil {
 				return err
 			}
@@ -200,7 +490,7 @@ func applyLookahead(m node, seen map[node]bool) error {
 
 	case *sequence:
 		for c := n; c != nil; c = c.next {
-			err := applyLookahead(c.node, seen)
+			err := b.apply(c.node)
 			if err != nil {
 				return err
 			}
@@ -209,50 +499,66 @@ func applyLookahead(m node, seen map[node]bool) error {
 	case *literal:
 
 	case *capture:
-		err := applyLookahead(n.node, seen)
+		err := b.apply(n.node)
 		if err != nil {
 			return err
 		}
 
 	case *reference:
 
+	case *keywordSet:
+
+	case *signedNumber:
+
+	case *positiveLookahead:
+		return b.apply(n.node)
+
+	case *versioned:
+		return b.apply(n.node)
+
+	case *negation:
+		return b.apply(n.stop)
+
+	case *cut, *column, *epsilon:
+
 	case *strct:
-		err := applyLookahead(n.expr, seen)
+		production := b.production
+		b.production = n.typ.Name()
+		err := b.apply(n.expr)
+		b.production = production
 		if err != nil {
 			return err
 		}
 
 	case *optional:
-		lookahead, err := buildLookahead(n.node, n.next)
-		if err == nil {
-			n.lookahead = lookahead
-		} else {
-			return Error(err.Error() + ": " + n.String())
+		lookahead, err := b.build(n, n.node, n.next)
+		if err != nil {
+			return err
 		}
-		err = applyLookahead(n.node, seen)
+		n.lookahead = lookahead
+		err = b.apply(n.node)
 		if err != nil {
 			return err
 		}
 		if n.next != nil {
-			err = applyLookahead(n.next, seen)
+			err = b.apply(n.next)
 			if err != nil {
 				return err
 			}
 		}
 
 	case *repetition:
-		lookahead, err := buildLookahead(n.node, n.next)
-		if err == nil {
-			n.lookahead = lookahead
-		} else {
-			return Error(err.Error() + ": " + n.String())
+		lookahead, err := b.build(n, n.node, n.next)
+		if err != nil {
+			return err
 		}
-		err = applyLookahead(n.node, seen)
+		n.lookahead = lookahead
+		err = b.apply(n.node)
 		if err != nil {
 			return err
 		}
 		if n.next != nil {
-			err = applyLookahead(n.next, seen)
+			err = b.apply(n.next)
 			if err != nil {
 				return err
 			}
@@ -273,21 +579,129 @@ type lookaheadTable []lookahead
 //
 // Will return -2 if lookahead table is missing, -1 for no match, or index of selected node.
 func (l lookaheadTable) Select(lex lexer.PeekingLexer, parent reflect.Value) (selected int, err error) {
+	return l.selectFrom(lex, nil)
+}
+
+// Select node to use, ignoring nodes whose index is true in exclude.
+func (l lookaheadTable) selectFrom(lex lexer.PeekingLexer, exclude []bool) (selected int, err error) {
 	if l == nil {
 		return -2, nil
 	}
+	peeked := 0
+	defer func() { recordPeekDepth(lex, peeked) }()
+	var quoted map[rune]bool
+	if ctx, ok := lex.(parseContext); ok {
+		quoted = ctx.quoted
+	}
 next:
 	for _, look := range l {
+		if exclude != nil && exclude[look.root] {
+			continue
+		}
 		for depth, lt := range look.tokens {
 			t, err := lex.Peek(depth)
 			if err != nil {
 				return 0, err
 			}
+			if depth >= peeked {
+				peeked = depth + 1
+			}
 			if !((lt.Value == "" || lt.Value == t.Value) && (lt.Type == lexer.EOF || lt.Type == t.Type)) {
 				continue next
 			}
+			if look.unquoted&(1<<uint(depth)) != 0 && quoted[t.Type] {
+				continue next
+			}
 		}
 		return look.root, nil
 	}
 	return -1, nil
 }
+
+// LookaheadStats records how many tokens each lookahead selection peeked at, when provided to
+// WithLookaheadStats().
+//
+// This can be used to determine whether MaxLookaheadTable() or the grammar itself needs tuning.
+type LookaheadStats struct {
+	// Selections is the number of selections made at each peek depth.
+	Selections map[int]int
+	// MaxDepth is the greatest number of tokens peeked at by a single selection.
+	MaxDepth int
+}
+
+func (s *LookaheadStats) record(depth int) {
+	if s.Selections == nil {
+		s.Selections = map[int]int{}
+	}
+	s.Selections[depth]++
+	if depth > s.MaxDepth {
+		s.MaxDepth = depth
+	}
+}
+
+// String returns a histogram of the number of selections at each peek depth.
+func (s *LookaheadStats) String() string {
+	most := 0
+	for _, count := range s.Selections {
+		if count > most {
+			most = count
+		}
+	}
+	w := &strings.Builder{}
+	for depth := 0; depth <= s.MaxDepth; depth++ {
+		count := s.Selections[depth]
+		if count == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%3d: %-8d %s\n", depth, count, strings.Repeat("#", (count*40+most-1)/most))
+	}
+	return w.String()
+}
+
+// Record the peek depth of a selection, if the parse is collecting statistics.
+func recordPeekDepth(lex lexer.PeekingLexer, depth int) {
+	if ctx, ok := lex.(parseContext); ok && ctx.lookaheadStats != nil {
+		ctx.lookaheadStats.record(depth)
+	}
+}
+
+// A lookaheadDispatch selects a node directly from the value of the next token.
+//
+// It is used in place of a lookaheadTable when every entry in the table is a single distinct
+// literal, such as a disjunction of statements that each begin with a unique keyword, making
+// selection O(1) rather than O(branches).
+type lookaheadDispatch map[string]lookahead
+
+// Build a dispatch table from a lookahead table, or return nil if the table is not eligible.
+func buildDispatch(table lookaheadTable) lookaheadDispatch {
+	if len(table) < 2 {
+		return nil
+	}
+	dispatch := lookaheadDispatch{}
+	for _, look := range table {
+		if len(look.tokens) != 1 || look.tokens[0].Value == "" || look.unquoted != 0 {
+			return nil
+		}
+		if _, ok := dispatch[look.tokens[0].Value]; ok {
+			return nil
+		}
+		dispatch[look.tokens[0].Value] = look
+	}
+	return dispatch
+}
+
+// Select node to use.
+//
+// Will return -1 for no match, or index of selected node.
+func (l lookaheadDispatch) Select(lex lexer.PeekingLexer) (selected int, err error) {
+	t, err := lex.Peek(0)
+	if err != nil {
+		return 0, err
+	}
+	recordPeekDepth(lex, 1)
+	look, ok := l[t.Value]
+	if !ok || (look.tokens[0].Type != lexer.EOF && look.tokens[0].Type != t.Type) {
+		return -1, nil
+	}
+	return look.root, nil
+}
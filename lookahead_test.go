@@ -1,9 +1,13 @@
 package participle
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/participle/lexer"
 )
 
 type LAT1Module struct {
@@ -355,3 +359,345 @@ func TestLookaheadDisambiguateByType(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, &grammar{Float: -100.5}, actual)
 }
+
+func TestLookaheadDispatch(t *testing.T) {
+	type grammar struct {
+		Let   string `  "let" @Ident`
+		Print string `| "print" @Ident`
+		Exit  bool   `| @"exit"`
+	}
+	p := mustTestParser(t, &grammar{}, UseLookahead())
+	require.NotNil(t, p.root.(*strct).expr.(*disjunction).dispatch)
+
+	actual := &grammar{}
+	err := p.ParseString(`print a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Print: "a"}, actual)
+
+	actual = &grammar{}
+	err = p.ParseString(`exit`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Exit: true}, actual)
+
+	err = p.ParseString(`goto a`, &grammar{})
+	require.Error(t, err)
+}
+
+func TestLookaheadDispatchFallback(t *testing.T) {
+	type grammar struct {
+		A string `  "target" "a" @Ident`
+		B string `| "target" "b" @Ident`
+		C string `| @Ident`
+	}
+	p := mustTestParser(t, &grammar{}, UseLookahead())
+	require.Nil(t, p.root.(*strct).expr.(*disjunction).dispatch)
+
+	actual := &grammar{}
+	err := p.ParseString(`target b c`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{B: "c"}, actual)
+}
+
+func TestMaxLookaheadTable(t *testing.T) {
+	type grammar struct {
+		A string `  "a" @Ident`
+		B string `| "b" @Ident`
+		C string `| "c" @Ident`
+	}
+	sizes := []LookaheadTableSize{}
+	_, err := Build(&grammar{}, UseLookahead(), ReportLookaheadTables(func(size LookaheadTableSize) {
+		sizes = append(sizes, size)
+	}))
+	require.NoError(t, err)
+	require.Len(t, sizes, 1)
+	require.Equal(t, "grammar", sizes[0].Production)
+	require.Equal(t, 3, sizes[0].Size)
+
+	_, err = Build(&grammar{}, UseLookahead(), MaxLookaheadTable(3))
+	require.NoError(t, err)
+
+	_, err = Build(&grammar{}, UseLookahead(), MaxLookaheadTable(2))
+	require.EqualError(t, err, `lookahead table for grammar has 3 entries, exceeding the limit of 2: `+sizes[0].Node)
+}
+
+func BenchmarkLookaheadDispatch(b *testing.B) {
+	d := &disjunction{}
+	for i := 0; i < 200; i++ {
+		d.nodes = append(d.nodes, &literal{s: fmt.Sprintf("keyword%d", i), t: lexer.EOF})
+	}
+	err := applyLookahead(d, map[node]bool{})
+	require.NoError(b, err)
+	require.NotNil(b, d.dispatch)
+	dispatch := d.dispatch
+	ctx := parseContext{BufferedLexer: lexer.Buffer(lexer.LexString("keyword199"))}
+	parent := reflect.Value{}
+
+	b.Run("Linear", func(b *testing.B) {
+		d.dispatch = nil
+		for i := 0; i < b.N; i++ {
+			_, _ = d.selectBranch(ctx, parent)
+		}
+	})
+	b.Run("Dispatch", func(b *testing.B) {
+		d.dispatch = dispatch
+		for i := 0; i < b.N; i++ {
+			_, _ = d.selectBranch(ctx, parent)
+		}
+	})
+}
+
+func TestLookaheadSoftKeyword(t *testing.T) {
+	type fetch struct {
+		Count int  `"fetch" @Int`
+		Rows  bool `@"rows":Ident`
+	}
+	type statement struct {
+		Fetch *fetch `  @@`
+		Rows  bool   `| @"rows":Ident "only"`
+		Ident string `| @Ident`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{}, UseLookahead())
+	actual := &grammar{}
+	err := p.ParseString(`rows fetch 10 rows rows only`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Statements: []*statement{
+		{Ident: "rows"},
+		{Fetch: &fetch{Count: 10, Rows: true}},
+		{Rows: true},
+	}}, actual)
+}
+
+func TestLookaheadKeywordOverIdent(t *testing.T) {
+	type command struct {
+		Print *lookaheadExpr `(  "PRINT" @@`
+		Goto  string         ` | "GOTO" @Ident`
+		Call  *lookaheadCall ` | @@ ) ";"`
+	}
+	type grammar struct {
+		Commands []*command `{ @@ }`
+	}
+	// The keywords are not reserved, so "PRINT" overlaps the identifier beginning a call, but the
+	// literal is preferred.
+	p := mustTestParser(t, &grammar{}, UseLookahead())
+	actual := &grammar{}
+	err := p.ParseString(`PRINT (a); GOTO b; f(x + (1), y); PRINT c;`, actual)
+	require.NoError(t, err)
+	a, c, x, y := "a", "c", "x", "y"
+	one := 1
+	require.Equal(t, &grammar{Commands: []*command{
+		{Print: &lookaheadExpr{Left: &lookaheadValue{Group: &lookaheadExpr{Left: &lookaheadValue{Variable: &a}}}}},
+		{Goto: "b"},
+		{Call: &lookaheadCall{Name: "f", Args: []*lookaheadExpr{
+			{Left: &lookaheadValue{Variable: &x}, Right: []*lookaheadValue{{Group: &lookaheadExpr{Left: &lookaheadValue{Number: &one}}}}},
+			{Left: &lookaheadValue{Variable: &y}},
+		}}},
+		{Print: &lookaheadExpr{Left: &lookaheadValue{Variable: &c}}},
+	}}, actual)
+}
+
+type lookaheadCall struct {
+	Name string           `@Ident`
+	Args []*lookaheadExpr `"(" [ @@ { "," @@ } ] ")"`
+}
+
+type lookaheadValue struct {
+	Number   *int           `  @Int`
+	Variable *string        `| @Ident`
+	Call     *lookaheadCall `| @@`
+	Group    *lookaheadExpr `| "(" @@ ")"`
+}
+
+type lookaheadExpr struct {
+	Left  *lookaheadValue   `@@`
+	Right []*lookaheadValue `{ "+" @@ }`
+}
+
+func TestLookaheadOptionalFollowSet(t *testing.T) {
+	type grammar struct {
+		Count int    `[ @Int | @Ident ]`
+		Name  string `@Ident`
+	}
+	p := mustTestParser(t, &grammar{}, UseLookahead())
+
+	actual := &grammar{}
+	err := p.ParseString(`10 name`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Count: 10, Name: "name"}, actual)
+
+	// Identifiers can begin the continuation, so the optional is not matched.
+	actual = &grammar{}
+	err = p.ParseString(`name`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Name: "name"}, actual)
+}
+
+func TestNoLookahead(t *testing.T) {
+	type grammar struct {
+		X []int `  { @Int } "x"`
+		Y []int `| { @Int } "y"`
+	}
+	_, err := Build(&grammar{}, UseLookahead())
+	require.Error(t, err)
+
+	p := mustTestParser(t, &grammar{}, UseLookahead(), NoLookahead())
+	actual := &grammar{}
+	err = p.ParseString(`1 2 3 y`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Y: []int{1, 2, 3}}, actual)
+
+	err = p.ParseString(`1 2 3 z`, &grammar{})
+	require.EqualError(t, err, `<source>:1:1: expected ( <int> ) | ( <int> ) but got "1"`)
+}
+
+func TestNoLookaheadOrderedChoice(t *testing.T) {
+	type call struct {
+		Name string `@Ident "(" ")"`
+	}
+	type assign struct {
+		Name  string `@Ident "="`
+		Value int    `@Int`
+	}
+	type statement struct {
+		Call   *call   `  @@`
+		Assign *assign `| @@`
+	}
+	type grammar struct {
+		Optional string       `[ @Ident ":" ]`
+		Items    []*statement `{ @@ ";" }`
+	}
+	p := mustTestParser(t, &grammar{})
+	err := p.ParseString(`a = 1;`, &grammar{})
+	require.Error(t, err)
+
+	p = mustTestParser(t, &grammar{}, NoLookahead())
+	actual := &grammar{}
+	err = p.ParseString(`a = 1; f(); b = 2;`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Items: []*statement{
+		{Assign: &assign{Name: "a", Value: 1}},
+		{Call: &call{Name: "f"}},
+		{Assign: &assign{Name: "b", Value: 2}},
+	}}, actual)
+
+	actual = &grammar{}
+	err = p.ParseString(`label: f();`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Optional: "label", Items: []*statement{{Call: &call{Name: "f"}}}}, actual)
+}
+
+func TestLookaheadStats(t *testing.T) {
+	type statement struct {
+		Assign string `  @Ident "=" @Int`
+		Call   string `| @Ident "(" ")"`
+		Print  string `| "print" @Int`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ ";" }`
+	}
+	p := mustTestParser(t, &grammar{}, UseLookahead())
+	stats := &LookaheadStats{}
+	err := p.ParseString(`a = 1; f(); print 2;`, &grammar{}, WithLookaheadStats(stats))
+	require.NoError(t, err)
+	require.Equal(t, &LookaheadStats{Selections: map[int]int{1: 1, 2: 4}, MaxDepth: 2}, stats)
+	require.Equal(t, ""+
+		"  1: 1        ##########\n"+
+		"  2: 4        ########################################\n",
+		stats.String())
+}
+
+func TestCut(t *testing.T) {
+	type words struct {
+		Words []string `@Ident { @Ident }`
+	}
+	type let struct {
+		Name  string `"let" ! @Ident "="`
+		Value int    `@Int`
+	}
+	type uncut struct {
+		Name  string `"let" @Ident "="`
+		Value int    `@Int`
+	}
+	type grammar struct {
+		Let   *let   `  @@`
+		Words *words `| @@`
+	}
+	type uncutGrammar struct {
+		Let   *uncut `  @@`
+		Words *words `| @@`
+	}
+	for _, option := range []Option{NoLookahead(), LongestMatch()} {
+		p := mustTestParser(t, &uncutGrammar{}, option)
+		err := p.ParseString(`let x = y`, &uncutGrammar{})
+		require.EqualError(t, err, `<source>:1:7: expected "let" | <ident> but got "="`)
+
+		p = mustTestParser(t, &grammar{}, option)
+		err = p.ParseString(`let x = y`, &grammar{})
+		require.EqualError(t, err, `<source>:1:9: unexpected "y" (expected <int>)`)
+
+		actual := &grammar{}
+		err = p.ParseString(`let x = 1`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Let: &let{Name: "x", Value: 1}}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`a b`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Words: &words{Words: []string{"a", "b"}}}, actual)
+	}
+
+	type optional struct {
+		Key   string `[ @Ident ! ":" ]`
+		Value string `@Ident`
+	}
+	p := mustTestParser(t, &optional{}, NoLookahead())
+	err := p.ParseString(`a b`, &optional{})
+	require.EqualError(t, err, `<source>:1:3: unexpected "b" (expected ":")`)
+	actual := &optional{}
+	err = p.ParseString(`a: b`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &optional{Key: "a", Value: "b"}, actual)
+}
+
+func TestLookaheadOperatorPrefixes(t *testing.T) {
+	lt := func(values ...string) []lexer.Token {
+		out := []lexer.Token{}
+		for _, value := range values {
+			out = append(out, lexer.Token{Type: lexer.EOF, Value: value})
+		}
+		return out
+	}
+	// Cursors are not in root order once forked, so the table must be ordered by length alone.
+	l := &lookaheadWalker{cursors: []*lookaheadCursor{
+		{lookahead: lookahead{root: 1, tokens: lt("<", "=")}},
+		{lookahead: lookahead{root: 0, tokens: lt("<")}},
+		{lookahead: lookahead{root: 3, tokens: append(lt("<"), lexer.Token{Type: lexer.TextScannerLexer.Symbols()["Ident"]})}},
+		{lookahead: lookahead{root: 2, tokens: lt("<", "<", "=")}},
+	}}
+	table := lookaheadTable(l.collect())
+	roots := []int{}
+	for _, look := range table {
+		roots = append(roots, look.root)
+	}
+	require.Equal(t, []int{2, 1, 3, 0}, roots)
+	for input, expected := range map[string]int{"<": 0, "<=": 1, "<<=": 2, "< a": 3} {
+		selected, err := table.Select(lexer.Upgrade(lexer.LexString(input)), reflect.Value{})
+		require.NoError(t, err)
+		require.Equal(t, expected, selected, input)
+	}
+
+	type comparison struct {
+		Left  string `@Ident`
+		Op    string `@( "<" | "<" "=" | "<" "<" | "<" "<" "=" | ">" | ">" "=" )`
+		Right string `@Ident`
+	}
+	p := mustTestParser(t, &comparison{}, UseLookahead())
+	for _, op := range []string{"<", "<=", "<<", "<<=", ">", ">="} {
+		actual := &comparison{}
+		err := p.ParseString(fmt.Sprintf("a %s b", op), actual)
+		require.NoError(t, err)
+		require.Equal(t, &comparison{Left: "a", Op: op, Right: "b"}, actual)
+	}
+}
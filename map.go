@@ -12,6 +12,8 @@ import (
 type mapperByToken struct {
 	symbols []string
 	mapper  Mapper
+	// If set, creates a Mapper with its own state for each lexer, applied to all tokens.
+	stateful func(symbols map[string]rune) Mapper
 }
 
 // DropToken can be returned by a Mapper to remove a token from the stream.
@@ -40,18 +42,71 @@ func Map(mapper Mapper, symbols ...string) Option {
 // Unquote applies strconv.Unquote() to tokens of the given types.
 //
 // Tokens of type "String" will be unquoted if no other types are provided.
+//
+// Strings unquoted by Unquote(), UnquoteDoubled() or the lexer itself still match literals in the
+// grammar, but never match the token values given to options, such as the sync tokens of
+// Recover() or the operators of Precedence(), so that the string ";" is not taken for the
+// punctuation ";".
 func Unquote(types ...string) Option {
 	if len(types) == 0 {
 		types = []string{"String"}
 	}
-	return Map(func(t lexer.Token) (lexer.Token, error) {
+	return quoted(Map(func(t lexer.Token) (lexer.Token, error) {
 		value, err := unquote(t.Value)
 		if err != nil {
 			return t, lexer.Errorf(t.Pos, "invalid quoted string %q: %s", t.Value, err.Error())
 		}
 		t.Value = value
 		return t, nil
-	}, types...)
+	}, types...), types)
+}
+
+// UnquoteDoubled removes the quotes surrounding tokens of the given types, within which a quote
+// is escaped by doubling it, as in CSV and SQL, eg. `"say ""hi"", then go"` becomes
+// `say "hi", then go`. Any other character, including a newline, is taken literally.
+//
+// Tokens of type "String" will be unquoted if no other types are provided. As with Unquote(), the
+// unquoted strings never match token values given to options.
+func UnquoteDoubled(types ...string) Option {
+	if len(types) == 0 {
+		types = []string{"String"}
+	}
+	return quoted(Map(func(t lexer.Token) (lexer.Token, error) {
+		value, err := unquoteDoubled(t.Value)
+		if err != nil {
+			return t, lexer.Errorf(t.Pos, "invalid quoted string %q: %s", t.Value, err.Error())
+		}
+		t.Value = value
+		return t, nil
+	}, types...), types)
+}
+
+// Record that the unquoter removes the quotes of tokens of the given types.
+func quoted(unquoter Option, types []string) Option {
+	return func(p *Parser) error {
+		p.quoted = append(p.quoted, types...)
+		return unquoter(p)
+	}
+}
+
+// Returns true if token has the value given to an option, and is not an unquoted string (see
+// Unquote()).
+func isValue(quoted map[rune]bool, token lexer.Token, value string) bool {
+	return token.Value == value && !quoted[token.Type]
+}
+
+func unquoteDoubled(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", errors.New("missing closing quote")
+	}
+	quote := s[:1]
+	parts := strings.Split(s[1:len(s)-1], quote+quote)
+	for _, part := range parts {
+		if strings.Contains(part, quote) {
+			return "", errors.New("unescaped quote")
+		}
+	}
+	return strings.Join(parts, quote), nil
 }
 
 func unquote(s string) (string, error) {
@@ -84,10 +139,115 @@ func Elide(types ...string) Option {
 	}, types...)
 }
 
+// DocComments drops tokens of the specified types and binds them to the following struct.
+//
+// A contiguous run of these tokens immediately preceding a struct is assigned, joined by newlines,
+// to the struct's "Doc string" field if present. Any other token that reaches the grammar between
+// the run and the struct discards the run. Each run is assigned to the outermost struct only.
+//
+// A token of these types following the last token of a struct on the same line is instead
+// assigned to the struct's "TrailingComment string" field if present, eg. "// in seconds" in
+// "timeout = 30 // in seconds". It is assigned to the innermost struct with the field ending at
+// that line, and is then not part of the Doc of the following struct.
+func DocComments(types ...string) Option {
+	elide := Elide(types...)
+	return func(p *Parser) error {
+		p.docComments = append(p.docComments, types...)
+		return elide(p)
+	}
+}
+
+// ElideValues drops tokens of the specified type only when their value is one of values.
+//
+// Other tokens of the same type are retained. Values are compared after any preceding mappers
+// have been applied.
+func ElideValues(symbol string, values ...string) Option {
+	elide := map[string]bool{}
+	for _, value := range values {
+		elide[value] = true
+	}
+	return Map(func(token lexer.Token) (lexer.Token, error) {
+		if elide[token.Value] {
+			return lexer.Token{}, DropToken
+		}
+		return token, nil
+	}, symbol)
+}
+
+// ElideOutside drops tokens of the specified types, except between the open and close delimiters.
+//
+// This allows eg. whitespace to be significant only within "{{" and "}}" in a template. The
+// delimiters are token values, which unquoted strings never match (see Unquote()), and may be
+// nested.
+func ElideOutside(open, close string, types ...string) Option {
+	return func(p *Parser) error {
+		p.mappers = append(p.mappers, mapperByToken{symbols: types, stateful: func(symbols map[string]rune) Mapper {
+			elide := map[rune]bool{}
+			for _, symbol := range types {
+				elide[symbols[symbol]] = true
+			}
+			depth := 0
+			return func(token lexer.Token) (lexer.Token, error) {
+				switch {
+				case isValue(p.quotedTypes, token, open):
+					depth++
+				case isValue(p.quotedTypes, token, close) && depth > 0:
+					depth--
+				case depth == 0 && elide[token.Type]:
+					return lexer.Token{}, DropToken
+				}
+				return token, nil
+			}
+		}})
+		return nil
+	}
+}
+
+// StatementNewlines drops tokens of type newline except those immediately following a token that
+// can end a statement, mimicking Go's automatic semicolon insertion, eg.
+//
+//	participle.StatementNewlines("Newline", "Ident", "Int", "String", "return", ")", "]", "}")
+//
+// Each of enders is either the name of a token type, eg. "Ident", or otherwise a token value, eg.
+// ")". Only the first newline after such a token is kept, so that blank lines do not produce
+// empty statements. Newlines within an expression, eg. after a "+" or "(", are dropped, so that
+// grammars need only match newlines where statements end. Tokens dropped by earlier mappers,
+// such as comments, are ignored.
+func StatementNewlines(newline string, enders ...string) Option {
+	return func(p *Parser) error {
+		p.mappers = append(p.mappers, mapperByToken{symbols: []string{newline}, stateful: func(symbols map[string]rune) Mapper {
+			types := map[rune]bool{}
+			values := map[string]bool{}
+			for _, ender := range enders {
+				if rn, ok := symbols[ender]; ok {
+					types[rn] = true
+				} else {
+					values[ender] = true
+				}
+			}
+			newlineType := symbols[newline]
+			canEnd := false
+			return func(token lexer.Token) (lexer.Token, error) {
+				if token.Type == newlineType {
+					if !canEnd {
+						return lexer.Token{}, DropToken
+					}
+					canEnd = false
+					return token, nil
+				}
+				canEnd = types[token.Type] || values[token.Value]
+				return token, nil
+			}
+		}})
+		return nil
+	}
+}
+
 // Apply a Mapping to all tokens coming out of a Lexer.
 type mappingLexerDef struct {
 	lexer.Definition
-	mapper Mapper
+	// Creates the Mapper for each lexer.
+	newMapper func() Mapper
 }
 
 func (m *mappingLexerDef) Lex(r io.Reader) (lexer.Lexer, error) {
@@ -95,12 +255,15 @@ func (m *mappingLexerDef) Lex(r io.Reader) (lexer.Lexer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &mappingLexer{lexer, m.mapper}, nil
+	return &mappingLexer{Lexer: lexer, mapper: m.newMapper()}, nil
 }
 
 type mappingLexer struct {
 	lexer.Lexer
 	mapper Mapper
+	// If non-nil, dropped tokens are recorded here keyed by the index of the token they precede.
+	elided  map[int][]lexer.Token
+	emitted int
 }
 
 func (m *mappingLexer) Next() (lexer.Token, error) {
@@ -109,10 +272,17 @@ func (m *mappingLexer) Next() (lexer.Token, error) {
 		if err != nil {
 			return t, err
 		}
+		original := t
 		t, err = m.mapper(t)
 		if err == DropToken {
+			if m.elided != nil {
+				m.elided[m.emitted] = append(m.elided[m.emitted], original)
+			}
 			continue
 		}
+		if err == nil {
+			m.emitted++
+		}
 		return t, err
 	}
 }
@@ -45,3 +45,130 @@ func TestUnquote(t *testing.T) {
 	}
 	require.Equal(t, expected, actual)
 }
+
+func TestElideTextScannerWhitespace(t *testing.T) {
+	type grammar struct {
+		Pos    lexer.Position
+		Idents []string `{ @Ident }`
+	}
+	def := lexer.TextScanner(lexer.WhitespaceTokens(true), lexer.TabWidth(4))
+	parser := mustTestParser(t, &grammar{}, Lexer(def), Elide("Whitespace"))
+	actual := &grammar{}
+	err := parser.ParseString("\t\ta b", actual)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, actual.Idents)
+	require.Equal(t, lexer.Position{Offset: 2, Line: 1, Column: 9}, actual.Pos)
+}
+
+func TestElideValues(t *testing.T) {
+	type grammar struct {
+		Values []string `{ @Ident | @"+" }`
+	}
+	def := lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<Ident>\w+)|(?P<Punct>[,+])`))
+	p := mustTestParser(t, &grammar{}, Lexer(def), Elide("Whitespace"), ElideValues("Punct", ","), UseLookahead())
+	actual := &grammar{}
+	err := p.ParseString(`a, b + c,,d`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Values: []string{"a", "b", "+", "c", "d"}}, actual)
+
+	_, err = Build(&grammar{}, Lexer(def), ElideValues("Missing", ","))
+	require.Error(t, err)
+}
+
+func TestElideOutside(t *testing.T) {
+	type template struct {
+		Parts []string `{ @Text | "{{" { @(Text | Whitespace) } "}}" }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<Delim>{{|}})|(?P<Text>[^\s{}]+)`))
+	p := mustTestParser(t, &template{}, Lexer(lex), ElideOutside("{{", "}}", "Whitespace"))
+
+	actual := &template{}
+	err := p.ParseString("a  b {{ c  d }} e", actual)
+	require.NoError(t, err)
+	require.Equal(t, &template{Parts: []string{"a", "b", " ", "c", "  ", "d", " ", "e"}}, actual)
+
+	_, err = Build(&template{}, Lexer(lex), ElideOutside("{{", "}}", "Space"))
+	require.Error(t, err)
+
+	// Unquoted strings are not delimiters.
+	type quotedTemplate struct {
+		Parts []string `{ @(Text | String) | "{{" { @(Text | Whitespace | String) } "}}" }`
+	}
+	lex = lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<String>"[^"]*")|(?P<Delim>{{|}})|(?P<Text>[^\s{}"]+)`))
+	p = mustTestParser(t, &quotedTemplate{}, Lexer(lex), Unquote(), ElideOutside("{{", "}}", "Whitespace"))
+	actualQuoted := &quotedTemplate{}
+	err = p.ParseString(`a "{{" b {{ c }}`, actualQuoted)
+	require.NoError(t, err)
+	require.Equal(t, &quotedTemplate{Parts: []string{"a", "{{", "b", " ", "c", " "}}, actualQuoted)
+}
+
+func TestStatementNewlines(t *testing.T) {
+	type goStatement struct {
+		Keyword string   `( @"return"`
+		Args    []string `  { @(Ident | Int | "+" | "(" | ")" | ",") }`
+		Call    []string `| @Ident { @(Ident | Int | "+" | "(" | ")" | ",") } ) Newline`
+	}
+	type goBlock struct {
+		Statements []*goStatement `{ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?P<Newline>\n)|(?P<Space>[ \t]+)|(?P<Comment>//[^\n]*)|(?P<Int>\d+)|(?P<Ident>[a-z]+)|(?P<Punct>[+(),])`))
+	p := mustTestParser(t, &goBlock{}, Lexer(lex), Elide("Space", "Comment"),
+		StatementNewlines("Newline", "Ident", "Int", ")"))
+
+	actual := &goBlock{}
+	err := p.ParseString(`
+print(a +
+	1, // Continued.
+	b)
+
+return a +
+	b
+return
+`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &goBlock{Statements: []*goStatement{
+		{Call: []string{"print", "(", "a", "+", "1", ",", "b", ")"}},
+		{Keyword: "return", Args: []string{"a", "+", "b"}},
+		{Keyword: "return"},
+	}}, actual)
+
+	_, err = Build(&goBlock{}, Lexer(lex), StatementNewlines("Semicolon", "Ident"))
+	require.Error(t, err)
+}
+
+func TestUnquoteDoubled(t *testing.T) {
+	for _, test := range []struct {
+		quoted   string
+		expected string
+		err      string
+	}{
+		{quoted: `""`, expected: ``},
+		{quoted: `"Smith, J."`, expected: `Smith, J.`},
+		{quoted: `"She said ""hi"""`, expected: `She said "hi"`},
+		{quoted: "\"multi\nline\"", expected: "multi\nline"},
+		{quoted: `'it''s'`, expected: `it's`},
+		{quoted: `"b"c"`, err: "unescaped quote"},
+		{quoted: `"""`, err: "unescaped quote"},
+		{quoted: `"open`, err: "missing closing quote"},
+		{quoted: `"`, err: "missing closing quote"},
+	} {
+		actual, err := unquoteDoubled(test.quoted)
+		if test.err != "" {
+			require.EqualError(t, err, test.err, test.quoted)
+			continue
+		}
+		require.NoError(t, err, test.quoted)
+		require.Equal(t, test.expected, actual, test.quoted)
+	}
+
+	var grammar struct {
+		Values []string `{ @Quoted }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?P<Quoted>"[^"]*(?:""[^"]*)*"?)`))
+	parser := mustTestParser(t, &grammar, Lexer(lex), UnquoteDoubled("Quoted"))
+	actual, err := parser.Lex(strings.NewReader(`"a""b"`))
+	require.NoError(t, err)
+	require.Equal(t, `a"b`, actual[0].Value)
+	_, err = parser.Lex(strings.NewReader(`"a`))
+	require.EqualError(t, err, `<source>:1:1: invalid quoted string "\"a": missing closing quote`)
+}
@@ -0,0 +1,91 @@
+package participle
+
+import (
+	"reflect"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// WithMemoization caches the result of parsing each grammar struct at each position in the input
+// for the duration of a single parse, as a packrat parser does.
+//
+// When the parser backtracks, eg. with NoLookahead() or LongestMatch(), a struct attempted again
+// at the same position reuses the earlier result rather than being parsed again, trading memory
+// for time. Results are not reused where parsing a struct has side effects that would be lost,
+// ie. when building a CST, reporting events or warnings, tracing, calling hooks, or assigning doc
+// comments or leading trivia, although speculative parses within such a parse may still benefit.
+func WithMemoization() ParseOption {
+	return func(p *parseContext) {
+		p.memo = memoTable{}
+	}
+}
+
+type memoKey struct {
+	strct  *strct
+	cursor int
+}
+
+// The result of parsing a struct at a position.
+type memoEntry struct {
+	out []reflect.Value
+	err error
+	// Cursor following the parse.
+	end int
+	// True if a cut within the struct committed to the enclosing branch.
+	committed bool
+}
+
+type memoTable map[memoKey]*memoEntry
+
+// Returns true if the results of parsing structs may be cached and reused.
+func (p parseContext) memoizable() bool {
+	return p.memo != nil && p.cst == nil && p.events == nil && p.annotationHook == nil &&
+		p.structHook == nil && p.warnings == nil && p.trace == nil && p.docTypes == nil && p.triviaClaimed == nil &&
+		len(p.exclusive) == 0
+}
+
+// Parse s at the cursor, reusing any earlier result.
+func (m memoTable) parse(ctx parseContext, s *strct, parent reflect.Value) ([]reflect.Value, error) {
+	key := memoKey{strct: s, cursor: ctx.Cursor()}
+	entry, ok := m[key]
+	if !ok {
+		committed := false
+		inner := ctx
+		inner.committed = &committed
+		out, err := s.parse(inner, parent)
+		entry = &memoEntry{out: out, err: copyError(err), end: ctx.Cursor(), committed: committed}
+		m[key] = entry
+	}
+	if err := ctx.Restore(entry.end); err != nil {
+		return nil, err
+	}
+	if entry.committed && ctx.committed != nil {
+		*ctx.committed = true
+	}
+	var out []reflect.Value
+	if entry.out != nil {
+		out = make([]reflect.Value, 0, len(entry.out))
+		for _, v := range entry.out {
+			// Copy the struct, so that a result reused after backtracking is not shared.
+			copied := reflect.New(v.Type()).Elem()
+			copied.Set(v)
+			out = append(out, copied)
+		}
+	}
+	return out, copyError(entry.err)
+}
+
+// Copy positioned errors, which are modified as they propagate, so that a cached error is unchanged.
+func copyError(err error) error {
+	switch err := err.(type) {
+	case *ParseError:
+		copied := *err
+		copied.Stack = append([]string(nil), err.Stack...)
+		return &copied
+	case *lexer.Error:
+		copied := *err
+		return &copied
+	default:
+		return err
+	}
+}
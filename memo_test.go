@@ -0,0 +1,29 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoization(t *testing.T) {
+	p := mustTestParser(t, &memoTerm{}, NoLookahead())
+	expected := &memoTerm{}
+	err := p.ParseString(memoInput(3), expected)
+	require.NoError(t, err)
+	require.Equal(t, &memoTerm{Minus: &memoTerm{Minus: &memoTerm{Minus: &memoTerm{Name: "a"}}}}, expected)
+
+	actual := &memoTerm{}
+	err = p.ParseString(memoInput(3), actual, WithMemoization())
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// Too deep to parse in reasonable time without memoization.
+	err = p.ParseString(memoInput(200), &memoTerm{}, WithMemoization())
+	require.NoError(t, err)
+
+	err = p.ParseString(`((a)-)*`, &memoTerm{}, WithMemoization())
+	require.EqualError(t, err, `<source>:1:7: unexpected "*" (expected "+")`)
+	err = p.ParseString(`((a)-)*`, &memoTerm{})
+	require.EqualError(t, err, `<source>:1:7: unexpected "*" (expected "+")`)
+}
@@ -3,27 +3,344 @@ package participle
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/alecthomas/participle/lexer"
 )
 
 var (
 	positionType  = reflect.TypeOf(lexer.Position{})
+	tokensType    = reflect.TypeOf([]lexer.Token{})
 	captureType   = reflect.TypeOf((*Capture)(nil)).Elem()
 	parseableType = reflect.TypeOf((*Parseable)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType    = reflect.TypeOf(big.Int{})
+	bigFloatType  = reflect.TypeOf(big.Float{})
 
 	// NextMatch should be returned by Parseable.Parse() method implementations to indicate
 	// that the node did not match and that other matches should be attempted, if appropriate.
 	NextMatch = errors.New("no match") // nolint: golint
+
+	// ErrTooManyErrors follows the errors in RecoveredErrors, and is matched by it with
+	// errors.Is(), if parsing stopped after the number of errors set by MaxErrors().
+	ErrTooManyErrors = errors.New("too many errors")
+
+	// ErrIncomplete is matched, with errors.Is(), by errors caused by the input ending where
+	// the grammar expected more tokens. This allows eg. a REPL to prompt for more input rather
+	// than report a syntax error.
+	ErrIncomplete = errors.New("incomplete input")
 )
 
 // Context for a single parse.
 type parseContext struct {
-	lexer.PeekingLexer
+	*lexer.BufferedLexer
 	caseInsensitive map[rune]bool
+	// Tokens dropped from the stream, keyed by the index of the token they precede.
+	elided map[int][]lexer.Token
+	// The CST node being populated, if any.
+	cst *CSTNode
+	// Branches of exclusive groups matched within the innermost enclosing repetition.
+	exclusive map[*exclusive]int
+	// Keyword sets matched by $<name>, provided by WithKeywords().
+	keywords map[string]map[string]bool
+	// Maximum number of tokens that may be consumed, if non-zero.
+	maxTokens int
+	// If true, disjunctions select the branch consuming the most tokens.
+	longestMatch bool
+	// If true, failed branches, optionals and repetitions are backtracked over, provided by NoLookahead().
+	backtrack bool
+	// Elided token types bound to the Doc field of the following struct, provided by DocComments().
+	docTypes map[rune]bool
+	// Cursors whose preceding doc comments have been assigned to a struct.
+	docClaimed map[int]bool
+	// Cursors whose preceding comment, on the same line as the token before them, has been
+	// assigned to the TrailingComment of a struct.
+	trailingClaimed map[int]bool
+	// Cursors whose preceding elided tokens have been assigned to the LeadingTrivia of a
+	// struct, if the grammar has LeadingTrivia fields.
+	triviaClaimed map[int]bool
+	// The input, if the grammar captures source text with @=.
+	source []byte
+	// Unescape functions for captured tokens, provided by WithUnescaper().
+	unescapers map[rune]func(string) (string, error)
+	// Width of tab characters in token positions, provided by the lexer.TabWidth() option.
+	tabWidth int
+	// Token types of unquoted strings, which never match values given to options (see Unquote()).
+	quoted map[rune]bool
+	// Called for each annotated struct or field matched, provided by WithAnnotationHook().
+	annotationHook func(Annotation)
+	// Called for each struct matched, provided by OnStruct().
+	structHook func(v interface{}, pos lexer.Position) error
+	// Peek depths of lookahead selections, provided by WithLookaheadStats().
+	lookaheadStats *LookaheadStats
+	// Errors recovered from in the root repetition, provided by Recover().
+	recovery *recovery
+	// Position of the start of the input within a larger file, provided by WithBasePosition().
+	base *lexer.Position
+	// Set by a cut within the innermost branch being backtracked over.
+	committed *bool
+	// Receives the structure of the parse, provided by ParseEvents().
+	events *eventRecorder
+	// If true, the grammar captures into channels.
+	channels bool
+	// Sends to channels made within the innermost branch being backtracked over, which are only
+	// made once the branch is committed to, or nil if sends are made as values are captured.
+	sends *[]func() error
+	// The repetition most recently stopped short of the remainder of its sequence, which the
+	// sequence reports if it fails at the same point.
+	stopped *stoppedRepetition
+	// If true, errors record the productions they pass through, provided by ProductionStack().
+	productionStack bool
+	// If true, scalar fields may only be captured once per struct, provided by StrictCaptures().
+	strictCaptures bool
+	// If true, values captured into a string field after the first are joined with a separator or
+	// rejected, provided by JoinStrings() or StrictStrings().
+	joinStrings bool
+	// Fields of the innermost struct captured so far, if strictCaptures or joinStrings is set.
+	captured map[string]bool
+	// Collects matches of deprecated productions, provided by WithWarnings().
+	warnings *[]Warning
+	// Indexes of the elements of unsorted sets by key, keyed by the address of the slice field.
+	sets map[uintptr]map[interface{}]int
+	// Results of parsing structs at each position, provided by WithMemoization().
+	memo memoTable
+	// Target of the parse, which is given to the root struct if merging, or whose channels are
+	// given to it if the grammar captures into channels.
+	target reflect.Value
+	// If true, the root struct is parsed into the existing value of the target, provided by Merge().
+	merge bool
+	// Fields of the root struct captured so far, if merging.
+	merged map[string]bool
+	// Writes a trace of the parse, provided by Trace().
+	trace *tracer
+	// Flags enabling alternatives marked with ?<flag>, provided by WithFlags().
+	flags map[string]bool
+	// The root repetition, if it matches a single statement for a StatementScanner.
+	statement *repetition
+	// The version of the input, provided by WithVersion().
+	version *int
+}
+
+// Returns a copy of the context for a branch that may be backtracked over, and the flag set if a
+// cut within it commits to the branch.
+func (p parseContext) branch() (parseContext, *bool) {
+	committed := false
+	p.committed = &committed
+	if p.channels {
+		p.sends = &[]func() error{}
+	}
+	return p, &committed
+}
+
+// Make the channel sends of a branch that matched, or defer them to the enclosing branch if it
+// may also be backtracked over.
+func (p parseContext) commitSends(branch parseContext) error {
+	if branch.sends == nil || branch.sends == p.sends {
+		return nil
+	}
+	if p.sends != nil {
+		*p.sends = append(*p.sends, *branch.sends...)
+		return nil
+	}
+	for _, send := range *branch.sends {
+		if err := send(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call the annotation hook, if any, for an annotated struct or field matched at pos.
+func (p parseContext) annotate(annotation *Annotation, pos lexer.Position) {
+	if annotation == nil || p.annotationHook == nil {
+		return
+	}
+	event := *annotation
+	event.Pos = pos
+	p.annotationHook(event)
+}
+
+// Record a warning, if warnings are collected, for a deprecated struct or field matched at pos.
+func (p parseContext) warn(deprecated string, pos lexer.Position) {
+	if deprecated == "" || p.warnings == nil {
+		return
+	}
+	*p.warnings = append(*p.warnings, Warning{Pos: pos, Message: deprecated})
+}
+
+// Unescape the value of a captured token, if an unescape function is registered for its type.
+func (p parseContext) unescape(token lexer.Token) (string, error) {
+	fn, ok := p.unescapers[token.Type]
+	if !ok {
+		return token.Value, nil
+	}
+	value, err := fn(token.Value)
+	if err == nil {
+		return value, nil
+	}
+	pos := token.Pos
+	if u, ok := err.(*UnescapeError); ok && u.Offset >= 0 && u.Offset <= len(token.Value) {
+		pos = pos.Advance(token.Value[:u.Offset], p.tabWidth)
+	}
+	return "", lexer.Errorf(pos, "invalid escape in %q: %s", token.Value, err)
+}
+
+// The position following the last consumed token, given the position of the next token, excluding
+// any elided tokens, such as comments, between them.
+func (p parseContext) consumedEnd(next lexer.Position) lexer.Position {
+	if elided := p.elided[p.Cursor()]; len(elided) > 0 {
+		return elided[0].Pos
+	}
+	return next
+}
+
+// The source text from start up to end, excluding trailing whitespace.
+func (p parseContext) sourceText(start, end lexer.Position) string {
+	if p.base != nil {
+		start.Offset -= p.base.Offset
+		end.Offset -= p.base.Offset
+	}
+	if start.Offset < 0 || start.Offset > end.Offset || end.Offset > len(p.source) {
+		return ""
+	}
+	return strings.TrimRightFunc(string(p.source[start.Offset:end.Offset]), unicode.IsSpace)
+}
+
+// Record the state of the parse, returning a function that restores it if a branch fails, and a
+// function that must be called once it will no longer be restored.
+func (p parseContext) checkpoint(parent reflect.Value) (restore, release func()) {
+	cursor, release := p.Hold()
+	var original reflect.Value
+	if parent.IsValid() && parent.CanSet() {
+		original = reflect.New(parent.Type()).Elem()
+		original.Set(parent)
+	}
+	children := 0
+	if p.cst != nil {
+		children = len(p.cst.Children)
+	}
+	exclusive := copyCounts(p.exclusive)
+	restoreEvents := func() {}
+	if p.events != nil {
+		restoreEvents = p.events.checkpoint()
+	}
+	captured := copyCaptured(p.captured)
+	merged := copyCaptured(p.merged)
+	warnings := 0
+	if p.warnings != nil {
+		warnings = len(*p.warnings)
+	}
+	claimed := copyClaimed(p.docClaimed)
+	triviaClaimed := copyClaimed(p.triviaClaimed)
+	trailingClaimed := copyClaimed(p.trailingClaimed)
+	return func() {
+		// The cursor is held, so can always be restored.
+		_ = p.Restore(cursor)
+		if original.IsValid() {
+			parent.Set(original)
+		}
+		if p.cst != nil {
+			p.cst.Children = p.cst.Children[:children]
+		}
+		restoreEvents()
+		if p.warnings != nil {
+			*p.warnings = (*p.warnings)[:warnings]
+		}
+		for k := range p.captured {
+			if !captured[k] {
+				delete(p.captured, k)
+			}
+		}
+		for k := range p.merged {
+			if !merged[k] {
+				delete(p.merged, k)
+			}
+		}
+		for k := range p.exclusive {
+			delete(p.exclusive, k)
+		}
+		for k, v := range exclusive {
+			p.exclusive[k] = v
+		}
+		for k := range p.docClaimed {
+			if !claimed[k] {
+				delete(p.docClaimed, k)
+			}
+		}
+		for k := range p.triviaClaimed {
+			if !triviaClaimed[k] {
+				delete(p.triviaClaimed, k)
+			}
+		}
+		for k := range p.trailingClaimed {
+			if !trailingClaimed[k] {
+				delete(p.trailingClaimed, k)
+			}
+		}
+	}, release
+}
+
+func copyClaimed(claimed map[int]bool) map[int]bool {
+	if claimed == nil {
+		return nil
+	}
+	out := map[int]bool{}
+	for k, v := range claimed {
+		out[k] = v
+	}
+	return out
+}
+
+func copyCaptured(captured map[string]bool) map[string]bool {
+	if captured == nil {
+		return nil
+	}
+	out := make(map[string]bool, len(captured))
+	for k, v := range captured {
+		out[k] = v
+	}
+	return out
+}
+
+func copyCounts(counts map[*exclusive]int) map[*exclusive]int {
+	out := map[*exclusive]int{}
+	for k, v := range counts {
+		out[k] = v
+	}
+	return out
+}
+
+// Peek at the n'th token ahead, failing if the input exceeds the token limit.
+func (p parseContext) Peek(n int) (lexer.Token, error) {
+	token, err := p.BufferedLexer.Peek(n)
+	if err == nil && p.maxTokens > 0 && p.Cursor()+n >= p.maxTokens && !token.EOF() {
+		return token, &MaxTokensError{Limit: p.maxTokens, Pos: token.Pos}
+	}
+	return token, err
+}
+
+// Next consumes the next token, recording it in the CST if one is being built.
+func (p parseContext) Next() (lexer.Token, error) {
+	cursor := p.Cursor()
+	token, err := p.BufferedLexer.Next()
+	if err == nil && p.events != nil && !token.EOF() {
+		p.events.token(token)
+	}
+	if err == nil && p.trace != nil && !token.EOF() {
+		p.trace.token(token)
+	}
+	if err != nil || p.cst == nil || token.EOF() {
+		return token, err
+	}
+	p.cst.addElided(p.elided[cursor])
+	p.cst.Children = append(p.cst.Children, &CSTNode{Token: token})
+	return token, nil
 }
 
 // A node in the grammar.
@@ -44,6 +361,8 @@ func decorate(err *error, name func() string) {
 	switch realError := (*err).(type) {
 	case *lexer.Error:
 		*err = &lexer.Error{Message: name() + ": " + realError.Message, Pos: realError.Pos}
+	case *ParseError:
+		realError.Message = name() + ": " + realError.Message
 	default:
 		*err = fmt.Errorf("%s: %s", name(), realError)
 	}
@@ -72,6 +391,10 @@ func (p *parseable) Parse(ctx parseContext, parent reflect.Value) (out []reflect
 type strct struct {
 	typ  reflect.Type
 	expr node
+	// Metadata attached to the struct with Annotate(), if any.
+	annotation *Annotation
+	// Warning message for the struct from Deprecate(), if any.
+	deprecated string
 }
 
 func (s *strct) String() string { return stringer(s) }
@@ -82,48 +405,741 @@ func (s *strct) maybeInjectPos(pos lexer.Position, v reflect.Value) {
 	}
 }
 
+// Set Index, if present and not part of the grammar, of a struct appended to a slice at index i.
+func maybeInjectIndex(v reflect.Value, i int) {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct || !hasIndexField(v.Type()) {
+		return
+	}
+	if f := v.FieldByName("Index"); f.CanSet() {
+		f.SetInt(int64(i))
+	}
+}
+
+// Returns true if t, or the struct it points to, has an Index field set by maybeInjectIndex().
+func hasIndexField(t reflect.Type) bool {
+	t = indirectType(t)
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	field, ok := t.FieldByName("Index")
+	return ok && field.Type.Kind() == reflect.Int && fieldLexerTag(field) == ""
+}
+
+// Set Doc, if present, to the doc comments preceding the struct, returning true if they were assigned.
+func (s *strct) maybeInjectDoc(ctx parseContext, v reflect.Value) bool {
+	if ctx.docTypes == nil {
+		return false
+	}
+	f := v.FieldByName("Doc")
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return false
+	}
+	cursor := ctx.Cursor()
+	if ctx.docClaimed[cursor] {
+		return false
+	}
+	elided := ctx.elided[cursor]
+	if ctx.trailingClaimed[cursor] {
+		// The trailing comment of the preceding struct does not document this one.
+		elided = elided[trailingComment(ctx, cursor)+1:]
+	}
+	lines := []string{}
+	for _, token := range elided {
+		if ctx.docTypes[token.Type] {
+			lines = append(lines, token.Value)
+		}
+	}
+	if len(lines) == 0 {
+		return false
+	}
+	f.SetString(strings.Join(lines, "\n"))
+	ctx.docClaimed[cursor] = true
+	return true
+}
+
+// Set TrailingComment, if present, to the doc comment following the last token of the struct on
+// the same line, returning true if it was assigned.
+func (s *strct) maybeInjectTrailingComment(ctx parseContext, start int, v reflect.Value) bool {
+	if ctx.docTypes == nil {
+		return false
+	}
+	f := v.FieldByName("TrailingComment")
+	cursor := ctx.Cursor()
+	if !f.IsValid() || f.Kind() != reflect.String || cursor == start || ctx.trailingClaimed[cursor] {
+		return false
+	}
+	i := trailingComment(ctx, cursor)
+	if i < 0 {
+		return false
+	}
+	f.SetString(ctx.elided[cursor][i].Value)
+	ctx.trailingClaimed[cursor] = true
+	return true
+}
+
+// Returns the index of the first doc comment among the elided tokens preceding cursor that is on
+// the same line as the end of the token before it, or -1 if there is none.
+func trailingComment(ctx parseContext, cursor int) int {
+	last := ctx.Range(cursor-1, cursor)[0]
+	line := last.Pos.Line + strings.Count(last.Value, "\n")
+	for i, token := range ctx.elided[cursor] {
+		if token.Pos.Line != line {
+			break
+		}
+		if ctx.docTypes[token.Type] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Set LeadingTrivia, if present, to the elided tokens preceding the struct, returning true if they
+// were assigned.
+func (s *strct) maybeInjectTrivia(ctx parseContext, v reflect.Value) bool {
+	if ctx.triviaClaimed == nil {
+		return false
+	}
+	f := v.FieldByName("LeadingTrivia")
+	cursor := ctx.Cursor()
+	if !f.IsValid() || f.Type() != tokensType || ctx.triviaClaimed[cursor] || len(ctx.elided[cursor]) == 0 {
+		return false
+	}
+	f.Set(reflect.ValueOf(append([]lexer.Token(nil), ctx.elided[cursor]...)))
+	ctx.triviaClaimed[cursor] = true
+	return true
+}
+
+// Returns true if any struct in the grammar has a LeadingTrivia field.
+func hasLeadingTrivia(root node) bool {
+	found := false
+	_ = visit(root, func(n node, next func() error) error {
+		if s, ok := n.(*strct); ok {
+			if f, ok := s.typ.FieldByName("LeadingTrivia"); ok && f.Type == tokensType {
+				found = true
+			}
+		}
+		return next()
+	})
+	return found
+}
+
+// Set EndPos, if present, to the position of the token following the struct.
+func (s *strct) maybeInjectEndPos(pos lexer.Position, v reflect.Value) {
+	if f := v.FieldByName("EndPos"); f.IsValid() && f.Type() == positionType {
+		f.Set(reflect.ValueOf(pos))
+	}
+}
+
 func (s *strct) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
-	sv := reflect.New(s.typ).Elem()
+	if ctx.trace != nil {
+		name := ruleName(s.typ)
+		ctx.trace.enter(name)
+		defer func() { ctx.trace.exit(name, out, err) }()
+	}
+	if ctx.memoizable() {
+		return ctx.memo.parse(ctx, s, parent)
+	}
+	return s.parse(ctx, parent)
+}
+
+func (s *strct) parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	var sv reflect.Value
+	if ctx.events != nil {
+		sv = ctx.events.value(s.typ)
+	} else {
+		sv = reflect.New(s.typ).Elem()
+	}
+	ctx.merged = nil
+	if target := ctx.target; target.IsValid() {
+		if target.Type() == s.typ && ctx.merge {
+			sv.Set(target)
+			ctx.merged = map[string]bool{}
+		} else if target.Type() == s.typ {
+			copyChannels(target, sv)
+		}
+		ctx.target = reflect.Value{}
+	}
 	t, err := ctx.Peek(0)
 	if err != nil {
 		return nil, err
 	}
+	if parent := ctx.cst; parent != nil {
+		ctx.cst = &CSTNode{Rule: ruleName(s.typ)}
+		defer func() {
+			if out != nil || err != nil {
+				parent.Children = append(parent.Children, ctx.cst)
+			}
+		}()
+	}
+	if ctx.strictCaptures || ctx.joinStrings {
+		ctx.captured = map[string]bool{}
+	}
+	if ctx.events != nil {
+		depth := ctx.events.enter(ruleName(s.typ), t.Pos)
+		defer func() {
+			end, _ := ctx.BufferedLexer.Peek(0)
+			ctx.events.exit(depth, out != nil && err == nil, end.Pos)
+		}()
+	}
 	s.maybeInjectPos(t.Pos, sv)
+	start := ctx.Cursor()
+	if s.maybeInjectDoc(ctx, sv) {
+		cursor := ctx.Cursor()
+		defer func() {
+			if out == nil {
+				delete(ctx.docClaimed, cursor)
+			}
+		}()
+	}
+	if s.maybeInjectTrivia(ctx, sv) {
+		cursor := ctx.Cursor()
+		defer func() {
+			if out == nil {
+				delete(ctx.triviaClaimed, cursor)
+			}
+		}()
+	}
 	if out, err = s.expr.Parse(ctx, sv); err != nil {
-		return []reflect.Value{sv}, err
+		return []reflect.Value{sv}, s.pushProduction(ctx, err)
 	} else if out == nil {
 		return nil, nil
 	}
+	end, err := ctx.BufferedLexer.Peek(0)
+	if err != nil {
+		return []reflect.Value{sv}, err
+	}
+	s.maybeInjectEndPos(end.Pos, sv)
+	s.maybeInjectTrailingComment(ctx, start, sv)
+	ctx.annotate(s.annotation, t.Pos)
+	ctx.warn(s.deprecated, t.Pos)
+	if ctx.structHook != nil {
+		if err := ctx.structHook(sv.Addr().Interface(), t.Pos); err != nil {
+			if !isPositioned(err) {
+				err = &HookError{Pos: t.Pos, Err: err}
+			}
+			return []reflect.Value{sv}, s.pushProduction(ctx, err)
+		}
+	}
 	return []reflect.Value{sv}, nil
 }
 
+// Record this production in the stack of a positioned error passing through it, if enabled.
+func (s *strct) pushProduction(ctx parseContext, err error) error {
+	if !ctx.productionStack {
+		return err
+	}
+	switch realError := err.(type) {
+	case *ParseError:
+		realError.Stack = append([]string{ruleName(s.typ)}, realError.Stack...)
+		return realError
+	case *lexer.Error:
+		return &ParseError{Message: realError.Message, Pos: realError.Pos, Stack: []string{ruleName(s.typ)}, err: realError}
+	default:
+		return err
+	}
+}
+
+// <expr> { <operator> <expr> } - binary expressions parsed by precedence climbing, registered with Precedence()
+type precedence struct {
+	expr      *strct
+	operators map[string]Operator
+	// The equivalent grammar, used for lookahead.
+	grammar node
+}
+
+func newPrecedence(t reflect.Type, operators []Operator) *precedence {
+	expr := &strct{typ: t}
+	ops := &disjunction{}
+	out := &precedence{expr: expr, operators: map[string]Operator{}}
+	for _, op := range operators {
+		out.operators[op.Op] = op
+		ops.nodes = append(ops.nodes, &literal{s: op.Op, t: lexer.EOF})
+	}
+	out.grammar = &sequence{head: true, node: expr, next: &sequence{node: &repetition{
+		node: &sequence{head: true, node: ops, next: &sequence{node: expr}},
+	}}}
+	return out
+}
+
+func (p *precedence) String() string { return stringer(p) }
+
+func (p *precedence) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	return p.climb(ctx, parent, math.MinInt)
+}
+
+// Parse an expression whose operators have at least the precedence min.
+func (p *precedence) climb(ctx parseContext, parent reflect.Value, min int) (out []reflect.Value, err error) {
+	out, err = p.expr.Parse(ctx, parent)
+	if err != nil || out == nil {
+		return out, err
+	}
+	left := out[0]
+	for {
+		token, err := ctx.Peek(0)
+		if err != nil {
+			return []reflect.Value{left}, err
+		}
+		op, ok := p.operators[token.Value]
+		if !ok || token.EOF() || ctx.quoted[token.Type] || op.Precedence < min {
+			return []reflect.Value{left}, nil
+		}
+		_, _ = ctx.Next()
+		next := op.Precedence + 1
+		if op.Right {
+			next = op.Precedence
+		}
+		right, err := p.climb(ctx, parent, next)
+		if err != nil {
+			return []reflect.Value{left}, err
+		}
+		if right == nil {
+			return []reflect.Value{left}, p.expr.pushProduction(ctx, lexer.Errorf(token.Pos, "expected expression after %q", token.Value))
+		}
+		left = p.binary(left, op.Op, right[0])
+	}
+}
+
+// Construct a binary expression spanning left and right.
+func (p *precedence) binary(left reflect.Value, op string, right reflect.Value) reflect.Value {
+	out := reflect.New(p.expr.typ).Elem()
+	if pos := left.FieldByName("Pos"); pos.IsValid() && pos.Type() == positionType {
+		p.expr.maybeInjectPos(pos.Interface().(lexer.Position), out)
+	}
+	if end := right.FieldByName("EndPos"); end.IsValid() && end.Type() == positionType {
+		p.expr.maybeInjectEndPos(end.Interface().(lexer.Position), out)
+	}
+	for name, v := range map[string]reflect.Value{"Left": left, "Right": right} {
+		ptr := reflect.New(p.expr.typ)
+		ptr.Elem().Set(v)
+		out.FieldByName(name).Set(ptr)
+	}
+	out.FieldByName("Op").SetString(op)
+	return out
+}
+
 // <expr> {"|" <expr>}
 type disjunction struct {
-	nodes     []node
+	nodes []node
+	// Flag required by each branch with ?<flag>, or "" if none, or nil if no branch requires one.
+	flags     []string
 	lookahead lookaheadTable
+	dispatch  lookaheadDispatch
 }
 
 func (d *disjunction) String() string { return stringer(d) }
 
+// Returns true if the branch requires a flag that is not enabled for the parse.
+func (d *disjunction) disabled(ctx parseContext, branch int) bool {
+	return d.flags != nil && d.flags[branch] != "" && !ctx.flags[d.flags[branch]]
+}
+
+// Returns the branches that are disabled for the parse, or nil if there are none.
+func (d *disjunction) excluded(ctx parseContext) []bool {
+	var exclude []bool
+	for i := range d.flags {
+		if d.disabled(ctx, i) {
+			if exclude == nil {
+				exclude = make([]bool, len(d.nodes))
+			}
+			exclude[i] = true
+		}
+	}
+	return exclude
+}
+
+// Select a branch, preferring the dispatch table if available.
+func (d *disjunction) selectBranch(ctx parseContext, parent reflect.Value) (int, error) {
+	if exclude := d.excluded(ctx); exclude != nil {
+		return d.lookahead.selectFrom(ctx, exclude)
+	}
+	if d.dispatch != nil {
+		return d.dispatch.Select(ctx)
+	}
+	return d.lookahead.Select(ctx, parent)
+}
+
 func (d *disjunction) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
-	if selected, err := d.lookahead.Select(ctx, parent); err != nil {
-		return nil, err
+	_, out, err = d.parseBranch(ctx, parent)
+	return out, err
+}
+
+// Parse the disjunction, returning the index of the branch that matched.
+func (d *disjunction) parseBranch(ctx parseContext, parent reflect.Value) (branch int, out []reflect.Value, err error) {
+	if ctx.longestMatch && len(d.nodes) > 1 {
+		return d.parseLongest(ctx, parent)
+	}
+	if ctx.backtrack && len(d.nodes) > 1 {
+		return d.parseOrdered(ctx, parent)
+	}
+	if selected, err := d.selectBranch(ctx, parent); err != nil {
+		return -1, nil, err
 	} else if selected != -2 {
 		if selected == -1 {
-			return nil, nil
+			return -1, nil, nil
 		}
-		return d.nodes[selected].Parse(ctx, parent)
+		out, err = d.nodes[selected].Parse(ctx, parent)
+		return selected, out, err
 	}
 
 	// Same logic without lookahead.
-	for _, a := range d.nodes {
+	for i, a := range d.nodes {
+		if d.disabled(ctx, i) {
+			continue
+		}
 		if value, err := a.Parse(ctx, parent); err != nil {
-			return value, err
+			return i, value, err
 		} else if value != nil {
-			return value, nil
+			return i, value, nil
 		}
 	}
-	return nil, nil
+	return -1, nil, nil
+}
+
+// Parse each branch in order, backtracking over any that fail, until one matches.
+//
+// If no branch matches, the error of the branch that failed furthest into the input is returned.
+func (d *disjunction) parseOrdered(ctx parseContext, parent reflect.Value) (branch int, out []reflect.Value, err error) {
+	restore, release := ctx.checkpoint(parent)
+	defer release()
+	failed, failedEnd := -1, -1
+	var failure error
+	for i, a := range d.nodes {
+		if d.disabled(ctx, i) {
+			continue
+		}
+		branch, committed := ctx.branch()
+		value, err := a.Parse(branch, parent)
+		if err == nil && value != nil {
+			return i, value, ctx.commitSends(branch)
+		}
+		if err != nil && *committed {
+			return i, value, err
+		}
+		if end := ctx.Cursor(); err != nil && end > failedEnd {
+			failed, failedEnd, failure = i, end, err
+		}
+		restore()
+	}
+	return failed, nil, failure
+}
+
+// Speculatively parse every branch, then parse the one that consumed the most tokens.
+//
+// Ties are resolved in favour of the earliest branch. If no branch matches, the branch whose error
+// occurred furthest into the input is used.
+func (d *disjunction) parseLongest(ctx parseContext, parent reflect.Value) (branch int, out []reflect.Value, err error) {
+	start, release := ctx.Hold()
+	defer release()
+	var original reflect.Value
+	if parent.IsValid() && parent.CanSet() {
+		original = reflect.New(parent.Type()).Elem()
+		original.Set(parent)
+	}
+	restore := func() {
+		// The cursor is held, so can always be restored.
+		_ = ctx.Restore(start)
+		if original.IsValid() {
+			parent.Set(original)
+		}
+	}
+	matched, matchedEnd := -1, -1
+	failed, failedEnd := -1, -1
+	for i, a := range d.nodes {
+		if d.disabled(ctx, i) {
+			continue
+		}
+		speculative := ctx
+		speculative.cst = nil
+		speculative.annotationHook = nil
+		speculative.structHook = nil
+		speculative.events = nil
+		speculative.warnings = nil
+		speculative.captured = copyCaptured(ctx.captured)
+		speculative.merged = copyCaptured(ctx.merged)
+		speculative.exclusive = map[*exclusive]int{}
+		for k, v := range ctx.exclusive {
+			speculative.exclusive[k] = v
+		}
+		speculative.docClaimed = copyClaimed(ctx.docClaimed)
+		speculative.trailingClaimed = copyClaimed(ctx.trailingClaimed)
+		speculative.triviaClaimed = copyClaimed(ctx.triviaClaimed)
+		speculative, committed := speculative.branch()
+		value, err := a.Parse(speculative, parent)
+		end := ctx.Cursor()
+		restore()
+		if err != nil && *committed {
+			// Report the error from the branch committed to by a cut.
+			matched = i
+			break
+		}
+		switch {
+		case err != nil && end > failedEnd:
+			failed, failedEnd = i, end
+		case err == nil && value != nil && end > matchedEnd:
+			matched, matchedEnd = i, end
+		}
+	}
+	if matched == -1 {
+		matched = failed
+	}
+	if matched == -1 {
+		return -1, nil, nil
+	}
+	out, err = d.nodes[matched].Parse(ctx, parent)
+	return matched, out, err
+}
+
+// A union of the types implementing an interface, registered with Union().
+//
+// Each member is a branch of the disjunction, and the matched value is converted to the member type.
+type union struct {
+	disjunction
+	typ     reflect.Type
+	members []reflect.Type
+	// Index of the member selected by each discriminator, if registered with TaggedUnion().
+	tags map[string]int
+}
+
+func (u *union) String() string { return stringer(u) }
+
+// Add the node for a member, selected by tag if it is not empty.
+func (u *union) addMember(n node, tag string) {
+	u.nodes = append(u.nodes, n)
+	if tag == "" {
+		return
+	}
+	if u.tags == nil {
+		u.tags = map[string]int{}
+	}
+	u.tags[tag] = len(u.nodes) - 1
+}
+
+func (u *union) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	var branch int
+	if u.tags != nil {
+		branch, out, err = u.parseTagged(ctx, parent)
+	} else {
+		branch, out, err = u.parseBranch(ctx, parent)
+	}
+	if branch == -1 || len(out) == 0 {
+		return out, err
+	}
+	// The member follows any discriminator.
+	v := out[len(out)-1]
+	if t := u.members[branch]; t.Kind() == reflect.Ptr && v.Kind() != reflect.Ptr {
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	return []reflect.Value{v}, err
+}
+
+// Parse the member selected by the value of the discriminator token.
+func (u *union) parseTagged(ctx parseContext, parent reflect.Value) (branch int, out []reflect.Value, err error) {
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return -1, nil, err
+	}
+	branch, ok := u.tags[token.Value]
+	if !ok || token.EOF() || ctx.quoted[token.Type] {
+		return -1, nil, nil
+	}
+	out, err = u.nodes[branch].Parse(ctx, parent)
+	return branch, out, err
+}
+
+// !
+//
+// A cut, which matches without consuming input and commits the parser to the enclosing branch, so
+// that a later failure within it is reported rather than backtracked over.
+type cut struct{}
+
+func (c *cut) String() string { return stringer(c) }
+
+func (c *cut) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	if ctx.committed != nil {
+		*ctx.committed = true
+	}
+	return []reflect.Value{}, nil
+}
+
+// The grammar of a field tagged with since:"<version>", which is skipped, matching nothing, when
+// parsing with WithVersion() an earlier version of the input.
+type versioned struct {
+	node  node
+	since int
+}
+
+func (v *versioned) String() string { return stringer(v) }
+
+func (v *versioned) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	if ctx.version == nil || *ctx.version >= v.since {
+		return v.node.Parse(ctx, parent)
+	}
+	// An optional or repetition is followed by the remainder of its sequence, which still applies.
+	switch n := v.node.(type) {
+	case *optional:
+		if n.next != nil {
+			return n.next.Parse(ctx, parent)
+		}
+	case *repetition:
+		if n.next != nil {
+			return n.next.Parse(ctx, parent)
+		}
+	}
+	return []reflect.Value{}, nil
+}
+
+// ε
+//
+// Matches the empty string, consuming no input. As the last alternative of a disjunction, it
+// allows the disjunction to match nothing if no other alternative matches.
+type epsilon struct{}
+
+func (e *epsilon) String() string { return stringer(e) }
+
+func (e *epsilon) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	return []reflect.Value{}, nil
+}
+
+// ~<expr>
+//
+// Matches zero or more tokens, stopping without consuming it at the first token that begins a
+// match of <expr>, or at EOF. The value of each token matched is captured.
+type negation struct {
+	stop *positiveLookahead
+}
+
+func (n *negation) String() string { return stringer(n) }
+
+func (n *negation) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	out = []reflect.Value{}
+	for {
+		token, err := ctx.Peek(0)
+		if err != nil {
+			return nil, err
+		}
+		if token.EOF() {
+			return out, nil
+		}
+		stop, err := n.stop.Parse(ctx, parent)
+		if err != nil {
+			return nil, err
+		}
+		if stop != nil {
+			return out, nil
+		}
+		value, err := ctx.unescape(token)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = ctx.Next()
+		out = append(out, reflect.ValueOf(value))
+	}
+}
+
+// #<column> or #<min>-<max>
+//
+// A zero-width assertion that matches if the next token starts within the inclusive range of
+// columns, for fixed-column formats.
+type column struct {
+	min, max int
+}
+
+func (c *column) String() string { return stringer(c) }
+
+func (c *column) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return nil, err
+	}
+	if token.Pos.Column < c.min || token.Pos.Column > c.max {
+		return nil, nil
+	}
+	return []reflect.Value{}, nil
+}
+
+// &<expr>
+//
+// A zero-width assertion that matches if <expr> matches, without consuming input or capturing.
+type positiveLookahead struct {
+	node node
+}
+
+func (p *positiveLookahead) String() string { return stringer(p) }
+
+func (p *positiveLookahead) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	start, release := ctx.Hold()
+	defer release()
+	defer ctx.Restore(start)
+	speculative := ctx
+	speculative.cst = nil
+	speculative.annotationHook = nil
+	speculative.structHook = nil
+	speculative.events = nil
+	speculative.warnings = nil
+	speculative.captured = copyCaptured(ctx.captured)
+	speculative.merged = copyCaptured(ctx.merged)
+	speculative.exclusive = nil
+	speculative.docClaimed = copyClaimed(ctx.docClaimed)
+	speculative.trailingClaimed = copyClaimed(ctx.trailingClaimed)
+	speculative.triviaClaimed = copyClaimed(ctx.triviaClaimed)
+	// Parse into a copy of the parent so that captures are discarded.
+	if parent.IsValid() {
+		copied := reflect.New(parent.Type()).Elem()
+		copied.Set(parent)
+		parent = copied
+	}
+	value, err := p.node.Parse(speculative, parent)
+	if _, ok := err.(*MaxTokensError); ok {
+		return nil, err
+	}
+	if err != nil || value == nil {
+		return nil, nil
+	}
+	return []reflect.Value{}, nil
+}
+
+// ^( <expr> | <expr> ... )
+//
+// An exclusive group must match exactly one of its alternatives, exactly once, within the
+// repetition enclosing it.
+type exclusive struct {
+	disjunction
+}
+
+func (e *exclusive) String() string { return stringer(e) }
+
+func (e *exclusive) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return nil, err
+	}
+	branch, out, err := e.parseBranch(ctx, parent)
+	if err != nil || out == nil || ctx.exclusive == nil {
+		return out, err
+	}
+	if previous, ok := ctx.exclusive[e]; ok {
+		if previous == branch {
+			return out, lexer.Errorf(token.Pos, "%s may only be specified once", stringer(e.nodes[branch]))
+		}
+		return out, lexer.Errorf(token.Pos, "%s conflicts with %s", stringer(e.nodes[branch]), stringer(e.nodes[previous]))
+	}
+	ctx.exclusive[e] = branch
+	return out, nil
+}
+
+// Returns an error if the exclusive group was not matched in the enclosing repetition.
+func (e *exclusive) check(pos lexer.Position, matched map[*exclusive]int) error {
+	if _, ok := matched[e]; ok {
+		return nil
+	}
+	alternatives := []string{}
+	for _, n := range e.nodes {
+		alternatives = append(alternatives, stringer(n))
+	}
+	return lexer.Errorf(pos, "expected exactly one of %s", strings.Join(alternatives, ", "))
 }
 
 // <node> ...
@@ -151,16 +1167,79 @@ func (s *sequence) Parse(ctx parseContext, parent reflect.Value) (out []reflect.
 			if err != nil {
 				return nil, err
 			}
-			return out, lexer.Errorf(token.Pos, "unexpected %q (expected %s)", token, n)
+			if stopped := ctx.stopped; stopped != nil && stopped.repetition == n.node && stopped.cursor == ctx.Cursor() {
+				return out, stopped.err
+			}
+			return out, unexpected(token, n)
 		}
 	}
 	return out, nil
 }
 
+// An error for an unexpected token where n was expected, using any message registered for n with
+// ErrorMessage().
+func unexpected(token lexer.Token, n node) error {
+	expected := n
+	for {
+		switch e := expected.(type) {
+		case *sequence:
+			expected = e.node
+			continue
+		case *capture:
+			expected = e.node
+			continue
+		case *literal:
+			if e.message != "" {
+				return lexer.Errorf(token.Pos, "%s", e.message)
+			}
+		case *reference:
+			if e.message != "" {
+				return lexer.Errorf(token.Pos, "%s", e.message)
+			}
+		}
+		return lexer.Errorf(token.Pos, "unexpected %q (expected %s)", token, n)
+	}
+}
+
 // @<expr>
 type capture struct {
 	field structLexerField
-	node  node
+	// Additional fields the captured values are also assigned to.
+	also []structLexerField
+	// Allowed values, if the field type was registered with Enum().
+	enum *enum
+	// How multiple values captured into a string field are combined.
+	join stringJoin
+	// Separators of numbers captured into numeric fields, if provided by WithNumberFormat().
+	numbers *numberFormat
+	// If true, each match increments the field rather than assigning the captured values.
+	count bool
+	// If true, the source text spanned by the match is captured rather than its values.
+	raw bool
+	// Metadata attached to the field with Annotate(), if any.
+	annotation *Annotation
+	// Warning message for the field from Deprecate(), if any.
+	deprecated string
+	// Converts the matched tokens into the field's type, registered with Convert(), if any.
+	convert Converter
+	// If true, the values of matched tokens of type char are captured into a rune or byte field
+	// as the character, rather than parsed as a number.
+	chars bool
+	char  rune
+	// Called with each element appended to the slice field, registered with OnRepeat(), if any.
+	onRepeat func(element interface{})
+	// Canonical forms of captured values, registered with Canonicalize(), if any.
+	canonical map[string]string
+	// Counts the elided tokens preceding each match, registered with CountElided(), if any.
+	elided *elidedCounter
+	// Validates captured values, from the field's "opts" tag, if any.
+	validator *validator
+	// Maintains the elements of a set or sorted slice field, from the field's "opts" tag, if any.
+	collection *collection
+	// Assigns the groups of a regular expression matched against the captured value to fields,
+	// given by "@<expression> /<regex>/", if any.
+	groups *subCaptures
+	node   node
 }
 
 func (c *capture) String() string { return stringer(c) }
@@ -171,44 +1250,303 @@ func (c *capture) Parse(ctx parseContext, parent reflect.Value) (out []reflect.V
 		return nil, err
 	}
 	pos := token.Pos
+	start := ctx.Cursor()
+	// Retrieved now, as those preceding a long capture may be discarded before it matches.
+	elided := ctx.elided[start]
+	if c.convert != nil || c.chars {
+		// The matched tokens are retrieved once the capture has matched.
+		_, release := ctx.Hold()
+		defer release()
+	}
 	v, err := c.node.Parse(ctx, parent)
 	if err != nil {
-		if v != nil {
-			_ = setField(pos, parent, c.field, v)
+		// Partial values are not sent to channels, as they can not be retracted.
+		if v != nil && ctx.events == nil && c.convert == nil && c.groups == nil && c.field.Type.Kind() != reflect.Chan {
+			c.resetMerged(ctx, parent)
+			_ = c.set(pos, parent, v, ctx.captured)
 		}
 		return []reflect.Value{parent}, err
 	}
 	if v == nil {
 		return nil, nil
 	}
-	return []reflect.Value{parent}, setField(pos, parent, c.field, v)
+	if ctx.events != nil {
+		// Only the structure of the parse is reported, so values are neither converted nor assigned.
+		ctx.annotate(c.annotation, pos)
+		ctx.warn(c.deprecated, pos)
+		return []reflect.Value{parent}, nil
+	}
+	if c.raw {
+		end, err := ctx.BufferedLexer.Peek(0)
+		if err != nil {
+			return nil, err
+		}
+		v = []reflect.Value{reflect.ValueOf(ctx.sourceText(pos, ctx.consumedEnd(end.Pos)))}
+	} else if c.convert != nil {
+		if v, err = convertTokens(c.convert, pos, ctx.Range(start, ctx.Cursor())); err != nil {
+			return []reflect.Value{parent}, err
+		}
+	} else if c.chars {
+		if v, err = c.captureChars(ctx.Range(start, ctx.Cursor()), v); err != nil {
+			return []reflect.Value{parent}, err
+		}
+	}
+	if c.canonical != nil {
+		v = c.canonicalize(v)
+	}
+	ctx.annotate(c.annotation, pos)
+	ctx.warn(c.deprecated, pos)
+	if c.groups != nil {
+		return []reflect.Value{parent}, c.groups.set(pos, parent, v, c.join, c.numbers, ctx.captured)
+	}
+	if ctx.strictCaptures && !c.count {
+		for _, field := range append([]structLexerField{c.field}, c.also...) {
+			if kind := field.Type.Kind(); kind == reflect.Slice || kind == reflect.Chan || indirectType(field.Type).Kind() == reflect.String {
+				continue
+			}
+			if ctx.captured[field.Name] {
+				return []reflect.Value{parent}, lexer.Errorf(pos, "field %s captured multiple times", field.Name)
+			}
+			ctx.captured[field.Name] = true
+		}
+	}
+	c.resetMerged(ctx, parent)
+	if c.elided != nil {
+		c.elided.record(parent, elided)
+	}
+	if ctx.sends != nil && c.field.Type.Kind() == reflect.Chan {
+		// Values sent can not be retracted, so are held back until the branch is committed to. The
+		// sends of speculative parses of the longest match are never made.
+		*ctx.sends = append(*ctx.sends, func() error { return c.set(pos, parent, v, nil) })
+		return []reflect.Value{parent}, nil
+	}
+	if c.onRepeat == nil && c.validator == nil && c.collection == nil {
+		return []reflect.Value{parent}, c.set(pos, parent, v, ctx.captured)
+	}
+	f := parent.FieldByIndex(c.field.Index)
+	appended := 0
+	if f.Kind() == reflect.Slice {
+		appended = f.Len()
+	}
+	if err := c.set(pos, parent, v, ctx.captured); err != nil {
+		return []reflect.Value{parent}, err
+	}
+	if c.validator != nil {
+		if err := c.validator.validate(pos, f, appended); err != nil {
+			return []reflect.Value{parent}, err
+		}
+	}
+	var elements []reflect.Value
+	if c.collection != nil {
+		elements = c.collection.insert(f, appended, ctx.sets)
+	} else if f.Kind() == reflect.Slice {
+		for i := appended; i < f.Len(); i++ {
+			elements = append(elements, f.Index(i))
+		}
+	}
+	for i := 0; c.onRepeat != nil && i < len(elements); i++ {
+		c.onRepeat(elements[i].Interface())
+	}
+	return []reflect.Value{parent}, nil
+}
+
+// Reset the existing values of fields of a merged root struct the first time each is captured, so
+// that they are overwritten rather than appended to.
+func (c *capture) resetMerged(ctx parseContext, parent reflect.Value) {
+	if ctx.merged == nil {
+		return
+	}
+	for _, field := range append([]structLexerField{c.field}, c.also...) {
+		if field.setter != "" || field.Type.Kind() == reflect.Chan || ctx.merged[field.Name] {
+			continue
+		}
+		ctx.merged[field.Name] = true
+		f := parent.FieldByIndex(field.Index)
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
+
+// Sets or appends to an integer field the number of elided tokens preceding a capture.
+type elidedCounter struct {
+	// The struct and index of the counter field.
+	typ   reflect.Type
+	index []int
+	// Token types counted, or nil to count all elided tokens.
+	types map[rune]bool
+}
+
+func (e *elidedCounter) record(parent reflect.Value, elided []lexer.Token) {
+	if parent.Type() != e.typ {
+		// A capture into an element of a group.
+		return
+	}
+	count := 0
+	for _, token := range elided {
+		if e.types == nil || e.types[token.Type] {
+			count++
+		}
+	}
+	f := parent.FieldByIndex(e.index)
+	if f.Kind() == reflect.Slice {
+		f.Set(reflect.Append(f, reflect.ValueOf(count).Convert(f.Type().Elem())))
+	} else {
+		f.SetInt(int64(count))
+	}
+}
+
+// Replace captured values with their canonical forms.
+func (c *capture) canonicalize(v []reflect.Value) []reflect.Value {
+	out := make([]reflect.Value, len(v))
+	for i, value := range v {
+		out[i] = value
+		if value.Kind() != reflect.String {
+			continue
+		}
+		if form, ok := c.canonical[value.String()]; ok {
+			out[i] = reflect.ValueOf(form)
+		}
+	}
+	return out
+}
+
+// Assign captured values to the field, and to any additional fields, given the fields of the
+// innermost struct captured so far, if tracked.
+func (c *capture) set(pos lexer.Position, parent reflect.Value, v []reflect.Value, captured map[string]bool) error {
+	if c.count {
+		for _, field := range append([]structLexerField{c.field}, c.also...) {
+			f := parent.FieldByIndex(field.Index)
+			if f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64 {
+				f.SetUint(f.Uint() + 1)
+			} else {
+				f.SetInt(f.Int() + 1)
+			}
+		}
+		return nil
+	}
+	if c.enum != nil {
+		if err := c.enum.validate(pos, v); err != nil {
+			return err
+		}
+	}
+	for _, field := range append([]structLexerField{c.field}, c.also...) {
+		if err := setField(pos, parent, field, v, c.join.of(field, captured), c.numbers); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // <identifier> - named lexer token reference
 type reference struct {
 	typ        rune
 	identifier string // Used for informational purposes.
+	// Additional token types matched by a set reference, eg. (String|Number).
+	set []rune
+	// Error reported if the reference is expected but not matched, registered with ErrorMessage().
+	message string
 }
 
 func (r *reference) String() string { return stringer(r) }
 
+// Returns true if the reference matches tokens of type typ.
+func (r *reference) matches(typ rune) bool {
+	if typ == r.typ {
+		return true
+	}
+	for _, t := range r.set {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *reference) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
 	token, err := ctx.Peek(0)
 	if err != nil {
 		return nil, err
 	}
-	if token.Type != r.typ {
+	if !r.matches(token.Type) {
+		return nil, nil
+	}
+	value, err := ctx.unescape(token)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = ctx.Next()
+	return []reflect.Value{reflect.ValueOf(value)}, nil
+}
+
+// $<name> - an identifier in the keyword set provided at parse time
+type keywordSet struct {
+	name string
+	typ  rune
+}
+
+func (k *keywordSet) String() string { return stringer(k) }
+
+func (k *keywordSet) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return nil, err
+	}
+	if token.Type != k.typ || !ctx.keywords[k.name][token.Value] {
 		return nil, nil
 	}
 	_, _ = ctx.Next()
 	return []reflect.Value{reflect.ValueOf(token.Value)}, nil
 }
 
+// [ "-" | "+" ] <reference> - a number with an optional sign token, folded into one value
+type signedNumber struct {
+	number *reference
+	// The equivalent grammar, used for lookahead.
+	grammar node
+}
+
+func newSignedNumber(number *reference) *signedNumber {
+	signed := func(sign string) node {
+		return &sequence{head: true, node: &literal{s: sign, t: lexer.EOF, unquoted: true}, next: &sequence{node: number}}
+	}
+	return &signedNumber{
+		number:  number,
+		grammar: &disjunction{nodes: []node{signed("-"), signed("+"), number}},
+	}
+}
+
+func (s *signedNumber) String() string { return stringer(s) }
+
+func (s *signedNumber) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return nil, err
+	}
+	if s.number.matches(token.Type) {
+		_, _ = ctx.Next()
+		return []reflect.Value{reflect.ValueOf(token.Value)}, nil
+	}
+	if !isValue(ctx.quoted, token, "-") && !isValue(ctx.quoted, token, "+") {
+		return nil, nil
+	}
+	number, err := ctx.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if !s.number.matches(number.Type) {
+		return nil, nil
+	}
+	_, _ = ctx.Next()
+	_, _ = ctx.Next()
+	return []reflect.Value{reflect.ValueOf(token.Value + number.Value)}, nil
+}
+
 // [ <expr> ] <sequence>
 type optional struct {
 	node      node
 	next      node
 	lookahead lookaheadTable
+	// If set, records whether node matched.
+	present *structLexerField
 }
 
 func (o *optional) String() string { return stringer(o) }
@@ -222,15 +1560,36 @@ func (o *optional) Parse(ctx parseContext, parent reflect.Value) (out []reflect.
 	case -2: // No lookahead table
 		fallthrough
 	case 0:
-		out, err = o.node.Parse(ctx, parent)
+		var restore func()
+		release := func() {}
+		body, committed := ctx, new(bool)
+		if ctx.backtrack {
+			restore, release = ctx.checkpoint(parent)
+			body, committed = ctx.branch()
+		}
+		out, err = o.node.Parse(body, parent)
 		if err != nil {
+			if restore == nil || *committed {
+				release()
+				return out, err
+			}
+			restore()
+			out = nil
+		} else if err := ctx.commitSends(body); err != nil {
+			release()
 			return out, err
 		}
+		release()
+		o.setPresent(parent, out != nil)
 		if out == nil {
 			out = []reflect.Value{}
 		}
 		fallthrough
 	case 1:
+		if result == 1 {
+			o.setPresent(parent, false)
+			out = []reflect.Value{}
+		}
 		if o.next != nil {
 			next, err := o.next.Parse(ctx, parent)
 			if err != nil {
@@ -247,24 +1606,60 @@ func (o *optional) Parse(ctx parseContext, parent reflect.Value) (out []reflect.
 		if o.next != nil {
 			return nil, nil
 		}
+		o.setPresent(parent, false)
 		return []reflect.Value{}, nil
 	default:
 		panic("unexpected selection")
 	}
 }
 
+func (o *optional) setPresent(parent reflect.Value, matched bool) {
+	if o.present == nil {
+		return
+	}
+	f := parent.FieldByIndex(o.present.Index)
+	if f.Kind() == reflect.Ptr {
+		f.Set(reflect.New(f.Type().Elem()))
+		f.Elem().SetBool(matched)
+	} else {
+		f.SetBool(matched)
+	}
+}
+
 // { <expr> } <sequence>
 type repetition struct {
 	node      node
 	next      node
 	lookahead lookaheadTable
+	// Exclusive groups that must be matched exactly once within the repetition.
+	exclusive []*exclusive
 }
 
 func (r *repetition) String() string { return stringer(r) }
 
+// A repetition that matched, but whose remainder did not, at cursor.
+type stoppedRepetition struct {
+	repetition *repetition
+	cursor     int
+	// The error reporting the remainder as unexpected.
+	err error
+}
+
 // Parse a repetition. Once a repetition is encountered it will always match, so grammars
 // should ensure that branches are differentiated prior to the repetition.
 func (r *repetition) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	if ctx.recovery != nil && ctx.recovery.root == r {
+		return r.parseRecovering(ctx, parent)
+	}
+	if ctx.statement == r {
+		// A single match, which may be empty at the end of the input.
+		out, err = r.node.Parse(ctx, parent)
+		if out == nil && err == nil {
+			out = []reflect.Value{}
+		}
+		return out, err
+	}
+	start := ctx.Cursor()
 	result, err := r.lookahead.Select(ctx, parent)
 	if err != nil {
 		return nil, err
@@ -273,9 +1668,31 @@ func (r *repetition) Parse(ctx parseContext, parent reflect.Value) (out []reflec
 	case -2: // No lookahead table
 		fallthrough
 	case 0:
+		if r.exclusive != nil {
+			ctx.exclusive = map[*exclusive]int{}
+		}
 		for {
-			v, err := r.node.Parse(ctx, parent)
-			out = append(out, v...)
+			var restore func()
+			release := func() {}
+			body, committed := ctx, new(bool)
+			if ctx.backtrack {
+				restore, release = ctx.checkpoint(parent)
+				body, committed = ctx.branch()
+			}
+			v, err := r.node.Parse(body, parent)
+			if err != nil && restore != nil && !*committed {
+				restore()
+				release()
+				break
+			}
+			if err == nil {
+				err = ctx.commitSends(body)
+			}
+			release()
+			if ctx.events == nil {
+				// The values of an event parse are discarded, so are not accumulated.
+				out = append(out, v...)
+			}
 			if err != nil {
 				return out, err
 			}
@@ -286,6 +1703,9 @@ func (r *repetition) Parse(ctx parseContext, parent reflect.Value) (out []reflec
 		if out == nil {
 			out = []reflect.Value{}
 		}
+		if err := r.checkExclusive(ctx); err != nil {
+			return out, err
+		}
 		fallthrough
 	case 1:
 		if r.next != nil {
@@ -295,6 +1715,15 @@ func (r *repetition) Parse(ctx parseContext, parent reflect.Value) (out []reflec
 				return out, err
 			}
 			if next == nil {
+				if ctx.Cursor() > start && !ctx.backtrack && ctx.stopped != nil {
+					// Recorded so that if the enclosing sequence fails here it reports the remainder of
+					// the sequence, such as a closing token, rather than the repetition.
+					token, err := ctx.Peek(0)
+					if err != nil {
+						return nil, err
+					}
+					*ctx.stopped = stoppedRepetition{repetition: r, cursor: ctx.Cursor(), err: unexpected(token, r.next)}
+				}
 				return nil, nil
 			}
 		}
@@ -310,11 +1739,311 @@ func (r *repetition) Parse(ctx parseContext, parent reflect.Value) (out []reflec
 	}
 }
 
+// Parse the root repetition to the end of the input, recording the error from each failed match and
+// skipping past the next sync token.
+func (r *repetition) parseRecovering(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	if r.exclusive != nil {
+		ctx.exclusive = map[*exclusive]int{}
+	}
+	out = []reflect.Value{}
+	for {
+		token, err := ctx.Peek(0)
+		if err != nil {
+			return out, err
+		}
+		if token.EOF() {
+			break
+		}
+		restore, release := ctx.checkpoint(parent)
+		v, err := r.node.Parse(ctx, parent)
+		if err == nil && v != nil {
+			release()
+			if ctx.events != nil {
+				if !ctx.backtrack {
+					ctx.events.flush()
+				}
+			} else {
+				out = append(out, v...)
+			}
+			continue
+		}
+		if err == nil {
+			err = lexer.Errorf(token.Pos, "expected %s but got %q", r.node, token)
+		}
+		if len(ctx.recovery.errors) == ctx.recovery.max && ctx.recovery.max > 0 {
+			ctx.recovery.errors = append(ctx.recovery.errors, ErrTooManyErrors)
+			release()
+			return out, nil
+		}
+		ctx.recovery.errors = append(ctx.recovery.errors, err)
+		// Discard the partial match, resuming from wherever it failed.
+		failed := ctx.Cursor()
+		restore()
+		release()
+		if err := ctx.Restore(failed); err != nil {
+			return out, err
+		}
+		if err := ctx.recovery.skip(ctx); err != nil {
+			return out, err
+		}
+	}
+	return out, r.checkExclusive(ctx)
+}
+
+func (r *repetition) checkExclusive(ctx parseContext) error {
+	if r.exclusive == nil {
+		return nil
+	}
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return err
+	}
+	for _, e := range r.exclusive {
+		if err := e.check(token.Pos, ctx.exclusive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind each exclusive group to its innermost enclosing repetition.
+//
+// A struct may be matched within several repetitions, so nodes are visited once per owner.
+func bindExclusive(root node) {
+	type visited struct {
+		node  node
+		owner *repetition
+	}
+	seen := map[visited]bool{}
+	var bind func(n node, owner *repetition)
+	bind = func(n node, owner *repetition) {
+		if n == nil || seen[visited{n, owner}] {
+			return
+		}
+		seen[visited{n, owner}] = true
+		switch n := n.(type) {
+		case *repetition:
+			// The body has the same owner wherever the repetition is, so is only bound once.
+			if !seen[visited{n, n}] {
+				seen[visited{n, n}] = true
+				n.exclusive = nil
+				bind(n.node, n)
+			}
+			bind(n.next, owner)
+			return
+		case *exclusive:
+			if owner != nil {
+				owner.exclusive = append(owner.exclusive, n)
+			}
+		}
+		for _, child := range children(n) {
+			bind(child, owner)
+		}
+	}
+	bind(root, nil)
+}
+
+// RecoveredErrors is returned by Parse when the parser was built with Recover() and one or more
+// statements failed to parse, in the order they occurred.
+type RecoveredErrors []error
+
+func (r RecoveredErrors) Error() string {
+	out := make([]string, 0, len(r))
+	for _, err := range r {
+		out = append(out, err.Error())
+	}
+	return strings.Join(out, "; ")
+}
+
+// Is reports whether target is ErrTooManyErrors and the errors were truncated by MaxErrors().
+func (r RecoveredErrors) Is(target error) bool {
+	return target == ErrTooManyErrors && len(r) > 0 && r[len(r)-1] == ErrTooManyErrors
+}
+
+// Error recovery state for the root repetition.
+type recovery struct {
+	root   *repetition
+	sync   []string
+	errors RecoveredErrors
+	// Number of errors after which parsing stops, if non-zero, provided by MaxErrors().
+	max int
+}
+
+// Consume tokens up to and including the next sync token, or until the end of the input.
+func (r *recovery) skip(ctx parseContext) error {
+	for {
+		token, err := ctx.Peek(0)
+		if err != nil || token.EOF() {
+			return err
+		}
+		// Skipped tokens are not matched by the grammar, so are not recorded in the CST or events.
+		_, _ = ctx.BufferedLexer.Next()
+		for _, sync := range r.sync {
+			if isValue(ctx.quoted, token, sync) {
+				return nil
+			}
+		}
+	}
+}
+
+// < <expr> | <expr> ... >
+type unordered struct {
+	nodes     []node
+	lookahead lookaheadTable
+}
+
+func (u *unordered) String() string { return stringer(u) }
+
+// Parse an unordered group. Each member is matched at most once, with the group ending when no
+// remaining member matches. An unordered group always matches, even if no members do.
+func (u *unordered) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	matched := make([]bool, len(u.nodes))
+	out = []reflect.Value{}
+	for {
+		selected, err := u.lookahead.selectFrom(ctx, matched)
+		if err != nil {
+			return out, err
+		}
+		switch selected {
+		case -1:
+			return out, nil
+		case -2: // No lookahead table
+			selected = -1
+			for i, n := range u.nodes {
+				if matched[i] {
+					continue
+				}
+				v, err := n.Parse(ctx, parent)
+				out = append(out, v...)
+				if err != nil {
+					return out, err
+				}
+				if v != nil {
+					selected = i
+					break
+				}
+			}
+			if selected == -1 {
+				return out, nil
+			}
+		default:
+			v, err := u.nodes[selected].Parse(ctx, parent)
+			out = append(out, v...)
+			if err != nil {
+				return out, err
+			}
+			if v == nil {
+				return out, nil
+			}
+		}
+		matched[selected] = true
+	}
+}
+
+// %{ <expr> [?*+] | <expr> [?*+] ... }
+type record struct {
+	nodes       []node
+	cardinality []cardinality
+	lookahead   lookaheadTable
+}
+
+// The number of times a record member may match. A max of -1 is unbounded.
+type cardinality struct {
+	min, max int
+}
+
+func (c cardinality) String() string {
+	switch {
+	case c.min == 0 && c.max == 1:
+		return "?"
+	case c.min == 0:
+		return "*"
+	case c.max == -1:
+		return "+"
+	}
+	return ""
+}
+
+func (r *record) String() string { return stringer(r) }
+
+// Parse a record. Members are matched in any order until none that may still match does, at
+// which point every required member must have been matched. A record with required members does
+// not match if no members match.
+func (r *record) Parse(ctx parseContext, parent reflect.Value) (out []reflect.Value, err error) {
+	counts := make([]int, len(r.nodes))
+	exhausted := make([]bool, len(r.nodes))
+	matched := false
+	out = []reflect.Value{}
+	for {
+		selected, err := r.lookahead.selectFrom(ctx, exhausted)
+		if err != nil {
+			return out, err
+		}
+		switch selected {
+		case -1:
+		case -2: // No lookahead table
+			selected = -1
+			for i, n := range r.nodes {
+				if exhausted[i] {
+					continue
+				}
+				v, err := n.Parse(ctx, parent)
+				out = append(out, v...)
+				if err != nil {
+					return out, err
+				}
+				if v != nil {
+					selected = i
+					break
+				}
+			}
+		default:
+			v, err := r.nodes[selected].Parse(ctx, parent)
+			out = append(out, v...)
+			if err != nil {
+				return out, err
+			}
+			if v == nil {
+				selected = -1
+			}
+		}
+		if selected == -1 {
+			break
+		}
+		matched = true
+		counts[selected]++
+		if max := r.cardinality[selected].max; max != -1 && counts[selected] >= max {
+			exhausted[selected] = true
+		}
+	}
+	missing := []string{}
+	for i, card := range r.cardinality {
+		if counts[i] < card.min {
+			missing = append(missing, r.nodes[i].String())
+		}
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+	if !matched {
+		return nil, nil
+	}
+	token, err := ctx.Peek(0)
+	if err != nil {
+		return out, err
+	}
+	return out, lexer.Errorf(token.Pos, "missing required %s", strings.Join(missing, ", "))
+}
+
 // Match a token literal exactly "..."[:<type>].
 type literal struct {
 	s  string
 	t  rune
 	tt string // Used for display purposes - symbolic name of t.
+	// If set, the literal is a value given to an option, which does not match unquoted strings.
+	unquoted bool
+	// Error reported if the literal is expected but not matched, registered with ErrorMessage().
+	message string
 }
 
 func (l *literal) String() string { return stringer(l) }
@@ -330,7 +2059,7 @@ func (l *literal) Parse(ctx parseContext, parent reflect.Value) (out []reflect.V
 	} else {
 		equal = token.Value == l.s
 	}
-	if equal && (l.t == -1 || l.t == token.Type) {
+	if equal && (l.t == -1 || l.t == token.Type) && !(l.unquoted && ctx.quoted[token.Type]) {
 		next, err := ctx.Next()
 		if err != nil {
 			return nil, err
@@ -345,6 +2074,11 @@ func (l *literal) Parse(ctx parseContext, parent reflect.Value) (out []reflect.V
 // This will dereference pointers, and attempt to parse strings into integer values, floats, etc.
 func conform(t reflect.Type, values []reflect.Value) (out []reflect.Value, err error) {
 	for _, v := range values {
+		// Union members are already of a type implementing the interface.
+		if t.Kind() == reflect.Interface && v.Type().Implements(t) {
+			out = append(out, v)
+			continue
+		}
 		for t != v.Type() && t.Kind() == reflect.Ptr && v.Kind() != reflect.Ptr {
 			// This can occur during partial failure.
 			if !v.CanAddr() {
@@ -355,6 +2089,9 @@ func conform(t reflect.Type, values []reflect.Value) (out []reflect.Value, err e
 
 		// Already of the right kind, don't bother converting.
 		if v.Kind() == t.Kind() {
+			if v.Kind() == reflect.String && v.Type() != t {
+				v = v.Convert(t)
+			}
 			out = append(out, v)
 			continue
 		}
@@ -394,6 +2131,64 @@ func conform(t reflect.Type, values []reflect.Value) (out []reflect.Value, err e
 	return out, nil
 }
 
+// Returns true if every value is a string.
+func allStrings(values []reflect.Value) bool {
+	for _, v := range values {
+		if v.Kind() != reflect.String {
+			return false
+		}
+	}
+	return true
+}
+
+// Replace the values of tokens of type c.char with the character they contain, as a value of the
+// rune or byte element type of the field.
+func (c *capture) captureChars(tokens []lexer.Token, values []reflect.Value) ([]reflect.Value, error) {
+	if len(tokens) != len(values) {
+		// Values do not correspond to the matched tokens, eg. if they were unescaped or joined.
+		return values, nil
+	}
+	t := elementType(c.field.Type)
+	out := make([]reflect.Value, len(values))
+	for i, token := range tokens {
+		out[i] = values[i]
+		if token.Type != c.char {
+			continue
+		}
+		value := values[i].String()
+		v := reflect.New(t).Elem()
+		if t.Kind() == reflect.Uint8 {
+			if len(value) != 1 {
+				return nil, lexer.Errorf(token.Pos, "invalid byte %q: must be a single byte", value)
+			}
+			v.SetUint(uint64(value[0]))
+		} else {
+			r, size := utf8.DecodeRuneInString(value)
+			if size == 0 || size != len(value) {
+				return nil, lexer.Errorf(token.Pos, "invalid rune %q: must be a single character", value)
+			}
+			v.SetInt(int64(r))
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// Returns true if values captured into a field of type t can be characters, ie. it is a rune or
+// byte, a pointer to one or a slice of them.
+func isCharType(t reflect.Type) bool {
+	kind := elementType(t).Kind()
+	return kind == reflect.Int32 || kind == reflect.Uint8
+}
+
+func elementType(t reflect.Type) reflect.Type {
+	t = indirectType(t)
+	if t.Kind() == reflect.Slice {
+		t = indirectType(t.Elem())
+	}
+	return t
+}
+
 func sizeOfKind(kind reflect.Kind) int {
 	switch kind {
 	case reflect.Int8, reflect.Uint8:
@@ -410,26 +2205,184 @@ func sizeOfKind(kind reflect.Kind) int {
 	panic("unsupported kind " + kind.String())
 }
 
+// How multiple values captured into a single string field are combined.
+type stringJoin struct {
+	separator string
+	// If true, capturing more than one value into the field is an error.
+	strict bool
+	// If true, a value has already been captured into the field, so the next is joined to it.
+	joined bool
+}
+
+// Returns the join for a capture into field, recording that field has been captured into if
+// captured, the fields of the innermost struct captured so far, is tracked.
+func (j stringJoin) of(field structLexerField, captured map[string]bool) stringJoin {
+	if captured == nil || field.Type.Kind() == reflect.Slice || indirectType(field.Type).Kind() != reflect.String {
+		return j
+	}
+	j.joined = captured[field.Name]
+	captured[field.Name] = true
+	return j
+}
+
+// Decimal and grouping separators of numbers, provided by WithNumberFormat().
+type numberFormat struct {
+	decimal  rune
+	grouping rune
+}
+
+// Rewrite each numeric value using the separators understood by strconv, removing grouping
+// separators and replacing the decimal separator with ".".
+//
+// Values without digits, such as a separately captured sign, are left unchanged.
+func (n *numberFormat) normalize(values []reflect.Value) ([]reflect.Value, error) {
+	out := make([]reflect.Value, 0, len(values))
+	for _, v := range values {
+		value := v.String()
+		if !strings.ContainsAny(value, "0123456789") {
+			out = append(out, v)
+			continue
+		}
+		integer, fraction := value, ""
+		decimal := strings.IndexRune(value, n.decimal)
+		if decimal >= 0 {
+			integer, fraction = value[:decimal], value[decimal+utf8.RuneLen(n.decimal):]
+			if strings.ContainsRune(fraction, n.decimal) {
+				return nil, fmt.Errorf("invalid number %q: multiple decimal separators", value)
+			}
+			if n.grouping != 0 && strings.ContainsRune(fraction, n.grouping) {
+				return nil, fmt.Errorf("invalid number %q: grouping separator after decimal separator", value)
+			}
+		}
+		if n.grouping != 0 && strings.ContainsRune(integer, n.grouping) {
+			// Groups after the first must be of exactly three digits, eg. 1.234.567
+			groups := strings.Split(integer, string(n.grouping))
+			first := strings.TrimLeft(groups[0], "+-")
+			valid := len(first) > 0 && len(first) <= 3
+			for _, group := range groups[1:] {
+				valid = valid && len(group) == 3
+			}
+			if !valid {
+				return nil, fmt.Errorf("invalid number %q: misplaced grouping separator", value)
+			}
+			integer = strings.Join(groups, "")
+		}
+		if decimal >= 0 {
+			integer += "." + fraction
+		}
+		out = append(out, reflect.ValueOf(integer))
+	}
+	return out, nil
+}
+
+// Returns true if values captured into a field of type t are parsed as numbers.
+func isNumeric(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	t = indirectType(t)
+	if t == bigIntType || t == bigFloatType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
 // Set field.
 //
 // If field is a pointer the pointer will be set to the value. If field is a string, value will be
-// appended. If field is a slice, value will be appended to slice.
+// appended, separated by join.separator. If field is a slice, value will be appended to slice.
 //
 // For all other types, an attempt will be made to convert the string to the corresponding
-// type (int, float32, etc.).
-func setField(pos lexer.Position, strct reflect.Value, field structLexerField, fieldValue []reflect.Value) (err error) { // nolint: gocyclo
-	defer decorate(&err, func() string { return pos.String() + ": " + strct.Type().String() + "." + field.Name })
+// type (int, float32, etc.), first normalising the separators of numbers if numbers is non-nil.
+func setField(pos lexer.Position, strct reflect.Value, field structLexerField, fieldValue []reflect.Value, join stringJoin, numbers *numberFormat) (err error) { // nolint: gocyclo
+	defer func() {
+		if isPositioned(err) {
+			// Already positioned.
+			decorate(&err, func() string { return strct.Type().String() + "." + field.Name })
+			return
+		}
+		decorate(&err, func() string { return pos.String() + ": " + strct.Type().String() + "." + field.Name })
+	}()
 
-	f := strct.FieldByIndex(field.Index)
+	var f reflect.Value
+	if field.setter != "" {
+		// Convert into a temporary value that is then passed to the setter.
+		value := reflect.New(field.Type).Elem()
+		defer func() {
+			if err == nil {
+				err = callSetter(strct, field.setter, value)
+			}
+		}()
+		f = value
+	} else {
+		f = strct.FieldByIndex(field.Index)
+	}
+	if numbers != nil && isNumeric(f.Type()) {
+		if fieldValue, err = numbers.normalize(fieldValue); err != nil {
+			return err
+		}
+	}
 	switch f.Kind() {
 	case reflect.Slice:
+		if elem := f.Type().Elem(); indirectType(elem) == bigIntType || indirectType(elem) == bigFloatType {
+			for _, v := range fieldValue {
+				n, err := parseBig(pos, indirectType(elem), v.String())
+				if err != nil {
+					return err
+				}
+				if elem.Kind() != reflect.Ptr {
+					n = n.Elem()
+				}
+				f.Set(reflect.Append(f, n))
+			}
+			return nil
+		}
+		if elem := f.Type().Elem(); reflect.PtrTo(indirectType(elem)).Implements(captureType) && allStrings(fieldValue) {
+			// Each capture appends a single element, built from all of the values captured.
+			n := reflect.New(indirectType(elem))
+			values := []string{}
+			for _, v := range fieldValue {
+				values = append(values, v.String())
+			}
+			if err := n.Interface().(Capture).Capture(values); err != nil {
+				return err
+			}
+			if elem.Kind() != reflect.Ptr {
+				n = n.Elem()
+			}
+			f.Set(reflect.Append(f, n))
+			return nil
+		}
 		fieldValue, err = conform(f.Type().Elem(), fieldValue)
 		if err != nil {
 			return err
 		}
+		for i, v := range fieldValue {
+			maybeInjectIndex(v, f.Len()+i)
+		}
 		f.Set(reflect.Append(f, fieldValue...))
 		return nil
 
+	case reflect.Chan:
+		// Each element is sent as it is parsed, blocking while the channel is full.
+		if f.IsNil() {
+			return fmt.Errorf("channel is nil")
+		}
+		fieldValue, err = conform(f.Type().Elem(), fieldValue)
+		if err != nil {
+			return err
+		}
+		for _, v := range fieldValue {
+			f.Send(v)
+		}
+		return nil
+
 	case reflect.Ptr:
 		if f.IsNil() {
 			fv := reflect.New(f.Type().Elem()).Elem()
@@ -440,6 +2393,19 @@ func setField(pos lexer.Position, strct reflect.Value, field structLexerField, f
 		}
 	}
 
+	if f.Type() == bigIntType || f.Type() == bigFloatType {
+		values := []string{}
+		for _, v := range fieldValue {
+			values = append(values, v.String())
+		}
+		n, err := parseBig(pos, f.Type(), strings.Join(values, ""))
+		if err != nil {
+			return err
+		}
+		f.Set(n.Elem())
+		return nil
+	}
+
 	if f.Kind() == reflect.Struct {
 		if pf := f.FieldByName("Pos"); pf.IsValid() && pf.Type() == positionType {
 			pf.Set(reflect.ValueOf(pos))
@@ -460,14 +2426,21 @@ func setField(pos lexer.Position, strct reflect.Value, field structLexerField, f
 		}
 	}
 
-	// Strings concatenate all captured tokens.
+	// Strings concatenate all captured tokens, optionally with a separator.
 	if f.Kind() == reflect.String {
 		fieldValue, err = conform(f.Type(), fieldValue)
 		if err != nil {
 			return err
 		}
-		for _, v := range fieldValue {
-			f.Set(reflect.ValueOf(f.String() + v.String()).Convert(f.Type()))
+		if join.strict && (join.joined || len(fieldValue) > 1) {
+			return fmt.Errorf("multiple values captured into string field")
+		}
+		for i, v := range fieldValue {
+			value := v.String()
+			if join.joined || i > 0 {
+				value = join.separator + value
+			}
+			f.Set(reflect.ValueOf(f.String() + value).Convert(f.Type()))
 		}
 		return nil
 	}
@@ -517,14 +2490,50 @@ func setField(pos lexer.Position, strct reflect.Value, field structLexerField, f
 		}
 		f.Set(fv)
 
+	case reflect.Interface:
+		if !fv.Type().Implements(f.Type()) {
+			return fmt.Errorf("value %q does not implement %s", fv, f.Type())
+		}
+		f.Set(fv)
+
 	default:
 		return fmt.Errorf("unsupported field type %s for field %s", f.Type(), field.Name)
 	}
 	return nil
 }
 
+// Parse s as a *big.Int or *big.Float, detecting the base from its prefix.
+func parseBig(pos lexer.Position, t reflect.Type, s string) (reflect.Value, error) {
+	if t == bigIntType {
+		n, ok := new(big.Int).SetString(s, 0)
+		if !ok {
+			return reflect.Value{}, lexer.Errorf(pos, "invalid integer %q", s)
+		}
+		return reflect.ValueOf(n), nil
+	}
+	// Use enough precision to represent every decimal digit.
+	prec := uint(len(s)) * 4
+	if prec < 64 {
+		prec = 64
+	}
+	n, _, err := big.ParseFloat(s, 0, prec, big.ToNearestEven)
+	if err != nil {
+		return reflect.Value{}, lexer.Errorf(pos, "invalid float %q: %s", s, err)
+	}
+	return reflect.ValueOf(n), nil
+}
+
+// Call the setter method on the struct pointer with value.
+func callSetter(strct reflect.Value, setter string, value reflect.Value) error {
+	out := strct.Addr().MethodByName(setter).Call([]reflect.Value{value})
+	if len(out) == 1 && !out[0].IsNil() {
+		return out[0].Interface().(error)
+	}
+	return nil
+}
+
 func indirectType(t reflect.Type) reflect.Type {
-	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Chan {
 		return indirectType(t.Elem())
 	}
 	return t
@@ -534,3 +2543,74 @@ func indirectType(t reflect.Type) reflect.Type {
 type Error string
 
 func (e Error) Error() string { return string(e) }
+
+// ParseError is a positioned parse error along with the stack of productions, outermost first,
+// that were being parsed when it occurred, which is only recorded with ProductionStack().
+type ParseError struct {
+	Message string
+	Pos     lexer.Position
+	Stack   []string
+	// Incomplete is true if the error occurred at the end of the input.
+	Incomplete bool
+	// The error the ParseError was created from, if any.
+	err *lexer.Error
+}
+
+func (p *ParseError) Error() string {
+	if len(p.Stack) == 0 {
+		return lexer.Errorf(p.Pos, "%s", p.Message).Error()
+	}
+	return lexer.Errorf(p.Pos, "while parsing %s: %s", strings.Join(p.Stack, " > "), p.Message).Error()
+}
+
+// Is reports whether target is ErrIncomplete and the error occurred at the end of the input.
+func (p *ParseError) Is(target error) bool { return target == ErrIncomplete && p.Incomplete }
+
+// Unwrap returns the *lexer.Error the ParseError was created from, or else the error as a
+// lexer.Error, without the production stack.
+func (p *ParseError) Unwrap() error {
+	if p.err != nil {
+		return p.err
+	}
+	return &lexer.Error{Message: p.Message, Pos: p.Pos}
+}
+
+// Returns true if err carries its own position, such as an error from a delegated parse.
+func isPositioned(err error) bool {
+	switch err.(type) {
+	case *lexer.Error, *ParseError:
+		return true
+	}
+	return false
+}
+
+// HookError is returned when a hook registered with OnStruct() fails, wrapping its error with the
+// position of the struct.
+type HookError struct {
+	Pos lexer.Position
+	Err error
+}
+
+func (h *HookError) Error() string { return lexer.Errorf(h.Pos, "%s", h.Err).Error() }
+
+// Unwrap returns the error returned by the hook.
+func (h *HookError) Unwrap() error { return h.Err }
+
+// MaxTokensError is returned when the input contains more tokens than allowed by MaxTokens().
+type MaxTokensError struct {
+	Limit int
+	Pos   lexer.Position
+}
+
+func (m *MaxTokensError) Error() string {
+	return lexer.Errorf(m.Pos, "input exceeds the maximum of %d tokens", m.Limit).Error()
+}
+
+// UnescapeError may be returned by an unescape function registered with WithUnescaper() to report
+// the byte offset within the token value of an invalid escape.
+type UnescapeError struct {
+	Offset  int
+	Message string
+}
+
+func (u *UnescapeError) Error() string { return u.Message }
@@ -1,6 +1,13 @@
 package participle
 
-import "github.com/alecthomas/participle/lexer"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/alecthomas/participle/lexer"
+)
 
 // An Option to modify the behaviour of the Parser.
 type Option func(p *Parser) error
@@ -13,6 +20,18 @@ func Lexer(def lexer.Definition) Option {
 	}
 }
 
+// LazyLexer defers choosing the lexer until the Parser is first used.
+//
+// This allows a grammar to be built before its lexer is finalised. Token type names in the grammar
+// are resolved against the returned definition on first use, and any errors that Build() would
+// otherwise have returned, such as unknown token types, are returned from that call instead.
+func LazyLexer(def func() (lexer.Definition, error)) Option {
+	return func(p *Parser) error {
+		p.lazyLexer = def
+		return nil
+	}
+}
+
 // UseLookahead builds lookahead tables for disambiguating branches.
 //
 // NOTE: This is an experimental feature.
@@ -23,6 +42,111 @@ func UseLookahead() Option {
 	}
 }
 
+// MaxLookaheadTable causes Build to fail if any lookahead table has more than n entries.
+//
+// This guards against grammars whose lookahead tables grow combinatorially. It only applies when
+// UseLookahead() is also provided.
+func MaxLookaheadTable(n int) Option {
+	return func(p *Parser) error {
+		p.maxLookahead = n
+		return nil
+	}
+}
+
+// MaxTokens causes parsing to fail with a *MaxTokensError if the input contains more than n tokens.
+//
+// This guards against excessively large input. Elided tokens are not counted.
+func MaxTokens(n int) Option {
+	return func(p *Parser) error {
+		p.maxTokens = n
+		return nil
+	}
+}
+
+// ReportLookaheadTables calls report with the size of each lookahead table as it is built.
+//
+// It only applies when UseLookahead() is also provided.
+func ReportLookaheadTables(report func(size LookaheadTableSize)) Option {
+	return func(p *Parser) error {
+		p.reportLookahead = report
+		return nil
+	}
+}
+
+// ReportUncapturedFields calls report for each exported field of a grammar struct, other than
+// Pos, that is not the target of any capture in the grammar.
+//
+// This helps to catch fields that are never populated because their capture tag is missing.
+func ReportUncapturedFields(report func(field UncapturedField)) Option {
+	return func(p *Parser) error {
+		p.reportFields = report
+		return nil
+	}
+}
+
+// NoLookahead makes the parser use ordered choice with full backtracking, as in a PEG, rather
+// than lookahead.
+//
+// Each disjunction tries its branches in order, restoring the input after any that fail, and the
+// first to match wins. Optionals and repetitions likewise backtrack over a failed match. Lookahead
+// tables are not built, even if UseLookahead() is also provided, so grammars that can not be
+// disambiguated by lookahead still build. The cost is that input may be parsed many times over,
+// up to exponentially in the depth of nested choices.
+func NoLookahead() Option {
+	return func(p *Parser) error {
+		p.backtrack = true
+		return nil
+	}
+}
+
+// Recover enables error recovery for grammars whose root is a single repetition, eg. a file of
+// statements captured with "{ @@ }".
+//
+// When a statement fails to parse, the error is recorded and tokens are skipped up to and
+// including the next token whose value is one of sync, which unquoted strings never match (see
+// Unquote()). Parsing then resumes with the next statement, and continues in this way to the end
+// of the input. Only statements that parsed successfully are captured, and if any failed a
+// RecoveredErrors is returned.
+func Recover(sync ...string) Option {
+	return func(p *Parser) error {
+		if len(sync) == 0 {
+			return errors.New("at least one sync token must be provided to Recover()")
+		}
+		p.recoverSync = sync
+		return nil
+	}
+}
+
+// MaxErrors stops parsing with Recover() at the first failure after n statements have failed to
+// parse, so that a badly broken input does not produce an unbounded number of errors.
+//
+// The n RecoveredErrors returned are then followed by ErrTooManyErrors, which is matched by
+// errors.Is() to detect that errors were truncated.
+func MaxErrors(n int) Option {
+	return func(p *Parser) error {
+		if n <= 0 {
+			return fmt.Errorf("MaxErrors() requires a positive limit, not %d", n)
+		}
+		p.maxErrors = n
+		return nil
+	}
+}
+
+// LongestMatch makes each disjunction speculatively parse all of its branches and select the one
+// consuming the most tokens, rather than the first that matches.
+//
+// Where several branches consume the same number of tokens the earliest is selected. This replaces
+// lookahead-based selection for disjunctions. The selected branch is parsed again once selected,
+// including the disjunctions within it, so the cost is exponential in the depth to which
+// disjunctions are nested, eg. doubling with each level of parentheses in an expression. Parsing
+// with WithMemoization() avoids reparsing each struct at the same position.
+func LongestMatch() Option {
+	return func(p *Parser) error {
+		p.longestMatch = true
+		return nil
+	}
+}
+
 // CaseInsensitive allows the specified token types to be matched case-insensitively.
 func CaseInsensitive(tokens ...string) Option {
 	return func(p *Parser) error {
@@ -32,3 +156,520 @@ func CaseInsensitive(tokens ...string) Option {
 		return nil
 	}
 }
+
+// JoinStrings separates each value captured into a string field with separator.
+//
+// By default values are concatenated directly, eg. capturing "a" and "b" results in "ab". It may
+// not be combined with StrictStrings().
+func JoinStrings(separator string) Option {
+	return func(p *Parser) error {
+		if p.join.strict {
+			return errors.New("JoinStrings() can not be combined with StrictStrings()")
+		}
+		p.join.separator = separator
+		return nil
+	}
+}
+
+// StrictCaptures makes capturing into the same scalar field more than once within a single match
+// of its struct a parse error, rather than overwriting the earlier value.
+//
+// This catches grammar mistakes such as a repetition around a capture into a non-slice field.
+// Slice and string fields, which accumulate values, and counting captures with @# are exempt.
+func StrictCaptures() Option {
+	return func(p *Parser) error {
+		p.strictCaptures = true
+		return nil
+	}
+}
+
+// ProductionStack records the stack of productions being parsed when an error occurs, returning
+// a *ParseError whose message is prefixed by it, eg.
+//
+//	<source>:1:23: while parsing Function > Body > Statement: unexpected token "}"
+//
+// Without it, errors are reported without the stack.
+func ProductionStack() Option {
+	return func(p *Parser) error {
+		p.productionStack = true
+		return nil
+	}
+}
+
+// WithNumberFormat parses values captured into numeric fields using the given decimal and
+// grouping separators, eg. WithNumberFormat(',', '.') for "1.234,56".
+//
+// Grouping separators are only permitted before the decimal separator, between groups of three
+// digits, so that a value such as "1.5" is rejected rather than silently read as 15. A grouping
+// separator of 0 disallows grouping. The lexer must produce each number, separators included, as
+// a single token.
+func WithNumberFormat(decimal, grouping rune) Option {
+	return func(p *Parser) error {
+		if decimal == 0 || decimal == grouping {
+			return fmt.Errorf("invalid number format with decimal separator %q and grouping separator %q", decimal, grouping)
+		}
+		p.numbers = &numberFormat{decimal: decimal, grouping: grouping}
+		return nil
+	}
+}
+
+// StrictStrings makes capturing more than one value into a string field a parse error.
+//
+// Use a slice field to capture multiple values. It may not be combined with JoinStrings().
+func StrictStrings() Option {
+	return func(p *Parser) error {
+		if p.join.separator != "" {
+			return errors.New("StrictStrings() can not be combined with JoinStrings()")
+		}
+		p.join.strict = true
+		return nil
+	}
+}
+
+// SignedNumbers folds a "-" or "+" token immediately preceding a number into the number, when the
+// number is captured into a signed integer or floating point field by reference, eg. "@Int".
+//
+// Elided tokens, such as whitespace, may appear between the sign and the number, so "- 5" is
+// captured as -5. A sign that is not followed by a number is not consumed, nor is an unquoted
+// string such as "-" (see Unquote()).
+func SignedNumbers() Option {
+	return func(p *Parser) error {
+		p.signedNumbers = true
+		return nil
+	}
+}
+
+// Enum restricts captures into fields of the same type as "of" to the given values.
+//
+// Capturing any other value is a parse error. eg.
+//
+// 		type Color string
+//
+// 		participle.Build(&grammar{}, participle.Enum(Color(""), "red", "green", "blue"))
+func Enum(of interface{}, values ...string) Option {
+	return func(p *Parser) error {
+		t := reflect.TypeOf(of)
+		if t == nil {
+			return fmt.Errorf("Enum() requires a value of the enumerated type")
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("Enum() for %s requires at least one value", t)
+		}
+		p.enums[t] = newEnum(t, values)
+		return nil
+	}
+}
+
+// RegisterEnum converts captures into fields of the integer type of fieldType, eg. an iota
+// enumeration, from the value of the literal matched, using values. eg.
+//
+// 		type Op int
+//
+// 		const (
+// 			Add Op = iota
+// 			Sub
+// 		)
+//
+// 		participle.Build(&grammar{}, participle.RegisterEnum(Op(0), map[string]int{"+": int(Add), "-": int(Sub)}))
+//
+// Each literal that a capture into such a field can match must have a value.
+func RegisterEnum(fieldType interface{}, values map[string]int) Option {
+	return func(p *Parser) error {
+		t := reflect.TypeOf(fieldType)
+		if t == nil || !isIntegerKind(t.Kind()) {
+			return fmt.Errorf("RegisterEnum() requires a value of an integer type, not %T", fieldType)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("RegisterEnum() for %s requires at least one value", t)
+		}
+		p.converters[t] = enumConverter(t, values)
+		p.enumValues[t] = values
+		return nil
+	}
+}
+
+// Union registers the types that may be parsed into fields of an interface type.
+//
+// iface must be a nil pointer to the interface, and each member must implement it. Members are
+// tried in order. eg.
+//
+// 		type Value interface{ value() }
+//
+// 		participle.Build(&grammar{}, participle.Union((*Value)(nil), &Number{}, &String{}))
+//
+// A capture may select a specific member with "@@:<type>", eg. `@@:Number`.
+func Union(iface interface{}, members ...interface{}) Option {
+	return func(p *Parser) error {
+		t := reflect.TypeOf(iface)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+			return fmt.Errorf("Union() requires a nil pointer to an interface, not %T", iface)
+		}
+		t = t.Elem()
+		if len(members) == 0 {
+			return fmt.Errorf("Union() for %s requires at least one member", t)
+		}
+		types := []reflect.Type{}
+		for _, member := range members {
+			mt := reflect.TypeOf(member)
+			if mt == nil || !mt.Implements(t) {
+				return fmt.Errorf("union member %s does not implement %s", mt, t)
+			}
+			types = append(types, mt)
+		}
+		p.unions[t] = types
+		return nil
+	}
+}
+
+// TaggedUnion registers the types that may be parsed into fields of an interface type, as with
+// Union(), but selects the member by the value of a leading discriminator token, which an
+// unquoted string never is (see Unquote()), eg.
+//
+// 		participle.TaggedUnion((*Shape)(nil), map[string]interface{}{"circle": &Circle{}, "square": &Square{}})
+//
+// parses "circle" followed by the grammar of Circle into a *Circle. The discriminator is consumed
+// before the member is parsed, and selects the member directly rather than by trying each in turn,
+// so a member that does not match after its discriminator is an error.
+func TaggedUnion(iface interface{}, members map[string]interface{}) Option {
+	return func(p *Parser) error {
+		t := reflect.TypeOf(iface)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+			return fmt.Errorf("TaggedUnion() requires a nil pointer to an interface, not %T", iface)
+		}
+		t = t.Elem()
+		if len(members) == 0 {
+			return fmt.Errorf("TaggedUnion() for %s requires at least one member", t)
+		}
+		tags := make([]string, 0, len(members))
+		for tag := range members {
+			if tag == "" {
+				return fmt.Errorf("TaggedUnion() for %s requires non-empty discriminators", t)
+			}
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		types := []reflect.Type{}
+		for _, tag := range tags {
+			mt := reflect.TypeOf(members[tag])
+			if mt == nil || !mt.Implements(t) {
+				return fmt.Errorf("union member %s does not implement %s", mt, t)
+			}
+			types = append(types, mt)
+		}
+		p.unions[t] = types
+		p.unionTags[t] = tags
+		return nil
+	}
+}
+
+// Annotate attaches arbitrary metadata to a grammar struct, named by its type, or to a field of
+// one, named "<struct>.<field>", for use by tooling.
+//
+// The metadata is exposed in the Grammar() graph and, during parsing, to WithAnnotationHook().
+func Annotate(name string, metadata interface{}) Option {
+	return func(p *Parser) error {
+		if p.annotations == nil {
+			p.annotations = map[string]interface{}{}
+		}
+		p.annotations[name] = metadata
+		return nil
+	}
+}
+
+// Deprecate marks a grammar struct, named by its type, or a captured field of one, named
+// "<struct>.<field>", as deprecated.
+//
+// Each match of it during a parse is recorded as a Warning with message, which is collected by
+// WithWarnings(). Deprecated input still parses successfully.
+func Deprecate(name, message string) Option {
+	return func(p *Parser) error {
+		if p.deprecations == nil {
+			p.deprecations = map[string]string{}
+		}
+		p.deprecations[name] = message
+		return nil
+	}
+}
+
+// OnRepeat calls callback with each element appended to the slice field named "<struct>.<field>"
+// as it is parsed, eg. for progress reporting or incremental processing of a repetition.
+//
+// The element is passed as stored in the slice, so a pointer for a field of type []*T. Elements
+// that are later discarded by backtracking may still be passed to callback.
+func OnRepeat(field string, callback func(element interface{})) Option {
+	return func(p *Parser) error {
+		if p.repeatHooks == nil {
+			p.repeatHooks = map[string]func(interface{}){}
+		}
+		p.repeatHooks[field] = callback
+		return nil
+	}
+}
+
+// Comparator compares the elements of the slice field named "<struct>.<field>", which is tagged
+// with the "set" or "sorted" opts, returning a negative number if a is ordered before b, a
+// positive number if after, and zero if they are equal, eg. for struct elements without a single
+// key field:
+//
+//	participle.Comparator("Table.Columns", func(a, b interface{}) int {
+//		return strings.Compare(a.(*Column).QualifiedName(), b.(*Column).QualifiedName())
+//	})
+//
+// As with OnRepeat(), the elements are passed as stored in the slice.
+func Comparator(field string, compare func(a, b interface{}) int) Option {
+	return func(p *Parser) error {
+		if p.comparators == nil {
+			p.comparators = map[string]func(a, b interface{}) int{}
+		}
+		p.comparators[field] = compare
+		return nil
+	}
+}
+
+// Canonicalize replaces each value captured into the field named "<struct>.<field>" that is a key
+// of forms with the corresponding canonical form, eg. to accept alternative spellings:
+//
+//	Property string `@("color" | "colour")`
+//
+//	participle.Canonicalize("Style.Property", map[string]string{"colour": "color"})
+//
+// Values are replaced before they are assigned to the field, so this applies to each value of
+// string fields and slices of strings.
+func Canonicalize(field string, forms map[string]string) Option {
+	return func(p *Parser) error {
+		if p.canonicalForms == nil {
+			p.canonicalForms = map[string]map[string]string{}
+		}
+		p.canonicalForms[field] = forms
+		return nil
+	}
+}
+
+// ErrorMessage replaces the error reported when a literal or token type within the grammar struct
+// named rule is expected but not matched.
+//
+// expected is the value of a literal, eg. ";", or the name of a token type, eg. "Ident". Each
+// occurrence of it directly within the struct, but not within structs it references, reports
+// message at the position of the unexpected token.
+//
+// eg.
+//
+//     participle.ErrorMessage("Statement", ";", "missing semicolon at end of statement")
+func ErrorMessage(rule, expected, message string) Option {
+	return func(p *Parser) error {
+		p.errorMessages = append(p.errorMessages, errorMessage{rule: rule, expected: expected, message: message})
+		return nil
+	}
+}
+
+// Operator is a binary operator declared with Precedence().
+type Operator struct {
+	// Op is the value of the operator token.
+	Op string
+	// Precedence of the operator. Operators with a higher precedence bind more tightly.
+	Precedence int
+	// Right is true if the operator is right associative.
+	Right bool
+}
+
+// Precedence parses the grammar struct expr as binary expressions of the given operators, using
+// precedence climbing.
+//
+// The grammar of expr itself is the primary operand, and expr must also have the untagged fields
+// "Left" and "Right" of type *expr and "Op" of type string. Each binary expression is a new expr
+// with only these fields set, eg. given:
+//
+// 		type Expr struct {
+// 			Left  *Expr
+// 			Op    string
+// 			Right *Expr
+//
+// 			Number *int  `  @Int`
+// 			Sub    *Expr `| "(" @@ ")"`
+// 		}
+//
+// 		participle.Precedence(&Expr{}, participle.Operator{Op: "+", Precedence: 1}, participle.Operator{Op: "*", Precedence: 2})
+//
+// "1 + 2 * 3" is parsed as &Expr{Left: 1, Op: "+", Right: &Expr{Left: 2, Op: "*", Right: 3}}.
+// Operators match tokens by value, but not unquoted strings (see Unquote()).
+func Precedence(expr interface{}, operators ...Operator) Option {
+	return func(p *Parser) error {
+		t := reflect.TypeOf(expr)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("Precedence() requires a pointer to a struct, not %T", expr)
+		}
+		t = t.Elem()
+		fields := []struct {
+			name string
+			typ  reflect.Type
+		}{{"Left", reflect.PtrTo(t)}, {"Op", reflect.TypeOf("")}, {"Right", reflect.PtrTo(t)}}
+		for _, field := range fields {
+			if f, ok := t.FieldByName(field.name); !ok || f.Type != field.typ {
+				return fmt.Errorf("Precedence() requires %s to have a field %s of type %s", t, field.name, field.typ)
+			}
+		}
+		if len(operators) == 0 {
+			return fmt.Errorf("Precedence() for %s requires at least one operator", t)
+		}
+		seen := map[string]bool{}
+		for _, op := range operators {
+			if seen[op.Op] {
+				return fmt.Errorf("Precedence() for %s has duplicate operator %q", t, op.Op)
+			}
+			seen[op.Op] = true
+		}
+		p.precedence[t] = operators
+		return nil
+	}
+}
+
+// WithUnescaper registers a function to decode the value of captured tokens of the given type.
+//
+// This allows escapes beyond those supported by Unquote(), eg. "\u{1F600}", to be decoded. If fn
+// returns an *UnescapeError, the error is reported at the bad escape's offset within the token.
+func WithUnescaper(tokenType string, fn func(string) (string, error)) Option {
+	return func(p *Parser) error {
+		if p.unescapers == nil {
+			p.unescapers = map[string]func(string) (string, error){}
+		}
+		p.unescapers[tokenType] = fn
+		return nil
+	}
+}
+
+// A ParseOption modifies how an individual parse is applied.
+type ParseOption func(p *parseContext)
+
+// WithAnnotationHook calls hook each time a struct or field annotated with Annotate() is matched
+// during a single parse.
+//
+// Structs are reported once they have been fully parsed, so nested structs are reported before
+// those enclosing them. A match that is later discarded by backtracking may still be reported.
+func WithAnnotationHook(hook func(annotation Annotation)) ParseOption {
+	return func(p *parseContext) {
+		p.annotationHook = hook
+	}
+}
+
+// WithBasePosition reports positions relative to base, for input that is a fragment of a larger
+// file beginning at base.
+//
+// The positions of tokens, and thus of Pos fields and errors, are adjusted as described by
+// lexer.Rebase().
+func WithBasePosition(base lexer.Position) ParseOption {
+	return func(p *parseContext) {
+		p.base = &base
+	}
+}
+
+// OnStruct calls hook with a pointer to each struct once it has been fully parsed, along with the
+// position of its first token, during a single parse.
+//
+// If hook returns an error the parse is aborted and the error is returned. A *lexer.Error is
+// returned as a positioned parse error, while any other error is wrapped in a HookError at the
+// position of the struct. Matches later discarded by backtracking may still be passed to hook.
+func OnStruct(hook func(v interface{}, pos lexer.Position) error) ParseOption {
+	return func(p *parseContext) {
+		p.structHook = hook
+	}
+}
+
+// WithLookaheadStats records in stats the number of tokens peeked at by each lookahead selection
+// made during a single parse.
+//
+// It only applies when UseLookahead() is also provided. The same stats may be passed to several
+// parses to accumulate their statistics, but not concurrently.
+func WithLookaheadStats(stats *LookaheadStats) ParseOption {
+	return func(p *parseContext) {
+		p.lookaheadStats = stats
+	}
+}
+
+// WithWarnings appends to warnings a Warning for each match of a production marked with
+// Deprecate() during a single parse.
+//
+// Structs are reported once they have been fully parsed, so nested structs are reported before
+// those enclosing them. Matches discarded by backtracking are not reported.
+func WithWarnings(warnings *[]Warning) ParseOption {
+	return func(p *parseContext) {
+		p.warnings = warnings
+	}
+}
+
+// Merge parses into the existing value of the target rather than a zero value, so that values
+// already present, eg. defaults, are preserved where the grammar does not capture into them.
+//
+// Each field of the target that is captured into is overwritten as if it had been zero, including
+// slices, which are replaced rather than appended to, and structs, which are parsed anew.
+func Merge() ParseOption {
+	return func(p *parseContext) {
+		p.merge = true
+	}
+}
+
+// CountElided sets the field named counter of the same struct to the number of elided tokens
+// immediately preceding each value captured into the field named "<struct>.<field>", eg. to
+// enforce blank lines between declarations:
+//
+//	Name       string `"func" @Ident`
+//	BlankLines int
+//
+//	participle.CountElided("Func.Name", "BlankLines", "Newline")
+//
+// Only elided tokens with one of the given types are counted, or all elided tokens if none are
+// given. counter must be an integer field, which is set on each capture, or a slice of integers,
+// to which the count is appended on each capture.
+func CountElided(field, counter string, types ...string) Option {
+	return func(p *Parser) error {
+		if p.elidedCounts == nil {
+			p.elidedCounts = map[string]elidedCount{}
+		}
+		p.elidedCounts[field] = elidedCount{counter: counter, types: types}
+		return nil
+	}
+}
+
+// WithFlags enables the alternatives of disjunctions marked with any of flags, eg.
+// `@@ | ?experimental @@`, for a single parse.
+//
+// Alternatives marked with a flag that is not enabled are never matched, as if they were absent
+// from the grammar, although lookahead is computed for all alternatives.
+func WithFlags(flags ...string) ParseOption {
+	return func(p *parseContext) {
+		if p.flags == nil {
+			p.flags = map[string]bool{}
+		}
+		for _, flag := range flags {
+			p.flags[flag] = true
+		}
+	}
+}
+
+// WithVersion parses input of the given version, skipping the grammar of fields tagged with a
+// later version, eg. given:
+//
+//	type Server struct {
+//		Host    string `parser:"\"server\" @Ident"`
+//		Timeout int    `parser:"[ \"timeout\" @Int ]" since:"2"`
+//	}
+//
+// WithVersion(1) parses "server a" but not "server a timeout 30", leaving Timeout unset. The
+// grammar of such a field matches nothing, so that a later version's syntax is rejected rather
+// than captured. Without WithVersion(), every field is parsed.
+func WithVersion(version int) ParseOption {
+	return func(p *parseContext) {
+		p.version = &version
+	}
+}
+
+// WithKeywords provides the keyword set matched by $<name> in the grammar for a single parse.
+//
+// This allows the keywords of a language to be extended at runtime.
+func WithKeywords(name string, keywords map[string]bool) ParseOption {
+	return func(p *parseContext) {
+		if p.keywords == nil {
+			p.keywords = map[string]map[string]bool{}
+		}
+		p.keywords[name] = keywords
+	}
+}
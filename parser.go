@@ -7,6 +7,7 @@ import (
 	"io"
 	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/alecthomas/participle/lexer"
 )
@@ -17,8 +18,50 @@ type Parser struct {
 	lex             lexer.Definition
 	typ             reflect.Type
 	useLookahead    bool
+	maxLookahead    int
+	reportLookahead func(LookaheadTableSize)
+	reportFields    func(UncapturedField)
+	annotations     map[string]interface{}
+	deprecations    map[string]string
+	repeatHooks     map[string]func(interface{})
+	comparators     map[string]func(a, b interface{}) int
+	canonicalForms  map[string]map[string]string
+	elidedCounts    map[string]elidedCount
+	channels        [][]int
+	leadingTrivia   bool
+	errorMessages   []errorMessage
 	caseInsensitive map[string]bool
 	mappers         []mapperByToken
+	enums           map[reflect.Type]*enum
+	converters      map[reflect.Type]Converter
+	enumValues      map[reflect.Type]map[string]int
+	unions          map[reflect.Type][]reflect.Type
+	unionTags       map[reflect.Type][]string
+	precedence      map[reflect.Type][]Operator
+	join            stringJoin
+	numbers         *numberFormat
+	strictCaptures  bool
+	productionStack bool
+	signedNumbers   bool
+	maxTokens       int
+	longestMatch    bool
+	backtrack       bool
+	recoverSync     []string
+	maxErrors       int
+	recoverRoot     *repetition
+	requirements    []requirement
+	docComments     []string
+	docTypes        map[rune]bool
+	unescapers      map[string]func(string) (string, error)
+	unescapeTypes   map[rune]func(string) (string, error)
+	quoted          []string
+	quotedTypes     map[rune]bool
+	lazyLexer       func() (lexer.Definition, error)
+	resolveOnce     sync.Once
+	resolveErr      error
+	generator       *generatorContext
+	// BufferedLexers released by completed parses.
+	buffers sync.Pool
 }
 
 // MustBuild calls Build(grammar, options...) and panics if an error occurs.
@@ -41,6 +84,12 @@ func Build(grammar interface{}, options ...Option) (parser *Parser, err error) {
 	p := &Parser{
 		lex:             lexer.TextScannerLexer,
 		caseInsensitive: map[string]bool{},
+		enums:           map[reflect.Type]*enum{},
+		converters:      map[reflect.Type]Converter{},
+		enumValues:      map[reflect.Type]map[string]int{},
+		unions:          map[reflect.Type][]reflect.Type{},
+		unionTags:       map[reflect.Type][]string{},
+		precedence:      map[reflect.Type][]Operator{},
 	}
 	for _, option := range options {
 		if option == nil {
@@ -51,54 +100,550 @@ func Build(grammar interface{}, options ...Option) (parser *Parser, err error) {
 			return nil, err
 		}
 	}
+	p.typ = reflect.TypeOf(grammar)
+	if p.lazyLexer != nil {
+		return p, nil
+	}
+	if err = p.resolve(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
 
+// Resolve the lexer definition, if deferred, and build the grammar against it.
+//
+// This occurs once, during Build() or on first use if the lexer is provided by LazyLexer().
+func (p *Parser) resolve() error {
+	p.resolveOnce.Do(func() {
+		if p.lazyLexer != nil {
+			def, err := p.lazyLexer()
+			if err != nil {
+				p.resolveErr = err
+				return
+			}
+			if def == nil {
+				p.resolveErr = fmt.Errorf("lazy lexer for %s returned a nil lexer.Definition", p.typ)
+				return
+			}
+			p.lex = def
+		}
+		p.resolveErr = p.build()
+	})
+	return p.resolveErr
+}
+
+func (p *Parser) build() (err error) {
+	// Strings unquoted by the lexer itself, such as the default lexer.
+	if unquoted, ok := p.lex.(interface{ Unquoted() []string }); ok {
+		p.quoted = append(p.quoted, unquoted.Unquoted()...)
+	}
 	if len(p.mappers) > 0 {
 		mappers := map[rune][]Mapper{}
+		stateful := []func(map[string]rune) Mapper{}
 		symbols := p.lex.Symbols()
 		for _, mapper := range p.mappers {
-			if len(mapper.symbols) == 0 {
+			if mapper.stateful != nil {
+				for _, symbol := range mapper.symbols {
+					if _, ok := symbols[symbol]; !ok {
+						return fmt.Errorf("mapper %#v uses unknown token %q", mapper, symbol)
+					}
+				}
+				stateful = append(stateful, mapper.stateful)
+			} else if len(mapper.symbols) == 0 {
 				mappers[lexer.EOF] = append(mappers[lexer.EOF], mapper.mapper)
 			} else {
 				for _, symbol := range mapper.symbols {
 					if rn, ok := symbols[symbol]; !ok {
-						return nil, fmt.Errorf("mapper %#v uses unknown token %q", mapper, symbol)
+						return fmt.Errorf("mapper %#v uses unknown token %q", mapper, symbol)
 					} else { // nolint: golint
 						mappers[rn] = append(mappers[rn], mapper.mapper)
 					}
 				}
 			}
 		}
-		p.lex = &mappingLexerDef{p.lex, func(t lexer.Token) (lexer.Token, error) {
-			combined := make([]Mapper, 0, len(mappers[t.Type])+len(mappers[lexer.EOF]))
-			combined = append(combined, mappers[lexer.EOF]...)
-			combined = append(combined, mappers[t.Type]...)
+		p.lex = &mappingLexerDef{p.lex, func() Mapper {
+			// Stateful mappers are applied after all others.
+			instances := make([]Mapper, 0, len(stateful))
+			for _, create := range stateful {
+				instances = append(instances, create(symbols))
+			}
+			return func(t lexer.Token) (lexer.Token, error) {
+				combined := make([]Mapper, 0, len(mappers[t.Type])+len(mappers[lexer.EOF])+len(instances))
+				combined = append(combined, mappers[lexer.EOF]...)
+				combined = append(combined, mappers[t.Type]...)
+				combined = append(combined, instances...)
 
-			var err error
-			for _, m := range combined {
-				t, err = m(t)
-				if err != nil {
-					return t, err
+				var err error
+				for _, m := range combined {
+					t, err = m(t)
+					if err != nil {
+						return t, err
+					}
 				}
+				return t, nil
 			}
-			return t, nil
 		}}
 	}
 
+	if len(p.unescapers) > 0 {
+		symbols := p.lex.Symbols()
+		p.unescapeTypes = map[rune]func(string) (string, error){}
+		for symbol, fn := range p.unescapers {
+			rn, ok := symbols[symbol]
+			if !ok {
+				return fmt.Errorf("unescaper uses unknown token %q", symbol)
+			}
+			p.unescapeTypes[rn] = fn
+		}
+	}
+
+	if len(p.quoted) > 0 {
+		symbols := p.lex.Symbols()
+		p.quotedTypes = map[rune]bool{}
+		for _, symbol := range p.quoted {
+			p.quotedTypes[symbols[symbol]] = true
+		}
+	}
+
+	if len(p.docComments) > 0 {
+		symbols := p.lex.Symbols()
+		p.docTypes = map[rune]bool{}
+		for _, symbol := range p.docComments {
+			p.docTypes[symbols[symbol]] = true
+		}
+	}
+
 	context := newGeneratorContext(p.lex)
-	p.typ = reflect.TypeOf(grammar)
+	context.enums = p.enums
+	context.converters = p.converters
+	context.unions = p.unions
+	context.unionTags = p.unionTags
+	context.precedence = p.precedence
+	context.join = p.join
+	context.numbers = p.numbers
+	context.signedNumbers = p.signedNumbers
 	p.root, err = context.parseType(p.typ)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	p.generator = context
+	if err := p.finalise(); err != nil {
+		return err
+	}
+	if p.reportFields != nil {
+		p.reportUncapturedFields()
 	}
 	// TODO: Fix lookahead - see SQL example.
-	if p.useLookahead {
-		return p, applyLookahead(p.root, map[node]bool{})
+	if p.useLookahead && !p.backtrack {
+		b := &lookaheadBuilder{seen: map[node]bool{}, max: p.maxLookahead, report: p.reportLookahead, root: p.root}
+		return b.apply(p.root)
+	}
+	return nil
+}
+
+// Bind the options referring to nodes of the grammar to them and check the grammar, once it has
+// been built or extended.
+func (p *Parser) finalise() error {
+	bindExclusive(p.root)
+	if err := checkRepetitions(p.root); err != nil {
+		return err
+	}
+	if p.recoverSync != nil {
+		if p.recoverRoot = rootRepetition(p.root); p.recoverRoot == nil {
+			return fmt.Errorf("Recover() requires the grammar %s to be a single repetition, eg. \"{ @@ }\"", p.typ)
+		}
+	} else if p.maxErrors != 0 {
+		return fmt.Errorf("MaxErrors() requires Recover()")
+	}
+	if err := p.checkEnumValues(); err != nil {
+		return err
+	}
+	if err := p.bindAnnotations(); err != nil {
+		return err
+	}
+	if err := p.bindErrorMessages(); err != nil {
+		return err
+	}
+	if err := p.bindDeprecations(); err != nil {
+		return err
+	}
+	if err := p.bindRepeatHooks(); err != nil {
+		return err
+	}
+	if err := p.bindComparators(); err != nil {
+		return err
+	}
+	if err := p.bindCanonicalForms(); err != nil {
+		return err
+	}
+	if err := p.bindElidedCounts(); err != nil {
+		return err
+	}
+	if err := p.findChannels(); err != nil {
+		return err
+	}
+	p.leadingTrivia = hasLeadingTrivia(p.root)
+	return nil
+}
+
+// Returns the repetition forming the whole of the grammar struct, if any.
+func rootRepetition(root node) *repetition {
+	s, ok := root.(*strct)
+	if !ok {
+		return nil
+	}
+	r, ok := s.expr.(*repetition)
+	if !ok || r.next != nil {
+		return nil
+	}
+	return r
+}
+
+// Annotation is metadata attached to a grammar struct or field with Annotate().
+type Annotation struct {
+	// Rule is the name of the grammar struct.
+	Rule string
+	// Field is the name of the annotated field, or empty if the struct itself is annotated.
+	Field string
+	// Pos is the position at which the struct or field was matched, when passed to WithAnnotationHook().
+	Pos      lexer.Position
+	Metadata interface{}
+}
+
+// Attach the metadata registered with Annotate() to the structs and captures it names.
+func (p *Parser) bindAnnotations() error {
+	if len(p.annotations) == 0 {
+		return nil
+	}
+	bound := map[string]bool{}
+	_ = visitWithin(p.root, func(owner *strct, n node) error {
+		rule := ruleName(owner.typ)
+		switch n := n.(type) {
+		case *strct:
+			if metadata, ok := p.annotations[rule]; ok {
+				n.annotation = &Annotation{Rule: rule, Metadata: metadata}
+				bound[rule] = true
+			}
+		case *capture:
+			name := rule + "." + n.field.Name
+			if metadata, ok := p.annotations[name]; ok {
+				n.annotation = &Annotation{Rule: rule, Field: n.field.Name, Metadata: metadata}
+				bound[name] = true
+			}
+		}
+		return nil
+	})
+	for name := range p.annotations {
+		if !bound[name] {
+			return fmt.Errorf("annotation for unknown struct or captured field %q", name)
+		}
+	}
+	return nil
+}
+
+// Warning is recorded for each match of a production marked with Deprecate(), when collected by
+// WithWarnings().
+type Warning struct {
+	Pos     lexer.Position
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Pos, w.Message)
+}
+
+// Attach the messages registered with Deprecate() to the structs and captures they name.
+func (p *Parser) bindDeprecations() error {
+	if len(p.deprecations) == 0 {
+		return nil
+	}
+	bound := map[string]bool{}
+	_ = visitWithin(p.root, func(owner *strct, n node) error {
+		name := ruleName(owner.typ)
+		switch n := n.(type) {
+		case *strct:
+			if message, ok := p.deprecations[name]; ok {
+				n.deprecated = message
+				bound[name] = true
+			}
+		case *capture:
+			name += "." + n.field.Name
+			if message, ok := p.deprecations[name]; ok {
+				n.deprecated = message
+				bound[name] = true
+			}
+		}
+		return nil
+	})
+	for name := range p.deprecations {
+		if !bound[name] {
+			return fmt.Errorf("deprecation of unknown struct or captured field %q", name)
+		}
+	}
+	return nil
+}
+
+// Attach the callbacks registered with OnRepeat() to the captures into the slice fields they name.
+func (p *Parser) bindRepeatHooks() error {
+	if len(p.repeatHooks) == 0 {
+		return nil
+	}
+	bound := map[string]bool{}
+	err := visitCaptures(p.root, func(rule string, c *capture) error {
+		name := rule + "." + c.field.Name
+		if callback, ok := p.repeatHooks[name]; ok {
+			if c.field.Type.Kind() != reflect.Slice || c.field.setter != "" {
+				return fmt.Errorf("OnRepeat() for %q requires a slice field", name)
+			}
+			c.onRepeat = callback
+			bound[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range p.repeatHooks {
+		if !bound[name] {
+			return fmt.Errorf("OnRepeat() for unknown captured field %q", name)
+		}
+	}
+	return nil
+}
+
+// Attach the functions registered with Comparator() to the captures into the fields they name,
+// and check that the elements of every set or sorted field can be compared.
+func (p *Parser) bindComparators() error {
+	bound := map[string]bool{}
+	err := visitCaptures(p.root, func(rule string, c *capture) error {
+		name := rule + "." + c.field.Name
+		if compare, ok := p.comparators[name]; ok {
+			if c.collection == nil {
+				return fmt.Errorf("Comparator() for %q requires a field tagged with opts \"set\" or \"sorted\"", name)
+			}
+			c.collection.compare = compare
+			bound[name] = true
+		}
+		if c.collection != nil {
+			if err := c.collection.check(c.field); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range p.comparators {
+		if !bound[name] {
+			return fmt.Errorf("Comparator() for unknown captured field %q", name)
+		}
+	}
+	return nil
+}
+
+// Attach the canonical forms registered with Canonicalize() to the captures into the fields they name.
+func (p *Parser) bindCanonicalForms() error {
+	if len(p.canonicalForms) == 0 {
+		return nil
+	}
+	bound := map[string]bool{}
+	err := visitCaptures(p.root, func(rule string, c *capture) error {
+		name := rule + "." + c.field.Name
+		if forms, ok := p.canonicalForms[name]; ok {
+			if indirectType(c.field.Type).Kind() != reflect.String || c.count {
+				return fmt.Errorf("Canonicalize() for %q requires a string field", name)
+			}
+			c.canonical = forms
+			bound[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range p.canonicalForms {
+		if !bound[name] {
+			return fmt.Errorf("Canonicalize() for unknown captured field %q", name)
+		}
+	}
+	return nil
+}
+
+// A counter of elided tokens registered with CountElided().
+type elidedCount struct {
+	counter string
+	types   []string
+}
+
+// Attach the counters registered with CountElided() to the captures into the fields they name.
+func (p *Parser) bindElidedCounts() error {
+	if len(p.elidedCounts) == 0 {
+		return nil
+	}
+	symbols := p.lex.Symbols()
+	bound := map[string]bool{}
+	err := visitWithin(p.root, func(owner *strct, n node) error {
+		c, ok := n.(*capture)
+		if !ok {
+			return nil
+		}
+		name := ruleName(owner.typ) + "." + c.field.Name
+		count, ok := p.elidedCounts[name]
+		if !ok {
+			return nil
+		}
+		counter, ok := owner.typ.FieldByName(count.counter)
+		if !ok || !isElidedCounter(counter.Type) {
+			return fmt.Errorf("CountElided() for %q requires an integer or integer slice field %q", name, count.counter)
+		}
+		c.elided = &elidedCounter{typ: owner.typ, index: counter.Index}
+		for _, symbol := range count.types {
+			rn, ok := symbols[symbol]
+			if !ok {
+				return fmt.Errorf("CountElided() for %q: lexer does not support symbol %q", name, symbol)
+			}
+			if c.elided.types == nil {
+				c.elided.types = map[rune]bool{}
+			}
+			c.elided.types[rn] = true
+		}
+		bound[name] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range p.elidedCounts {
+		if !bound[name] {
+			return fmt.Errorf("CountElided() for unknown captured field %q", name)
+		}
+	}
+	return nil
+}
+
+func isElidedCounter(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// A custom error message registered with ErrorMessage().
+type errorMessage struct {
+	rule     string
+	expected string
+	message  string
+}
+
+// Attach the messages registered with ErrorMessage() to the literals and references they name.
+func (p *Parser) bindErrorMessages() error {
+	if len(p.errorMessages) == 0 {
+		return nil
+	}
+	bound := make([]bool, len(p.errorMessages))
+	_ = visitWithin(p.root, func(owner *strct, n node) error {
+		rule := ruleName(owner.typ)
+		for i, m := range p.errorMessages {
+			if m.rule != rule {
+				continue
+			}
+			switch n := n.(type) {
+			case *literal:
+				if m.expected == n.s {
+					n.message = m.message
+					bound[i] = true
+				}
+			case *reference:
+				if m.expected == n.identifier {
+					n.message = m.message
+					bound[i] = true
+				}
+			}
+		}
+		return nil
+	})
+	for i, m := range p.errorMessages {
+		if !bound[i] {
+			return fmt.Errorf("error message for unknown literal or token type %q in %q", m.expected, m.rule)
+		}
+	}
+	return nil
+}
+
+// UncapturedField describes an exported field of a grammar struct that no capture assigns to.
+type UncapturedField struct {
+	// Production is the name of the grammar struct.
+	Production string
+	// Field is the name of the field.
+	Field string
+}
+
+// Report each exported field of each grammar struct that is not the target of a capture.
+func (p *Parser) reportUncapturedFields() {
+	captured := map[*strct]map[string]bool{}
+	order := []*strct{}
+	stack := []*strct{}
+	assign := func(name string) {
+		if len(stack) > 0 {
+			captured[stack[len(stack)-1]][name] = true
+		}
+	}
+	_ = visit(p.root, func(n node, next func() error) error {
+		switch n := n.(type) {
+		case *strct:
+			captured[n] = map[string]bool{}
+			order = append(order, n)
+			stack = append(stack, n)
+			err := next()
+			stack = stack[:len(stack)-1]
+			return err
+		case *capture:
+			assign(n.field.Name)
+			for _, also := range n.also {
+				assign(also.Name)
+			}
+			if n.groups != nil {
+				for _, field := range n.groups.fields {
+					if field != nil {
+						assign(field.Name)
+					}
+				}
+			}
+		case *optional:
+			if n.present != nil {
+				assign(n.present.Name)
+			}
+		case *precedence:
+			err := next()
+			for _, name := range []string{"Left", "Op", "Right"} {
+				captured[n.expr][name] = true
+			}
+			return err
+		}
+		return next()
+	})
+	for _, s := range order {
+		for _, field := range reflect.VisibleFields(s.typ) {
+			if field.Anonymous || field.PkgPath != "" || captured[s][field.Name] || field.Name == "Pos" ||
+				(field.Name == "Index" && field.Type.Kind() == reflect.Int) ||
+				((field.Name == "Doc" || field.Name == "TrailingComment") && p.docTypes != nil) {
+				continue
+			}
+			p.reportFields(UncapturedField{Production: ruleName(s.typ), Field: field.Name})
+		}
 	}
-	return p, nil
 }
 
 // Lex uses the parser's lexer to tokenise input.
 func (p *Parser) Lex(r io.Reader) ([]lexer.Token, error) {
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
 	lex, err := p.lex.Lex(r)
 	if err != nil {
 		return nil, err
@@ -108,50 +653,194 @@ func (p *Parser) Lex(r io.Reader) ([]lexer.Token, error) {
 
 // Parse from r into grammar v which must be of the same type as the grammar passed to
 // participle.Build().
-func (p *Parser) Parse(r io.Reader, v interface{}) (err error) {
+func (p *Parser) Parse(r io.Reader, v interface{}, options ...ParseOption) (err error) {
+	lex, err := p.parse(r, v, options, false)
+	p.release(lex)
+	return err
+}
+
+// ParsePartial parses as much of r as the grammar matches into v, which must be of the same type
+// as the grammar passed to participle.Build().
+//
+// Unlike Parse(), it is not an error for tokens to remain once the grammar has matched. The
+// returned lexer is positioned at the first unconsumed token, allowing the remainder to be
+// retrieved with Drain() or parsed further.
+func (p *Parser) ParsePartial(r io.Reader, v interface{}, options ...ParseOption) (*lexer.BufferedLexer, error) {
+	return p.parse(r, v, options, true)
+}
+
+func (p *Parser) parse(r io.Reader, v interface{}, options []ParseOption, partial bool) (lex *lexer.BufferedLexer, err error) {
 	if reflect.TypeOf(v) != p.typ {
-		return fmt.Errorf("must parse into value of type %s not %T", p.typ, v)
+		return nil, fmt.Errorf("must parse into value of type %s not %T", p.typ, v)
+	}
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	r, options, err = p.bufferSource(r, options)
+	if err != nil {
+		return nil, err
 	}
 	baseLexer, err := p.lex.Lex(r)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return p.parseLexer(baseLexer, v, options, partial)
+}
+
+// Buffer the input if the grammar captures source text, returning the reader to lex from.
+func (p *Parser) bufferSource(r io.Reader, options []ParseOption) (io.Reader, []ParseOption, error) {
+	if !p.generator.rawCaptures {
+		return r, options, nil
+	}
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
 	}
-	lex := lexer.Upgrade(baseLexer)
+	options = append(options[:len(options):len(options)], func(ctx *parseContext) { ctx.source = source })
+	return bytes.NewReader(source), options, nil
+}
+
+// Buffer a lexer for parsing, reusing a previously released BufferedLexer if available.
+func (p *Parser) buffer(baseLexer lexer.Lexer) *lexer.BufferedLexer {
+	if lex, ok := p.buffers.Get().(*lexer.BufferedLexer); ok {
+		lex.Reset(baseLexer)
+		return lex
+	}
+	return lexer.Buffer(baseLexer)
+}
+
+// Release a BufferedLexer that is no longer referenced for reuse by a later parse.
+func (p *Parser) release(lex *lexer.BufferedLexer) {
+	if lex != nil {
+		lex.Reset(nil)
+		p.buffers.Put(lex)
+	}
+}
+
+func (p *Parser) parseLexer(baseLexer lexer.Lexer, v interface{}, options []ParseOption, partial bool) (lex *lexer.BufferedLexer, err error) {
+	lex = p.buffer(baseLexer)
 	caseInsensitive := map[rune]bool{}
 	for sym, rn := range p.lex.Symbols() {
 		if p.caseInsensitive[sym] {
 			caseInsensitive[rn] = true
 		}
 	}
-	ctx := parseContext{PeekingLexer: lex, caseInsensitive: caseInsensitive}
+	ctx := parseContext{BufferedLexer: lex, caseInsensitive: caseInsensitive, maxTokens: p.maxTokens, longestMatch: p.longestMatch,
+		backtrack: p.backtrack, unescapers: p.unescapeTypes, tabWidth: lexer.TabWidthOf(p.lex), quoted: p.quotedTypes, strictCaptures: p.strictCaptures,
+		productionStack: p.productionStack, joinStrings: p.join != stringJoin{}, channels: p.channels != nil}
+	ctx.stopped = &stoppedRepetition{}
+	ctx.sets = map[uintptr]map[interface{}]int{}
+	if p.recoverRoot != nil {
+		ctx.recovery = &recovery{root: p.recoverRoot, sync: p.recoverSync, max: p.maxErrors}
+	}
+	for _, option := range options {
+		option(&ctx)
+	}
+	if ctx.base != nil {
+		// Rebase beneath any mapping lexer so that elided tokens are also rebased.
+		if mapper, ok := baseLexer.(*mappingLexer); ok {
+			mapper.Lexer = lexer.Rebase(mapper.Lexer, *ctx.base)
+		} else {
+			baseLexer = lexer.Rebase(baseLexer, *ctx.base)
+			lex.Reset(baseLexer)
+		}
+	}
+	if ctx.trace != nil {
+		ctx.trace.symbols = p.generator.symbolsToIDs
+	}
+	if ctx.events != nil {
+		ctx.events.buffered = ctx.backtrack || ctx.recovery != nil
+		defer ctx.events.flush()
+	}
+	cst := ctx.cst
+	if mapper, ok := baseLexer.(*mappingLexer); ok && (cst != nil || p.docTypes != nil || p.leadingTrivia || p.elidedCounts != nil || p.generator.rawCaptures) {
+		mapper.elided = map[int][]lexer.Token{}
+		ctx.elided = mapper.elided
+		lex.OnDiscard(func(offset int) {
+			for cursor := range mapper.elided {
+				if cursor < offset {
+					delete(mapper.elided, cursor)
+				}
+			}
+		})
+	}
+	if p.leadingTrivia {
+		ctx.triviaClaimed = map[int]bool{}
+	}
+	if p.docTypes != nil {
+		ctx.docTypes = p.docTypes
+		ctx.docClaimed = map[int]bool{}
+		ctx.trailingClaimed = map[int]bool{}
+	}
+	if cst != nil {
+		ctx.cst = &CSTNode{Rule: ruleName(p.typ)}
+		defer func() { ctx.finishCST(cst) }()
+	}
 	// If the grammar implements Parseable, use it.
 	if parseable, ok := v.(Parseable); ok {
-		return p.rootParseable(lex, parseable)
+		return lex, p.rootParseable(ctx, parseable, partial)
 	}
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
-		return errors.New("target must be a pointer to a struct")
+		return lex, errors.New("target must be a pointer to a struct")
+	}
+	if p.channels != nil || ctx.merge {
+		ctx.target = rv.Elem()
 	}
 	pv, err := p.root.Parse(ctx, rv.Elem())
+	p.closeChannels(rv.Elem())
 	if len(pv) > 0 && pv[0].Type() == rv.Elem().Type() {
 		rv.Elem().Set(reflect.Indirect(pv[0]))
 	}
 	if err != nil {
-		return err
+		return lex, markIncomplete(lex, err)
+	}
+	if ctx.recovery != nil && len(ctx.recovery.errors) > 0 {
+		return lex, ctx.recovery.errors
 	}
 	token, err := lex.Peek(0)
 	if err != nil {
-		return err
-	} else if !token.EOF() {
-		return lexer.Errorf(token.Pos, "expected %s but got %q", p.root, token)
+		return lex, err
+	} else if !partial && !token.EOF() {
+		return lex, lexer.Errorf(token.Pos, "expected %s but got %q", p.root, token)
 	}
 	if pv == nil {
-		return lexer.Errorf(token.Pos, "invalid syntax")
+		return lex, markIncomplete(lex, lexer.Errorf(token.Pos, "invalid syntax"))
 	}
-	return nil
+	if ctx.events != nil {
+		// Events are reported in place of the value, so there is nothing to check requirements of.
+		return lex, nil
+	}
+	return lex, p.checkRequirements(rv)
+}
+
+// Mark a positioned error as incomplete if it occurred at the end of the input.
+func markIncomplete(lex *lexer.BufferedLexer, err error) error {
+	perr, ok := err.(*ParseError)
+	if !ok {
+		lerr, ok := err.(*lexer.Error)
+		if !ok {
+			return err
+		}
+		perr = &ParseError{Message: lerr.Message, Pos: lerr.Pos, err: lerr}
+	}
+	for i := 0; ; i++ {
+		token, terr := lex.Peek(i)
+		if terr != nil {
+			return err
+		}
+		if token.EOF() {
+			if token.Pos != perr.Pos {
+				return err
+			}
+			break
+		}
+	}
+	perr.Incomplete = true
+	return perr
 }
 
-func (p *Parser) rootParseable(lex lexer.PeekingLexer, parseable Parseable) error {
+func (p *Parser) rootParseable(lex parseContext, parseable Parseable, partial bool) error {
 	peek, err := lex.Peek(0)
 	if err != nil {
 		return err
@@ -160,23 +849,26 @@ func (p *Parser) rootParseable(lex lexer.PeekingLexer, parseable Parseable) erro
 	if err == NextMatch {
 		return lexer.Errorf(peek.Pos, "invalid syntax")
 	}
-	if err == nil && !peek.EOF() {
+	if err == nil && !partial && !peek.EOF() {
 		return lexer.Errorf(peek.Pos, "unexpected token %q", peek)
 	}
 	return err
 }
 
 // ParseString is a convenience around Parse().
-func (p *Parser) ParseString(s string, v interface{}) error {
-	return p.Parse(strings.NewReader(s), v)
+func (p *Parser) ParseString(s string, v interface{}, options ...ParseOption) error {
+	return p.Parse(strings.NewReader(s), v, options...)
 }
 
 // ParseBytes is a convenience around Parse().
-func (p *Parser) ParseBytes(b []byte, v interface{}) error {
-	return p.Parse(bytes.NewReader(b), v)
+func (p *Parser) ParseBytes(b []byte, v interface{}, options ...ParseOption) error {
+	return p.Parse(bytes.NewReader(b), v, options...)
 }
 
 // String representation of the grammar.
 func (p *Parser) String() string {
+	if err := p.resolve(); err != nil {
+		return err.Error()
+	}
 	return dumpNode(p.root)
 }
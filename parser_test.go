@@ -1,10 +1,16 @@
 package participle
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -591,6 +597,31 @@ func TestPosInjection(t *testing.T) {
 	require.Equal(t, expected, actual)
 }
 
+func TestPosInjectionRepetition(t *testing.T) {
+	type element struct {
+		Pos    lexer.Position
+		EndPos lexer.Position
+		Key    string `@Ident "="`
+		Value  int    `@Int`
+	}
+	type grammar struct {
+		Elements []*element `{ @@ }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err := p.ParseString("a = 1\n  bb = 22\nc = 3", actual)
+		require.NoError(t, err)
+		require.Len(t, actual.Elements, 3)
+		require.Equal(t, lexer.Position{Offset: 0, Line: 1, Column: 1}, actual.Elements[0].Pos)
+		require.Equal(t, lexer.Position{Offset: 8, Line: 2, Column: 3}, actual.Elements[0].EndPos)
+		require.Equal(t, lexer.Position{Offset: 8, Line: 2, Column: 3}, actual.Elements[1].Pos)
+		require.Equal(t, lexer.Position{Offset: 16, Line: 3, Column: 1}, actual.Elements[1].EndPos)
+		require.Equal(t, lexer.Position{Offset: 16, Line: 3, Column: 1}, actual.Elements[2].Pos)
+		require.Equal(t, lexer.Position{Offset: 21, Line: 3, Column: 6}, actual.Elements[2].EndPos)
+	}
+}
+
 type parseableCount int
 
 func (c *parseableCount) Capture(values []string) error {
@@ -893,3 +924,2769 @@ func TestCaseInsensitive(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, expected, actual)
 }
+
+func TestParsePartial(t *testing.T) {
+	type grammar struct {
+		Key   string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`a = 1; b = 2;`, actual)
+	require.Error(t, err)
+
+	actual = &grammar{}
+	rest, err := p.ParsePartial(strings.NewReader(`a = 1; b = 2;`), actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Key: "a", Value: 1}, actual)
+	require.Equal(t, 4, rest.Cursor())
+	tokens, err := rest.Drain()
+	require.NoError(t, err)
+	values := []string{}
+	for _, token := range tokens {
+		values = append(values, token.Value)
+	}
+	require.Equal(t, []string{"b", "=", "2", ";"}, values)
+	require.Equal(t, 8, tokens[0].Pos.Column)
+}
+
+func TestUnorderedGroup(t *testing.T) {
+	type grammar struct {
+		Host    string `< "host" "=" @String`
+		Port    int    `| "port" "=" @Int`
+		Timeout int    `| "timeout" "=" @Int >`
+		End     bool   `@"end"`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+
+		actual := &grammar{}
+		err := p.ParseString(`port = 80 host = "localhost" end`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Host: "localhost", Port: 80, End: true}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`timeout = 10 port = 80 host = "localhost" end`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Host: "localhost", Port: 80, Timeout: 10, End: true}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`end`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{End: true}, actual)
+
+		// Each member may only appear once.
+		err = p.ParseString(`port = 80 port = 81 end`, &grammar{})
+		require.Error(t, err)
+	}
+}
+
+func TestCaptureAlso(t *testing.T) {
+	type grammar struct {
+		Raw   string `parser:"@Int" also:"Value, Text"`
+		Value int
+		Text  *string
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`0x10`, actual)
+	require.NoError(t, err)
+	text := "0x10"
+	require.Equal(t, &grammar{Raw: "0x10", Value: 16, Text: &text}, actual)
+
+	type badGrammar struct {
+		Raw string `parser:"@Int" also:"Missing"`
+	}
+	_, err = Build(&badGrammar{})
+	require.Error(t, err)
+}
+
+func TestExclusiveGroup(t *testing.T) {
+	type flag struct {
+		Verbose bool   `  @"verbose"`
+		Format  string `| ^( @"json" | @"yaml" )`
+	}
+	type grammar struct {
+		Flags []*flag `{ @@ }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+
+		actual := &grammar{}
+		err := p.ParseString(`verbose yaml`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Flags: []*flag{{Verbose: true}, {Format: "yaml"}}}, actual)
+
+		err = p.ParseString(`json verbose yaml`, &grammar{})
+		require.EqualError(t, err, `<source>:1:14: "yaml" conflicts with "json"`)
+
+		err = p.ParseString(`json json`, &grammar{})
+		require.EqualError(t, err, `<source>:1:6: "json" may only be specified once`)
+
+		err = p.ParseString(`verbose`, &grammar{})
+		require.EqualError(t, err, `<source>:1:8: expected exactly one of "json", "yaml"`)
+	}
+}
+
+func TestExclusiveGroupInSeveralRepetitions(t *testing.T) {
+	type flag struct {
+		Verbose bool   `  @"verbose"`
+		Format  string `| ^( @"json" | @"yaml" )`
+	}
+	type grammar struct {
+		Input  []*flag `"in" "(" { @@ } ")"`
+		Output []*flag `"out" "(" { @@ } ")"`
+	}
+	p := mustTestParser(t, &grammar{})
+
+	actual := &grammar{}
+	err := p.ParseString(`in ( json ) out ( verbose yaml )`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Input:  []*flag{{Format: "json"}},
+		Output: []*flag{{Verbose: true}, {Format: "yaml"}},
+	}, actual)
+
+	err = p.ParseString(`in ( json ) out ( verbose )`, &grammar{})
+	require.EqualError(t, err, `<source>:1:27: expected exactly one of "json", "yaml"`)
+}
+
+func TestCaptureExplicitTarget(t *testing.T) {
+	type value struct {
+		Int int `@Int`
+	}
+	type grammar struct {
+		Value *value
+		Name  string
+		Key   string `@Ident -> Name "=" @@ -> Value`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`a = 1`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Name: "a", Value: &value{Int: 1}}, actual)
+
+	type unknownField struct {
+		Key string `@Ident -> Missing`
+	}
+	_, err = Build(&unknownField{})
+	require.Error(t, err)
+
+	type unexportedField struct {
+		Key    string `@Ident -> hidden`
+		hidden string
+	}
+	_, err = Build(&unexportedField{})
+	require.Error(t, err)
+}
+
+func TestEnum(t *testing.T) {
+	type Color string
+	type grammar struct {
+		Colors []Color `{ @Ident }`
+	}
+	p := mustTestParser(t, &grammar{}, Enum(Color(""), "red", "green", "blue"))
+	actual := &grammar{}
+	err := p.ParseString(`red blue`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Colors: []Color{"red", "blue"}}, actual)
+
+	err = p.ParseString(`red purple`, &grammar{})
+	require.EqualError(t, err, `<source>:1:5: invalid Color "purple", expected one of "red", "green", "blue"`)
+
+	_, err = Build(&grammar{}, Enum(Color("")))
+	require.Error(t, err)
+}
+
+func TestParseErrorProductionStack(t *testing.T) {
+	type statement struct {
+		Key   string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type body struct {
+		Statements []*statement `"{" { @@ } "}"`
+	}
+	type function struct {
+		Name string `"func" @Ident`
+		Body *body  `@@`
+	}
+	p := mustTestParser(t, &function{}, ProductionStack())
+	err := p.ParseString(`func f { a = 1; b = 2 }`, &function{})
+	require.Error(t, err)
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, []string{"function", "body", "statement"}, perr.Stack)
+	require.Equal(t, lexer.Position{Offset: 22, Line: 1, Column: 23}, perr.Pos)
+	require.Contains(t, err.Error(), "while parsing function > body > statement: ")
+
+	var lerr *lexer.Error
+	require.True(t, errors.As(err, &lerr))
+	require.Equal(t, perr.Pos, lerr.Pos)
+
+	rejected := lexer.Errorf(lexer.Position{Line: 1, Column: 10}, "rejected")
+	err = p.ParseString(`func f { a = 1; }`, &function{}, OnStruct(func(v interface{}, pos lexer.Position) error {
+		if _, ok := v.(*statement); ok {
+			return rejected
+		}
+		return nil
+	}))
+	require.EqualError(t, err, "<source>:1:10: while parsing function > body > statement: rejected")
+	require.True(t, errors.Is(err, rejected))
+
+	err = mustTestParser(t, &function{}).ParseString(`func f { a = 1; b = 2 }`, &function{})
+	require.EqualError(t, err, `<source>:1:23: unexpected "}" (expected ";")`)
+}
+
+func TestCaptureRune(t *testing.T) {
+	type grammar struct {
+		Runes []rune `{ @Char }`
+		Byte  byte   `[ "=" @Char ]`
+		Code  int32  `[ ":" @(Int | Ident) ]`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`'a' '世' '7' 'ß' = 'c' : 7`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Runes: []rune{'a', '世', '7', 'ß'}, Byte: 'c', Code: 7}, actual)
+
+	err = p.ParseString(`= 'é'`, &grammar{})
+	require.EqualError(t, err, `<source>:1:3: invalid byte "é": must be a single byte`)
+
+	// Only the values of Char tokens are characters.
+	err = p.ParseString(`: abc`, &grammar{})
+	require.EqualError(t, err, `<source>:1:3: participle.grammar.Code: invalid integer "abc": strconv.ParseInt: parsing "abc": invalid syntax`)
+}
+
+func TestStringCaptureJoining(t *testing.T) {
+	type grammar struct {
+		Path string `@Ident { "." @Ident }`
+	}
+	actual := &grammar{}
+	err := mustTestParser(t, &grammar{}).ParseString(`a.b.c`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Path: "abc"}, actual)
+
+	actual = &grammar{}
+	err = mustTestParser(t, &grammar{}, JoinStrings("/")).ParseString(`a.b.c`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Path: "a/b/c"}, actual)
+
+	p := mustTestParser(t, &grammar{}, StrictStrings())
+	actual = &grammar{}
+	err = p.ParseString(`a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Path: "a"}, actual)
+	err = p.ParseString(`a.b`, &grammar{})
+	require.Error(t, err)
+
+	// An empty string is a capture like any other.
+	type quoted struct {
+		Path string `@String { "." @String }`
+	}
+	actualQuoted := &quoted{}
+	err = mustTestParser(t, &quoted{}, JoinStrings("/")).ParseString(`"" . "b"`, actualQuoted)
+	require.NoError(t, err)
+	require.Equal(t, &quoted{Path: "/b"}, actualQuoted)
+	err = mustTestParser(t, &quoted{}, StrictStrings()).ParseString(`"" . "b"`, &quoted{})
+	require.EqualError(t, err, `<source>:1:6: participle.quoted.Path: multiple values captured into string field`)
+
+	_, err = Build(&grammar{}, JoinStrings("/"), StrictStrings())
+	require.EqualError(t, err, "StrictStrings() can not be combined with JoinStrings()")
+	_, err = Build(&grammar{}, StrictStrings(), JoinStrings("/"))
+	require.EqualError(t, err, "JoinStrings() can not be combined with StrictStrings()")
+}
+
+func TestRecord(t *testing.T) {
+	type block struct {
+		Name        string   `"block" "{" %{ "name" "=" @String`
+		Description string   `           | "description" "=" @String ?`
+		Tags        []string `           | "tag" "=" @String * } "}"`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &block{}, options...)
+
+		actual := &block{}
+		err := p.ParseString(`block { tag = "a" name = "n" tag = "b" }`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &block{Name: "n", Tags: []string{"a", "b"}}, actual)
+
+		actual = &block{}
+		err = p.ParseString(`block { description = "d" name = "n" }`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &block{Name: "n", Description: "d"}, actual)
+
+		err = p.ParseString(`block { tag = "a" }`, &block{})
+		require.EqualError(t, err, `<source>:1:19: missing required "name"`)
+
+		err = p.ParseString(`block { name = "a" name = "b" }`, &block{})
+		require.Error(t, err)
+	}
+}
+
+func TestLazyLexer(t *testing.T) {
+	type grammar struct {
+		Key   string `@Ident "="`
+		Value int    `@Number`
+	}
+	// The lexer is not defined until after Build().
+	var def lexer.Definition
+	p := mustTestParser(t, &grammar{}, LazyLexer(func() (lexer.Definition, error) { return def, nil }), Elide("Whitespace"))
+	def = lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<Ident>[a-z]+)|(?P<Number>\d+)|(?P<Punct>=)`))
+
+	actual := &grammar{}
+	err := p.ParseString(`a = 1`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Key: "a", Value: 1}, actual)
+
+	// Unresolved token types are reported on first use.
+	p = mustTestParser(t, &grammar{}, LazyLexer(func() (lexer.Definition, error) { return lexer.TextScannerLexer, nil }))
+	err = p.ParseString(`a = 1`, &grammar{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unknown token type "Number", lexer defines `)
+}
+
+func TestKeywordSet(t *testing.T) {
+	type operation struct {
+		Operator string `@$operator`
+		Operand  int    `@Int`
+	}
+	type expression struct {
+		Left       int          `@Int`
+		Operations []*operation `{ @@ }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &expression{}, options...)
+		operators := map[string]bool{"plus": true}
+
+		actual := &expression{}
+		err := p.ParseString(`1 plus 2`, actual, WithKeywords("operator", operators))
+		require.NoError(t, err)
+		require.Equal(t, &expression{Left: 1, Operations: []*operation{{"plus", 2}}}, actual)
+
+		err = p.ParseString(`1 plus 2 times 3`, &expression{}, WithKeywords("operator", operators))
+		require.Error(t, err)
+
+		operators["times"] = true
+		actual = &expression{}
+		err = p.ParseString(`1 plus 2 times 3`, actual, WithKeywords("operator", operators))
+		require.NoError(t, err)
+		require.Equal(t, &expression{Left: 1, Operations: []*operation{{"plus", 2}, {"times", 3}}}, actual)
+
+		err = p.ParseString(`1 plus 2`, &expression{})
+		require.Error(t, err)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	type binding struct {
+		Mutable bool   `(   ?experimental @"var"`
+		Keyword string `  | @("let" | ?experimental "const") )`
+		Name    string `@Ident`
+	}
+	type grammar struct {
+		Bindings []*binding `{ @@ ";" }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}, {NoLookahead()}, {LongestMatch()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err := p.ParseString(`var a; const b; let c;`, actual, WithFlags("experimental"))
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Bindings: []*binding{
+			{Mutable: true, Name: "a"},
+			{Keyword: "const", Name: "b"},
+			{Keyword: "let", Name: "c"},
+		}}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`let c;`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Bindings: []*binding{{Keyword: "let", Name: "c"}}}, actual)
+
+		err = p.ParseString(`var a;`, &grammar{})
+		require.Error(t, err)
+		err = p.ParseString(`const b;`, &grammar{}, WithFlags("other"))
+		require.Error(t, err)
+	}
+
+	type invalid struct {
+		A string `?"a" @Ident`
+	}
+	_, err := Build(&invalid{})
+	require.EqualError(t, err, `A: expected flag name after ? but got "a"`)
+}
+
+func TestVersion(t *testing.T) {
+	type server struct {
+		Host    string   `parser:"\"server\" @Ident"`
+		Port    int      `parser:"@Int" since:"2"`
+		Timeout int      `parser:"[ \"timeout\" @Int ]" since:"3"`
+		Tags    []string `parser:"{ \"tag\" @Ident }" since:"2"`
+	}
+	type grammar struct {
+		Servers []*server `{ @@ ";" }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}, {NoLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err := p.ParseString(`server a; server b;`, actual, WithVersion(1))
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Servers: []*server{{Host: "a"}, {Host: "b"}}}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`server a 80 tag x tag y;`, actual, WithVersion(2))
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Servers: []*server{{Host: "a", Port: 80, Tags: []string{"x", "y"}}}}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`server a 80 timeout 5 tag x;`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Servers: []*server{{Host: "a", Port: 80, Timeout: 5, Tags: []string{"x"}}}}, actual)
+
+		err = p.ParseString(`server a 80;`, &grammar{}, WithVersion(1))
+		require.Error(t, err)
+		err = p.ParseString(`server a 80 timeout 5;`, &grammar{}, WithVersion(2))
+		require.Error(t, err)
+	}
+
+	type invalidVersion struct {
+		A string `parser:"@Ident" since:"two"`
+	}
+	_, err := Build(&invalidVersion{})
+	require.EqualError(t, err, `A: invalid since "two", expected an integer version`)
+
+	type partialTerm struct {
+		A string `parser:"( @Ident" since:"2"`
+		B string `parser:"@Int )"`
+	}
+	_, err = Build(&partialTerm{})
+	require.EqualError(t, err, `B: the grammar of a field tagged with since must consist of complete terms`)
+}
+
+func TestCaptureCount(t *testing.T) {
+	type grammar struct {
+		Name  string `@Ident "{"`
+		Items int    `{ @#( Ident ";" ) } "}"`
+		Bangs uint8  `{ @#"!" }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err := p.ParseString(`list { a; b; c; } ! !`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Name: "list", Items: 3, Bangs: 2}, actual)
+	}
+
+	type invalid struct {
+		Items string `{ @#Ident }`
+	}
+	_, err := Build(&invalid{})
+	require.Error(t, err)
+}
+
+func TestSignedNumbers(t *testing.T) {
+	type grammar struct {
+		Ints   []int     `{ @Int`
+		Floats []float64 `| @Float }`
+		Minus  string    `[ @"-" @Ident ]`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, append(options, SignedNumbers())...)
+		actual := &grammar{}
+		err := p.ParseString(`1 -2 +3 - 4 -1.5 - x`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Ints: []int{1, -2, 3, -4}, Floats: []float64{-1.5}, Minus: "-x"}, actual)
+	}
+
+	// Strings are not signs.
+	type signs struct {
+		Values []string `{ @String`
+		Ints   []int    `| @Int }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &signs{}, append(options, SignedNumbers())...)
+		actual := &signs{}
+		err := p.ParseString(`"-" 2 -3`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &signs{Values: []string{"-"}, Ints: []int{2, -3}}, actual)
+	}
+
+	p := mustTestParser(t, &grammar{})
+	err := p.ParseString(`-2`, &grammar{})
+	require.Error(t, err)
+}
+
+func TestMaxTokens(t *testing.T) {
+	type grammar struct {
+		Values []string `{ @Ident }`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, append(options, MaxTokens(3))...)
+		actual := &grammar{}
+		err := p.ParseString(`a b c`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Values: []string{"a", "b", "c"}}, actual)
+
+		err = p.ParseString(`a b c d`, &grammar{})
+		require.Error(t, err)
+		merr, ok := err.(*MaxTokensError)
+		require.True(t, ok, "%T", err)
+		require.Equal(t, 3, merr.Limit)
+		require.Equal(t, 7, merr.Pos.Column)
+	}
+}
+
+func TestLongInput(t *testing.T) {
+	type statement struct {
+		Name  string `@Ident`
+		Value *int   `( "=" @Int`
+		Call  bool   `| @"(" ")" ) ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	source := strings.Repeat("a = 1; b(); ", 500)
+	for _, options := range [][]Option{nil, {NoLookahead()}, {LongestMatch()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err := p.ParseString(source, actual)
+		require.NoError(t, err)
+		require.Len(t, actual.Statements, 1000)
+		require.Equal(t, 1, *actual.Statements[998].Value)
+		require.True(t, actual.Statements[999].Call)
+	}
+}
+
+func TestLongestMatch(t *testing.T) {
+	type grammar struct {
+		Short string `  @Ident`
+		Long  string `| @Ident "." @Ident`
+		Other string `| @Ident ":" @Ident`
+	}
+	p := mustTestParser(t, &grammar{})
+	err := p.ParseString(`a.b`, &grammar{})
+	require.Error(t, err)
+
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p = mustTestParser(t, &grammar{}, append(options, LongestMatch())...)
+		actual := &grammar{}
+		err = p.ParseString(`a.b`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Long: "ab"}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`a`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Short: "a"}, actual)
+
+		err = p.ParseString(`a.`, &grammar{})
+		require.Error(t, err)
+	}
+}
+
+func TestOptionalPresence(t *testing.T) {
+	type grammar struct {
+		Key      string `@Ident`
+		Value    int    `[ "=" @Int ] -> HasValue`
+		HasValue *bool
+	}
+	p, err := Build(&grammar{})
+	require.NoError(t, err)
+
+	yes, no := true, false
+	actual := &grammar{}
+	err = p.ParseString(`a = 0`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Key: "a", HasValue: &yes}, actual)
+
+	actual = &grammar{}
+	err = p.ParseString(`a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Key: "a", HasValue: &no}, actual)
+
+	type invalid struct {
+		Value int `[ @Int ] -> Value`
+	}
+	_, err = Build(&invalid{})
+	require.EqualError(t, err, "Value: optional presence can only be captured into bool fields, not int")
+
+	type captured struct {
+		Value *bool `@[ "yes" ] -> Flag`
+		Flag  bool
+	}
+	p, err = Build(&captured{})
+	require.NoError(t, err)
+	actualCaptured := &captured{}
+	err = p.ParseString(`yes`, actualCaptured)
+	require.NoError(t, err)
+	require.Equal(t, &captured{Flag: true}, actualCaptured)
+}
+
+func TestDocComments(t *testing.T) {
+	type docDecl struct {
+		Doc   string
+		Key   string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type docFile struct {
+		Decls []*docDecl `{ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?m)(\s+)|(?P<Comment>//[^\n]*)|(?P<Ident>[a-z]+)|(?P<Int>\d+)|(?P<Punct>[=;])`))
+	p, err := Build(&docFile{}, Lexer(lex), DocComments("Comment"))
+	require.NoError(t, err)
+
+	actual := &docFile{}
+	err = p.ParseString(`
+// The first.
+// Second line.
+a = 1;
+b = 2; // Trailing.
+c = 3;
+`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &docFile{Decls: []*docDecl{
+		{Doc: "// The first.\n// Second line.", Key: "a", Value: 1},
+		{Key: "b", Value: 2},
+		{Doc: "// Trailing.", Key: "c", Value: 3},
+	}}, actual)
+}
+
+func TestTrailingComments(t *testing.T) {
+	type value struct {
+		TrailingComment string
+		Int             int `@Int`
+	}
+	type decl struct {
+		Doc             string
+		Key             string `@Ident "="`
+		Value           *value `@@ ";"`
+		TrailingComment string
+	}
+	type file struct {
+		Decls []*decl `{ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?m)(\s+)|(?P<Comment>//[^\n]*)|(?P<Ident>[a-z]+)|(?P<Int>\d+)|(?P<Punct>[=;])`))
+	p := mustTestParser(t, &file{}, Lexer(lex), DocComments("Comment"))
+	actual := &file{}
+	err := p.ParseString(`
+// The first.
+a = 1; // After a.
+// Before b.
+b = 2 // Within b.
+;
+c = 3;
+// Before d.
+d = 4; // After d.
+`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &file{Decls: []*decl{
+		{Doc: "// The first.", Key: "a", Value: &value{Int: 1}, TrailingComment: "// After a."},
+		{Doc: "// Before b.", Key: "b", Value: &value{Int: 2, TrailingComment: "// Within b."}},
+		{Key: "c", Value: &value{Int: 3}},
+		{Doc: "// Before d.", Key: "d", Value: &value{Int: 4}, TrailingComment: "// After d."},
+	}}, actual)
+
+	type statement struct {
+		A *value `  @@ ";"`
+		B *value `| @@ ";" ";"`
+	}
+	type statements struct {
+		Statements []*statement `{ @@ }`
+	}
+	p = mustTestParser(t, &statements{}, Lexer(lex), DocComments("Comment"), LongestMatch())
+	actualStatements := &statements{}
+	err = p.ParseString("2 // Trailing.\n;;", actualStatements)
+	require.NoError(t, err)
+	require.Equal(t, &statements{Statements: []*statement{
+		{B: &value{Int: 2, TrailingComment: "// Trailing."}},
+	}}, actualStatements)
+}
+
+func TestLeadingTrivia(t *testing.T) {
+	type value struct {
+		LeadingTrivia []lexer.Token
+		Int           int `@Int`
+	}
+	type decl struct {
+		LeadingTrivia []lexer.Token
+		Key           string `@Ident "="`
+		Value         *value `@@ ";"`
+	}
+	type file struct {
+		LeadingTrivia []lexer.Token
+		Decls         []*decl `{ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?m)(?P<Whitespace>\s+)|(?P<Comment>//[^\n]*)|(?P<Ident>[a-z]+)|(?P<Int>\d+)|(?P<Punct>[=;])`))
+	p := mustTestParser(t, &file{}, Lexer(lex), Elide("Whitespace", "Comment"))
+	actual := &file{}
+	err := p.ParseString("// Header.\na =  1;\n\nb = 2;", actual)
+	require.NoError(t, err)
+	values := func(tokens []lexer.Token) []string {
+		out := []string{}
+		for _, token := range tokens {
+			out = append(out, token.Value)
+		}
+		return out
+	}
+	// The trivia preceding the first declaration is claimed by the file, which starts there too.
+	require.Equal(t, []string{"// Header.", "\n"}, values(actual.LeadingTrivia))
+	require.Nil(t, actual.Decls[0].LeadingTrivia)
+	require.Equal(t, []string{"  "}, values(actual.Decls[0].Value.LeadingTrivia))
+	require.Equal(t, []string{"\n\n"}, values(actual.Decls[1].LeadingTrivia))
+	require.Equal(t, []string{" "}, values(actual.Decls[1].Value.LeadingTrivia))
+	require.Equal(t, lexer.Position{Offset: 0, Line: 1, Column: 1}, actual.LeadingTrivia[0].Pos)
+}
+
+func TestLeadingTriviaLongestMatch(t *testing.T) {
+	type value struct {
+		LeadingTrivia []lexer.Token
+		Int           int `@Int`
+	}
+	type statement struct {
+		A *value `  @@ ";"`
+		B *value `| @@ ";" ";"`
+	}
+	type file struct {
+		Statements []*statement `{ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<Int>\d+)|(?P<Punct>;)`))
+	p := mustTestParser(t, &file{}, Lexer(lex), Elide("Whitespace"), LongestMatch())
+	actual := &file{}
+	err := p.ParseString("1;  2;;", actual)
+	require.NoError(t, err)
+	require.Equal(t, 2, actual.Statements[1].B.Int)
+	require.Len(t, actual.Statements[1].B.LeadingTrivia, 1)
+	require.Equal(t, "  ", actual.Statements[1].B.LeadingTrivia[0].Value)
+}
+
+type enumKind int
+
+const (
+	enumWord enumKind = iota
+	enumNumber
+	enumPunct
+)
+
+func (k enumKind) String() string {
+	return [...]string{"Word", "Number", "Punct"}[k]
+}
+
+// A lexer whose token types are the values of enumKind.
+type enumLexer struct {
+	lexer   lexer.Lexer
+	symbols map[rune]string
+}
+
+func (e *enumLexer) Symbols() map[string]rune {
+	symbols, err := lexer.EnumSymbols(enumWord, enumPunct)
+	if err != nil {
+		panic(err)
+	}
+	return symbols
+}
+
+func (e *enumLexer) Lex(r io.Reader) (lexer.Lexer, error) {
+	lex, err := lexer.TextScannerLexer.Lex(r)
+	if err != nil {
+		return nil, err
+	}
+	return &enumLexer{lexer: lex, symbols: lexer.SymbolsByRune(lexer.TextScannerLexer)}, nil
+}
+
+func (e *enumLexer) Next() (lexer.Token, error) {
+	token, err := e.lexer.Next()
+	if err != nil || token.EOF() {
+		return token, err
+	}
+	switch e.symbols[token.Type] {
+	case "Ident":
+		token.Type = rune(enumWord)
+	case "Int":
+		token.Type = rune(enumNumber)
+	default:
+		token.Type = rune(enumPunct)
+	}
+	return token, nil
+}
+
+func TestEnumSymbols(t *testing.T) {
+	type assignment struct {
+		Name  string `@Word "="`
+		Value int    `@Number ";"`
+	}
+	type grammar struct {
+		Assignments []*assignment `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{}, Lexer(&enumLexer{}))
+	actual := &grammar{}
+	err := p.ParseString(`a = 1; b = 2;`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Assignments: []*assignment{{"a", 1}, {"b", 2}}}, actual)
+
+	_, err = Build(&struct {
+		Name string `@Ident`
+	}{}, Lexer(&enumLexer{}))
+	require.EqualError(t, err, `Name: unknown token type "Ident", lexer defines EOF, Number, Punct, Word`)
+}
+
+type unionValue interface{ value() }
+
+type unionNumber struct {
+	Number int `@Int`
+}
+
+func (*unionNumber) value() {}
+
+type unionString struct {
+	String string `@String`
+}
+
+func (unionString) value() {}
+
+func TestUnion(t *testing.T) {
+	type grammar struct {
+		Values []unionValue `"[" { @@ } "]"`
+		Last   unionValue   `@@:unionString`
+	}
+	p, err := Build(&grammar{}, Union((*unionValue)(nil), &unionNumber{}, unionString{}))
+	require.NoError(t, err)
+
+	actual := &grammar{}
+	err = p.ParseString(`[1 "a" 2] "b"`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Values: []unionValue{&unionNumber{1}, unionString{"a"}, &unionNumber{2}},
+		Last:   unionString{"b"},
+	}, actual)
+
+	err = p.ParseString(`[] 1`, &grammar{})
+	require.Error(t, err)
+
+	p, err = Build(&grammar{}, UseLookahead(), Union((*unionValue)(nil), &unionNumber{}, unionString{}))
+	require.NoError(t, err)
+	actual = &grammar{}
+	err = p.ParseString(`[1 "a"] "b"`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Values: []unionValue{&unionNumber{1}, unionString{"a"}}, Last: unionString{"b"}}, actual)
+
+	type unknownMember struct {
+		Value unionValue `@@:grammar`
+	}
+	_, err = Build(&unknownMember{}, Union((*unionValue)(nil), &unionNumber{}))
+	require.EqualError(t, err, "Value: grammar is not a member of the union participle.unionValue")
+
+	type unregistered struct {
+		Value unionValue `@@:unionNumber`
+	}
+	_, err = Build(&unregistered{})
+	require.EqualError(t, err, "Value: @@:unionNumber requires a Union() to be registered for participle.unionValue")
+
+	_, err = Build(&grammar{}, Union((*unionValue)(nil), unionNumber{}))
+	require.EqualError(t, err, "union member participle.unionNumber does not implement participle.unionValue")
+}
+
+type taggedShape interface{ shape() }
+
+type taggedCircle struct {
+	Radius int `@Int`
+}
+
+func (*taggedCircle) shape() {}
+
+type taggedRect struct {
+	Width  int `@Int`
+	Height int `@Int`
+}
+
+func (*taggedRect) shape() {}
+
+func TestTaggedUnion(t *testing.T) {
+	type grammar struct {
+		Shapes []taggedShape `{ @@ ";" }`
+		Last   taggedShape   `[ "last" @@:taggedRect ]`
+	}
+	members := map[string]interface{}{"circle": &taggedCircle{}, "rect": &taggedRect{}}
+	for _, options := range [][]Option{nil, {UseLookahead()}, {NoLookahead()}} {
+		p := mustTestParser(t, &grammar{}, append(options, TaggedUnion((*taggedShape)(nil), members))...)
+		actual := &grammar{}
+		err := p.ParseString(`circle 1; rect 2 3; circle 4; last rect 5 6`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{
+			Shapes: []taggedShape{&taggedCircle{1}, &taggedRect{2, 3}, &taggedCircle{4}},
+			Last:   &taggedRect{5, 6},
+		}, actual)
+
+		err = p.ParseString(`circle 1; rect 2;`, &grammar{})
+		require.Error(t, err)
+		err = p.ParseString(`last circle 1`, &grammar{})
+		require.Error(t, err)
+		// Strings are not discriminators.
+		err = p.ParseString(`"circle" 1;`, &grammar{})
+		require.Error(t, err)
+	}
+
+	_, err := Build(&grammar{}, TaggedUnion((*taggedShape)(nil), map[string]interface{}{"circle": taggedCircle{}}))
+	require.EqualError(t, err, "union member participle.taggedCircle does not implement participle.taggedShape")
+	_, err = Build(&grammar{}, TaggedUnion((*taggedShape)(nil), map[string]interface{}{"": &taggedCircle{}}))
+	require.EqualError(t, err, "TaggedUnion() for participle.taggedShape requires non-empty discriminators")
+}
+
+func TestRepetitionDiscardsReferences(t *testing.T) {
+	type item struct {
+		Name string `@Ident`
+	}
+	type grammar struct {
+		Items []*item  `"[" { @@ Comma } "]"`
+		Names []string `{ @Ident Comma }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Ident>[a-z]+)|(?P<Comma>,)|(?P<Punct>[\[\]])`))
+	for _, options := range [][]Option{{Lexer(lex)}, {Lexer(lex), UseLookahead()}} {
+		p, err := Build(&grammar{}, options...)
+		require.NoError(t, err)
+
+		actual := &grammar{}
+		err = p.ParseString(`[a, b,] c, d,`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Items: []*item{{"a"}, {"b"}}, Names: []string{"c", "d"}}, actual)
+
+		err = p.ParseString(`[a b,]`, &grammar{})
+		require.Error(t, err)
+	}
+}
+
+func TestErrIncomplete(t *testing.T) {
+	type statement struct {
+		Name  string   `"let" @Ident "="`
+		Value []string `"(" { @Ident } ")" ";"`
+	}
+	p, err := Build(&statement{})
+	require.NoError(t, err)
+
+	input := ""
+	lines := []string{"let a", "= (b", "c)", ";"}
+	for i, line := range lines {
+		input += line + "\n"
+		actual := &statement{}
+		err = p.ParseString(input, actual)
+		if i < len(lines)-1 {
+			require.True(t, errors.Is(err, ErrIncomplete), "%q: %v", input, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, &statement{Name: "a", Value: []string{"b", "c"}}, actual)
+	}
+
+	err = p.ParseString(``, &statement{})
+	require.True(t, errors.Is(err, ErrIncomplete))
+
+	err = p.ParseString(`let a = ( b ; ;`, &statement{})
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrIncomplete))
+}
+
+func TestReferenceSet(t *testing.T) {
+	type grammar struct {
+		Values []string `{ @(String|Int) }`
+		Ident  string   `( @Ident | "=" @Float )`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p, err := Build(&grammar{}, options...)
+		require.NoError(t, err)
+		require.Contains(t, stringer(p.root), "(<string> | <int>)")
+
+		actual := &grammar{}
+		err = p.ParseString(`"a" 1 "b" c`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Values: []string{"a", "1", "b"}, Ident: "c"}, actual)
+
+		err = p.ParseString(`"a" 1.5`, &grammar{})
+		require.Error(t, err)
+	}
+
+	p, err := Build(&grammar{})
+	require.NoError(t, err)
+	ref, ok := p.root.(*strct).expr.(*repetition).node.(*capture).node.(*reference)
+	require.True(t, ok)
+	require.Equal(t, "String|Int", ref.identifier)
+}
+
+func TestLookaheadReferenceSet(t *testing.T) {
+	type grammar struct {
+		Assign string `  @(Ident|String) "="`
+		Call   string `| @(Ident|String) "("`
+		Number string `| @Int`
+	}
+	p, err := Build(&grammar{}, UseLookahead())
+	require.NoError(t, err)
+	for input, expected := range map[string]*grammar{
+		`a =`:   {Assign: "a"},
+		`"a" (`: {Call: "a"},
+		`a (`:   {Call: "a"},
+		`1`:     {Number: "1"},
+	} {
+		actual := &grammar{}
+		err = p.ParseString(input, actual)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, actual, input)
+	}
+}
+
+type setterEntry struct {
+	key   string       `@Ident "="`
+	value int          `@Int`
+	inner *setterInner `[ "(" @@ ")" ]`
+	Alias string       `[ "as" @Ident -> Label ]`
+	label string
+}
+
+type setterInner struct {
+	Name string `@Ident`
+}
+
+func (s *setterEntry) SetKey(key string) { s.key = key }
+
+func (s *setterEntry) SetValue(value int) error {
+	if value < 0 {
+		return fmt.Errorf("negative value %d", value)
+	}
+	s.value = value
+	return nil
+}
+
+func (s *setterEntry) SetInner(inner *setterInner) { s.inner = inner }
+
+func (s *setterEntry) SetLabel(label string) { s.label = label }
+
+func TestCaptureSetter(t *testing.T) {
+	p, err := Build(&setterEntry{}, SignedNumbers())
+	require.NoError(t, err)
+
+	actual := &setterEntry{}
+	err = p.ParseString(`a = 1 (b) as c`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &setterEntry{key: "a", value: 1, inner: &setterInner{Name: "b"}, label: "c"}, actual)
+
+	err = p.ParseString(`a = -1`, &setterEntry{})
+	require.EqualError(t, err, `<source>:1:5: participle.setterEntry.Value: negative value -1`)
+
+	type invalid struct {
+		Name string `@Ident -> Label`
+	}
+	_, err = Build(&invalid{})
+	require.EqualError(t, err, `Name: unknown field "Label" in participle.invalid`)
+
+	_, err = Build(&badSetter{})
+	require.EqualError(t, err, "Key: setter participle.badSetter.SetName must accept a single value and return nothing or an error")
+}
+
+type badSetter struct {
+	Key string `@Ident -> Name`
+}
+
+func (b *badSetter) SetName(key, value string) {}
+
+func TestPositiveLookahead(t *testing.T) {
+	type grammar struct {
+		Call string `  &(@Ident "(") @Ident "(" ")"`
+		Var  string `| @Ident`
+	}
+	for _, options := range [][]Option{nil, {UseLookahead()}} {
+		p, err := Build(&grammar{}, options...)
+		require.NoError(t, err)
+
+		actual := &grammar{}
+		err = p.ParseString(`a()`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Call: "a"}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`a`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Var: "a"}, actual)
+	}
+
+	// Doc comments are not claimed by the assertion.
+	type decl struct {
+		Doc string
+		Key string `@Ident ";"`
+	}
+	type file struct {
+		Decls []*decl `{ &@@ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Comment>//[^\n]*)|(?P<Ident>[a-z]+)|(?P<Punct>;)`))
+	p, err := Build(&file{}, Lexer(lex), DocComments("Comment"))
+	require.NoError(t, err)
+	actual := &file{}
+	err = p.ParseString("// A.\na;", actual)
+	require.NoError(t, err)
+	require.Equal(t, &file{Decls: []*decl{{Doc: "// A.", Key: "a"}}}, actual)
+
+	type invalid struct {
+		A string `@Ident &`
+	}
+	_, err = Build(&invalid{})
+	require.EqualError(t, err, "A: expected expression after &")
+}
+
+type setsTerm struct {
+	Number int       `  @Int`
+	Group  *setsExpr `| "(" @@ ")"`
+}
+
+type setsExpr struct {
+	Left  *setsTerm   `@@`
+	Right []*setsTerm `{ "+" @@ }`
+	Unit  string      `[ @Ident ]`
+}
+
+type setsProgram struct {
+	Exprs []*setsExpr `{ @@ ";" }`
+}
+
+func TestCaptureRaw(t *testing.T) {
+	type rawArg struct {
+		Expr string `@=( Ident { ("+" | "*") Ident } )`
+	}
+	type grammar struct {
+		Name string    `@Ident "("`
+		Args []*rawArg `[ @@ { "," @@ } ] ")"`
+	}
+	p, err := Build(&grammar{})
+	require.NoError(t, err)
+
+	actual := &grammar{}
+	err = p.ParseString("f(a  +b * c , d)", actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Name: "f", Args: []*rawArg{{Expr: "a  +b * c"}, {Expr: "d"}}}, actual)
+
+	// Elided tokens following the expression are not part of it.
+	lex := lexer.Must(lexer.Regexp(`(?P<Whitespace>\s+)|(?P<Comment>/\*[^*]*\*/)|(?P<Ident>\w+)|(?P<Punct>[(),+*])`))
+	p, err = Build(&grammar{}, Lexer(lex), Elide("Whitespace", "Comment"))
+	require.NoError(t, err)
+	actual = &grammar{}
+	err = p.ParseString("f(a /* x */ + b /* y */, c)", actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Name: "f", Args: []*rawArg{{Expr: "a /* x */ + b"}, {Expr: "c"}}}, actual)
+
+	type invalid struct {
+		Count int `@=Ident`
+	}
+	_, err = Build(&invalid{})
+	require.EqualError(t, err, "Count: @= can only capture into string fields, not int")
+}
+
+func TestParserReusesBuffers(t *testing.T) {
+	type grammar struct {
+		Idents []string `{ @Ident }`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString("one two three four", actual)
+	require.NoError(t, err)
+	require.Equal(t, []string{"one", "two", "three", "four"}, actual.Idents)
+
+	actual = &grammar{}
+	err = p.ParseString("five", actual)
+	require.NoError(t, err)
+	require.Equal(t, []string{"five"}, actual.Idents)
+
+	err = p.ParseString("six 7", &grammar{})
+	require.EqualError(t, err, `<source>:1:5: expected ( <ident> ) but got "7"`)
+	actual = &grammar{}
+	err = p.ParseString("eight", actual)
+	require.NoError(t, err)
+	require.Equal(t, []string{"eight"}, actual.Idents)
+}
+
+func TestWithUnescaper(t *testing.T) {
+	// Decodes \xNN and \u{N...} escapes in a double quoted string.
+	unescape := func(s string) (string, error) {
+		out := strings.Builder{}
+		for i := 1; i < len(s)-1; i++ {
+			if s[i] != '\\' {
+				out.WriteByte(s[i])
+				continue
+			}
+			switch {
+			case strings.HasPrefix(s[i:], `\x`) && i+4 <= len(s)-1:
+				n, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+				if err != nil {
+					return "", &UnescapeError{Offset: i, Message: "invalid hex escape"}
+				}
+				out.WriteByte(byte(n))
+				i += 3
+			case strings.HasPrefix(s[i:], `\u{`) && strings.Contains(s[i:], "}"):
+				end := i + strings.Index(s[i:], "}")
+				n, err := strconv.ParseUint(s[i+3:end], 16, 32)
+				if err != nil {
+					return "", &UnescapeError{Offset: i, Message: "invalid unicode escape"}
+				}
+				out.WriteRune(rune(n))
+				i = end
+			default:
+				return "", &UnescapeError{Offset: i, Message: "unknown escape"}
+			}
+		}
+		return out.String(), nil
+	}
+	type grammar struct {
+		Key   string   `@Ident "="`
+		Value []string `{ @String }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Ident>[a-z]+)|(?P<String>"[^"]*")|(?P<Punct>=)`))
+	p := mustTestParser(t, &grammar{}, Lexer(lex), WithUnescaper("String", unescape))
+
+	actual := &grammar{}
+	err := p.ParseString(`a = "\x41b" "\u{1F600}"`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Key: "a", Value: []string{"Ab", "😀"}}, actual)
+
+	err = p.ParseString(`a = "ok" "x\u{zz}"`, &grammar{})
+	require.EqualError(t, err, `<source>:1:12: invalid escape in "\"x\\u{zz}\"": invalid unicode escape`)
+
+	err = p.ParseString("a =\n  \"\\q\"", &grammar{})
+	require.EqualError(t, err, `<source>:2:4: invalid escape in "\"\\q\"": unknown escape`)
+
+	type raw struct {
+		Value string `@RawString`
+	}
+	tabbed := mustTestParser(t, &raw{}, Lexer(lexer.TextScanner(lexer.TabWidth(4))), WithUnescaper("RawString", unescape))
+	err = tabbed.ParseString("\t`a\tb\\q`", &raw{})
+	require.EqualError(t, err, "<source>:1:10: invalid escape in \"a\\tb\\\\q\": unknown escape")
+
+	_, err = Build(&grammar{}, Lexer(lex), WithUnescaper("Rune", unescape))
+	require.EqualError(t, err, `unescaper uses unknown token "Rune"`)
+}
+
+func TestReportUncapturedFields(t *testing.T) {
+	type value struct {
+		Pos      lexer.Position
+		Number   int    `@Int`
+		Unit     string `[ @Ident ]`
+		Scale    int
+		Optional bool `[ "?" ] -> Optional`
+	}
+	type grammar struct {
+		Name    string   `@Ident`
+		Alias   string   `[ "as" @Ident ]`
+		Values  []*value `{ @@ }`
+		Comment string
+	}
+	fields := []UncapturedField{}
+	_ = mustTestParser(t, &grammar{}, ReportUncapturedFields(func(field UncapturedField) {
+		fields = append(fields, field)
+	}))
+	require.Equal(t, []UncapturedField{
+		{Production: "grammar", Field: "Comment"},
+		{Production: "value", Field: "Scale"},
+	}, fields)
+}
+
+type precedenceExpr struct {
+	Left  *precedenceExpr
+	Op    string
+	Right *precedenceExpr
+
+	Number *int            `  @Int`
+	Sub    *precedenceExpr `| "(" @@ ")"`
+}
+
+func (e *precedenceExpr) String() string {
+	switch {
+	case e.Number != nil:
+		return strconv.Itoa(*e.Number)
+	case e.Sub != nil:
+		return "(" + e.Sub.String() + ")"
+	default:
+		return "[" + e.Left.String() + " " + e.Op + " " + e.Right.String() + "]"
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	type statement struct {
+		Name string          `@Ident "="`
+		Expr *precedenceExpr `@@ ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	operators := []Operator{
+		{Op: "+", Precedence: 1},
+		{Op: "-", Precedence: 1},
+		{Op: "*", Precedence: 2},
+		{Op: "/", Precedence: 2},
+		{Op: "^", Precedence: 3, Right: true},
+	}
+	for _, options := range [][]Option{{}, {UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, append(options, Precedence(&precedenceExpr{}, operators...))...)
+		actual := &grammar{}
+		err := p.ParseString(`a = 1 + 2 * 3 - 4; b = 2 ^ 3 ^ 2 / (1 + 1); c = 1;`, actual)
+		require.NoError(t, err)
+		exprs := []string{}
+		for _, statement := range actual.Statements {
+			exprs = append(exprs, statement.Expr.String())
+		}
+		require.Equal(t, []string{
+			"[[1 + [2 * 3]] - 4]",
+			"[[2 ^ [3 ^ 2]] / ([1 + 1])]",
+			"1",
+		}, exprs)
+
+		err = p.ParseString(`a = 1 + ;`, &grammar{})
+		require.EqualError(t, err, `<source>:1:7: expected expression after "+"`)
+
+		// Strings are not operators.
+		err = p.ParseString(`a = 1 "+" 2;`, &grammar{})
+		require.EqualError(t, err, `<source>:1:7: unexpected "+" (expected ";")`)
+	}
+
+	_, err := Build(&grammar{}, Precedence(&statement{}, operators...))
+	require.EqualError(t, err, `Precedence() requires participle.statement to have a field Left of type *participle.statement`)
+}
+
+func TestCaptureBigNumbers(t *testing.T) {
+	type grammar struct {
+		Int    *big.Int   `@(["-"] Int)`
+		Float  big.Float  `@Float`
+		Values []*big.Int `{ @(Int | Ident) }`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`-123456789012345678901234567890 3.14159265358979323846264338327950288 0xFFFFFFFFFFFFFFFFFFFF 0b101`, actual)
+	require.NoError(t, err)
+	require.Equal(t, "-123456789012345678901234567890", actual.Int.String())
+	require.Equal(t, "3.14159265358979323846264338327950288", actual.Float.Text('f', 35))
+	require.Len(t, actual.Values, 2)
+	require.Equal(t, "1208925819614629174706175", actual.Values[0].String())
+	require.Equal(t, "5", actual.Values[1].String())
+
+	err = p.ParseString(`1 2.5 10 abc`, &grammar{})
+	require.EqualError(t, err, `<source>:1:10: participle.grammar.Values: invalid integer "abc"`)
+	lerr := &lexer.Error{}
+	require.True(t, errors.As(err, &lerr))
+	require.Equal(t, 10, lerr.Pos.Column)
+}
+
+func TestNullableRepetition(t *testing.T) {
+	type optionals struct {
+		A string `[ @"a" ]`
+		B string `[ @"b" ]`
+	}
+	type grammar struct {
+		Items []*optionals `{ @@ }`
+	}
+	_, err := Build(&grammar{})
+	require.EqualError(t, err, `grammar: repetition body may match empty input: ( [ "a" ] [ "b" ] )`)
+
+	type direct struct {
+		Items []string `{ [ @Ident ] ";" | [ @Int ] }`
+	}
+	_, err = Build(&direct{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "repetition body may match empty input")
+
+	type nonEmpty struct {
+		Items []*optionals `{ "(" @@ ")" }`
+	}
+	_, err = Build(&nonEmpty{})
+	require.NoError(t, err)
+}
+
+func TestAnnotate(t *testing.T) {
+	type statement struct {
+		Name  string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{},
+		Annotate("statement", "boundary"),
+		Annotate("statement.Value", 42))
+
+	annotations := []Annotation{}
+	err := p.ParseString("a = 1;\nb = 2;", &grammar{}, WithAnnotationHook(func(annotation Annotation) {
+		annotations = append(annotations, annotation)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []Annotation{
+		{Rule: "statement", Field: "Value", Pos: lexer.Position{Filename: "", Offset: 4, Line: 1, Column: 5}, Metadata: 42},
+		{Rule: "statement", Pos: lexer.Position{Filename: "", Offset: 0, Line: 1, Column: 1}, Metadata: "boundary"},
+		{Rule: "statement", Field: "Value", Pos: lexer.Position{Filename: "", Offset: 11, Line: 2, Column: 5}, Metadata: 42},
+		{Rule: "statement", Pos: lexer.Position{Filename: "", Offset: 7, Line: 2, Column: 1}, Metadata: "boundary"},
+	}, annotations)
+
+	root := p.Grammar()
+	stmt := root.Children[0].Children[0].Children[0]
+	require.Equal(t, GrammarStruct, stmt.Kind)
+	require.Equal(t, "boundary", stmt.Metadata)
+	require.Equal(t, 42, stmt.Children[0].Children[2].Metadata)
+
+	_, err = Build(&grammar{}, Annotate("statement.Missing", true))
+	require.EqualError(t, err, `annotation for unknown struct or captured field "statement.Missing"`)
+}
+
+func TestCaptureElementGroup(t *testing.T) {
+	type pair struct {
+		Pos   lexer.Position
+		Key   string
+		Value []int
+	}
+	type grammar struct {
+		Pairs []pair  `{ @( @Ident -> Key "=" @Int -> Value { "," @Int -> Value } ) ";" }`
+		Last  *pair   `"!" @( @Ident -> Key )`
+		Refs  []*pair `{ @( "&" @Ident -> Key ) }`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`a = 1; b = 2, 3; ! c &d &e`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Pairs: []pair{
+			{Pos: lexer.Position{Offset: 0, Line: 1, Column: 1}, Key: "a", Value: []int{1}},
+			{Pos: lexer.Position{Offset: 7, Line: 1, Column: 8}, Key: "b", Value: []int{2, 3}},
+		},
+		Last: &pair{Pos: lexer.Position{Offset: 19, Line: 1, Column: 20}, Key: "c"},
+		Refs: []*pair{
+			{Pos: lexer.Position{Offset: 21, Line: 1, Column: 22}, Key: "d"},
+			{Pos: lexer.Position{Offset: 24, Line: 1, Column: 25}, Key: "e"},
+		},
+	}, actual)
+
+	type untargeted struct {
+		Pairs []pair `{ @( @Ident "=" @Int -> Value ) }`
+	}
+	_, err = Build(&untargeted{})
+	require.EqualError(t, err, `Pairs: captures within @( ... ) must name a field of participle.pair with -> <field>`)
+}
+
+func TestRecover(t *testing.T) {
+	type statement struct {
+		Name  string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{}, Recover(";"))
+	actual := &grammar{}
+	err := p.ParseString(`a = 1; b = ; c = 3; 4; d = 5;`, actual)
+	require.Equal(t, &grammar{Statements: []*statement{{"a", 1}, {"c", 3}, {"d", 5}}}, actual)
+	errs, ok := err.(RecoveredErrors)
+	require.True(t, ok, "%T", err)
+	require.Len(t, errs, 2)
+	require.EqualError(t, err, `<source>:1:12: unexpected ";" (expected <int>); `+
+		`<source>:1:21: expected <ident> but got "4"`)
+
+	actual = &grammar{}
+	err = p.ParseString(`a = 1; b = 2`, actual)
+	require.Equal(t, &grammar{Statements: []*statement{{"a", 1}}}, actual)
+	require.EqualError(t, err, `<source>:1:13: unexpected "<EOF>" (expected ";")`)
+
+	err = p.ParseString(`a = 1;`, &grammar{})
+	require.NoError(t, err)
+
+	// Strings are not sync tokens.
+	actual = &grammar{}
+	err = p.ParseString(`a = ";" b = 2; c = 3;`, actual)
+	require.Equal(t, &grammar{Statements: []*statement{{"c", 3}}}, actual)
+	require.EqualError(t, err, `<source>:1:5: unexpected ";" (expected <int>)`)
+
+	type sequence struct {
+		Statements []*statement `{ @@ } "."`
+	}
+	_, err = Build(&sequence{}, Recover(";"))
+	require.EqualError(t, err, `Recover() requires the grammar *participle.sequence to be a single repetition, eg. "{ @@ }"`)
+	_, err = Build(&grammar{}, Recover())
+	require.EqualError(t, err, `at least one sync token must be provided to Recover()`)
+}
+
+func TestMaxErrors(t *testing.T) {
+	type statement struct {
+		Name  string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{}, Recover(";"), MaxErrors(2))
+	actual := &grammar{}
+	err := p.ParseString(`a = 1; 2; b = 3; 4; 5; c = 6;`, actual)
+	require.Equal(t, &grammar{Statements: []*statement{{"a", 1}, {"b", 3}}}, actual)
+	errs, ok := err.(RecoveredErrors)
+	require.True(t, ok, "%T", err)
+	require.Len(t, errs, 3)
+	require.True(t, errors.Is(err, ErrTooManyErrors))
+	require.EqualError(t, err, `<source>:1:8: expected <ident> but got "2"; `+
+		`<source>:1:18: expected <ident> but got "4"; too many errors`)
+
+	// Errors are only truncated if there are more than the limit.
+	err = p.ParseString(`a = 1; 2; b = ; c = 3;`, &grammar{})
+	require.Len(t, err.(RecoveredErrors), 2)
+	require.False(t, errors.Is(err, ErrTooManyErrors))
+
+	_, err = Build(&grammar{}, MaxErrors(2))
+	require.EqualError(t, err, `MaxErrors() requires Recover()`)
+	_, err = Build(&grammar{}, Recover(";"), MaxErrors(0))
+	require.EqualError(t, err, `MaxErrors() requires a positive limit, not 0`)
+}
+
+func TestCapturePointerToScalar(t *testing.T) {
+	type grammar struct {
+		Int    *int     `[ @Int ]`
+		String *string  `[ "s" @String ]`
+		Float  *float64 `[ "f" @Float ]`
+	}
+	p := mustTestParser(t, &grammar{})
+
+	zero := &grammar{}
+	err := p.ParseString(`0 s "" f 0.0`, zero)
+	require.NoError(t, err)
+	require.NotNil(t, zero.Int)
+	require.NotNil(t, zero.String)
+	require.NotNil(t, zero.Float)
+	require.Equal(t, 0, *zero.Int)
+	require.Equal(t, "", *zero.String)
+	require.Equal(t, 0.0, *zero.Float)
+
+	nonZero := &grammar{}
+	err = p.ParseString(`42 s "hello" f 1.5`, nonZero)
+	require.NoError(t, err)
+	require.Equal(t, 42, *nonZero.Int)
+	require.Equal(t, "hello", *nonZero.String)
+	require.Equal(t, 1.5, *nonZero.Float)
+
+	absent := &grammar{}
+	err = p.ParseString(``, absent)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{}, absent)
+}
+
+func TestWithBasePosition(t *testing.T) {
+	type entry struct {
+		Pos   lexer.Position
+		Key   string `@Ident "="`
+		Value string `@=Int`
+	}
+	type grammar struct {
+		Entries []*entry `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{})
+	base := lexer.Position{Filename: "doc.md", Offset: 100, Line: 10, Column: 5}
+	actual := &grammar{}
+	err := p.ParseString("a = 1\nb = 2", actual, WithBasePosition(base))
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Entries: []*entry{
+		{Pos: lexer.Position{Filename: "doc.md", Offset: 100, Line: 10, Column: 5}, Key: "a", Value: "1"},
+		{Pos: lexer.Position{Filename: "doc.md", Offset: 106, Line: 11, Column: 1}, Key: "b", Value: "2"},
+	}}, actual)
+
+	err = p.ParseString("a = 1\nb = c", &grammar{}, WithBasePosition(base))
+	require.EqualError(t, err, `doc.md:11:5: unexpected "c" (expected <int>)`)
+
+	type words struct {
+		Words []string `{ @Ident }`
+	}
+	p = mustTestParser(t, &words{}, Lexer(lexer.Must(lexer.Regexp(`(?P<Ident>\w+)|(\s+)`))))
+	err = p.ParseString("a b $", &words{}, WithBasePosition(base))
+	require.EqualError(t, err, `doc.md:10:9: invalid token '$'`)
+}
+
+func TestIndentedBlocks(t *testing.T) {
+	type statement struct {
+		Name  string       `@Ident`
+		Block []*statement `[ ":" Indent { @@ } Dedent ]`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	def, err := lexer.Indentation(lexer.Must(lexer.Regexp(`(?P<Ident>\w+)|(?P<Punct>[:;])|(\s+)`)))
+	require.NoError(t, err)
+	source := `
+a:
+  b
+  c:
+    d
+e
+`
+	expected := &grammar{Statements: []*statement{
+		{Name: "a", Block: []*statement{{Name: "b"}, {Name: "c", Block: []*statement{{Name: "d"}}}}},
+		{Name: "e"},
+	}}
+	for _, options := range [][]Option{{Lexer(def)}, {Lexer(def), UseLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err = p.ParseString(source, actual)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+
+		err = p.ParseString("a:\n  b\n  ;\n", &grammar{})
+		require.EqualError(t, err, `<source>:3:3: unexpected ";" (expected <dedent>)`)
+	}
+}
+
+func TestRepetitionRemainderFallsThrough(t *testing.T) {
+	type semi struct {
+		Names []string `{ @Ident } ";"`
+	}
+	type dot struct {
+		Names []string `{ @Ident } "."`
+	}
+	type grammar struct {
+		S *semi `  @@`
+		D *dot  `| @@`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`a b .`, actual)
+	require.NoError(t, err)
+	require.NotNil(t, actual.D)
+}
+
+func TestCaptureLiteralsIntoSlice(t *testing.T) {
+	type grammar struct {
+		Flags []string `{ @( "--verbose" | "--force" | "-v" ) }`
+		Args  []string `{ @Ident }`
+	}
+	def := lexer.Must(lexer.Regexp(`(?P<Flag>--?\w+)|(?P<Ident>\w+)|(\s+)`))
+	p := mustTestParser(t, &grammar{}, Lexer(def))
+	actual := &grammar{}
+	err := p.ParseString(`--force --verbose -v --force a b`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Flags: []string{"--force", "--verbose", "-v", "--force"},
+		Args:  []string{"a", "b"},
+	}, actual)
+
+	actual = &grammar{}
+	err = p.ParseString(`a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Args: []string{"a"}}, actual)
+}
+
+func TestOnStruct(t *testing.T) {
+	type declaration struct {
+		Name  string `"var" @Ident`
+		Value int    `"=" @Int ";"`
+	}
+	type grammar struct {
+		Declarations []*declaration `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{})
+	errDuplicate := errors.New("duplicate declaration")
+	check := func() ParseOption {
+		seen := map[string]bool{}
+		return OnStruct(func(v interface{}, pos lexer.Position) error {
+			if decl, ok := v.(*declaration); ok {
+				if seen[decl.Name] {
+					return fmt.Errorf("%q: %w", decl.Name, errDuplicate)
+				}
+				seen[decl.Name] = true
+			}
+			return nil
+		})
+	}
+
+	actual := &grammar{}
+	err := p.ParseString(`var a = 1; var b = 2;`, actual, check())
+	require.NoError(t, err)
+	require.Len(t, actual.Declarations, 2)
+
+	err = p.ParseString(`var a = 1; var a = 2; var ! = 3;`, &grammar{}, check())
+	require.EqualError(t, err, `<source>:1:12: "a": duplicate declaration`)
+	require.True(t, errors.Is(err, errDuplicate))
+	hookErr := &HookError{}
+	require.True(t, errors.As(err, &hookErr))
+	require.Equal(t, lexer.Position{Offset: 11, Line: 1, Column: 12}, hookErr.Pos)
+
+	err = p.ParseString(`var a = 1;`, &grammar{}, OnStruct(func(v interface{}, pos lexer.Position) error {
+		if _, ok := v.(*declaration); ok {
+			return lexer.Errorf(pos, "not allowed")
+		}
+		return nil
+	}))
+	require.EqualError(t, err, `<source>:1:1: not allowed`)
+}
+
+func TestErrorMessage(t *testing.T) {
+	type statement struct {
+		Name  string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{},
+		ErrorMessage("statement", ";", "missing semicolon at end of statement"),
+		ErrorMessage("statement", "Int", "assigned value must be an integer"))
+	err := p.ParseString(`a = 1; b = 2`, &grammar{})
+	require.EqualError(t, err, `<source>:1:13: missing semicolon at end of statement`)
+	err = p.ParseString(`a = b;`, &grammar{})
+	require.EqualError(t, err, `<source>:1:5: assigned value must be an integer`)
+	err = p.ParseString(`a 1;`, &grammar{})
+	require.EqualError(t, err, `<source>:1:3: unexpected "1" (expected "=")`)
+
+	_, err = Build(&grammar{}, ErrorMessage("statement", ":", "missing colon"))
+	require.EqualError(t, err, `error message for unknown literal or token type ":" in "statement"`)
+}
+
+func TestWithNumberFormat(t *testing.T) {
+	type grammar struct {
+		Float  float64   `@Number`
+		Int    int       `@Number`
+		Floats []float32 `{ @Number }`
+	}
+	def := lexer.Must(lexer.Regexp(`(?P<Number>[-+]?[\d.,]+)|(\s+)`))
+	p := mustTestParser(t, &grammar{}, Lexer(def), WithNumberFormat(',', '.'))
+	actual := &grammar{}
+	err := p.ParseString(`1.234,56 1.234.567 1234,5 -0,25 12`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Float: 1234.56, Int: 1234567, Floats: []float32{1234.5, -0.25, 12}}, actual)
+
+	err = p.ParseString(`1.5 1`, &grammar{})
+	require.EqualError(t, err, `<source>:1:1: participle.grammar.Float: invalid number "1.5": misplaced grouping separator`)
+	err = p.ParseString(`1,5,0 1`, &grammar{})
+	require.EqualError(t, err, `<source>:1:1: participle.grammar.Float: invalid number "1,5,0": multiple decimal separators`)
+	err = p.ParseString(`1,500.000 1`, &grammar{})
+	require.EqualError(t, err, `<source>:1:1: participle.grammar.Float: invalid number "1,500.000": grouping separator after decimal separator`)
+	err = p.ParseString(`1 1,5`, &grammar{})
+	require.Error(t, err)
+
+	p = mustTestParser(t, &grammar{}, Lexer(def), WithNumberFormat(',', 0))
+	actual = &grammar{}
+	err = p.ParseString(`0,5 1234`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Float: 0.5, Int: 1234}, actual)
+
+	_, err = Build(&grammar{}, Lexer(def), WithNumberFormat(',', ','))
+	require.EqualError(t, err, `invalid number format with decimal separator ',' and grouping separator ','`)
+}
+
+func TestInjectIndex(t *testing.T) {
+	type item struct {
+		Index int
+		Name  string `@Ident`
+	}
+	type indexed struct {
+		Index int `@Int`
+	}
+	type grammar struct {
+		Items    []item     `{ @@ } ";"`
+		Pointers []*item    `{ @@ } ";"`
+		Captured []*indexed `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`a b c; d e; 5 7`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Items:    []item{{0, "a"}, {1, "b"}, {2, "c"}},
+		Pointers: []*item{{0, "d"}, {1, "e"}},
+		Captured: []*indexed{{5}, {7}},
+	}, actual)
+}
+
+type genericList[T any] struct {
+	Items []T `"[" [ @@ { "," @@ } ] "]"`
+}
+
+type genericPair[K, V any] struct {
+	Key   K `@Ident "="`
+	Value V `@@`
+}
+
+type genericNumber struct {
+	Value int `@Int`
+}
+
+func TestGenericGrammar(t *testing.T) {
+	type grammar struct {
+		Pairs []*genericPair[string, genericList[genericNumber]] `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`a = [1, 2] b = []`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Pairs: []*genericPair[string, genericList[genericNumber]]{
+		{Key: "a", Value: genericList[genericNumber]{Items: []genericNumber{{1}, {2}}}},
+		{Key: "b", Value: genericList[genericNumber]{}},
+	}}, actual)
+
+	err = p.ParseString(`a = [1, x]`, &grammar{})
+	require.EqualError(t, err, `<source>:1:9: unexpected "x" (expected <int>)`)
+	require.Equal(t, []string{`"["`}, p.FirstSets()["genericList[genericNumber]"])
+}
+
+func TestStrictCaptures(t *testing.T) {
+	type item struct {
+		Value int `@Int`
+	}
+	type grammar struct {
+		Number *int   `{ @Int }`
+		Values []int  `{ "," @Int }`
+		Label  string `[ ":" @Ident @Ident ]`
+		Count  int    `{ @#"!" }`
+		Item   *item  `[ "=" @@ ]`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`1 2 , 1 , 2 : x y ! !`, actual)
+	require.NoError(t, err)
+	require.Equal(t, 2, *actual.Number)
+
+	p = mustTestParser(t, &grammar{}, StrictCaptures())
+	err = p.ParseString(`1 2`, &grammar{})
+	require.EqualError(t, err, `<source>:1:3: field Number captured multiple times`)
+
+	actual = &grammar{}
+	err = p.ParseString(`1 , 1 , 2 : x y ! ! = 3`, actual)
+	require.NoError(t, err)
+	require.Equal(t, 1, *actual.Number)
+	require.Equal(t, []int{1, 2}, actual.Values)
+	require.Equal(t, "xy", actual.Label)
+	require.Equal(t, 2, actual.Count)
+	require.Equal(t, &item{Value: 3}, actual.Item)
+
+	type backtracked struct {
+		Value int `( @Int ";" | @Int "!" )`
+	}
+	for _, option := range []Option{NoLookahead(), LongestMatch()} {
+		p = mustTestParser(t, &backtracked{}, StrictCaptures(), option)
+		err = p.ParseString(`1 !`, &backtracked{})
+		require.NoError(t, err)
+	}
+
+	type repeated struct {
+		Value int `{ @Int ";" }`
+	}
+	p = mustTestParser(t, &repeated{}, StrictCaptures())
+	err = p.ParseString(`1;`, &repeated{})
+	require.NoError(t, err)
+	err = p.ParseString(`1; 2;`, &repeated{})
+	require.EqualError(t, err, `<source>:1:4: field Value captured multiple times`)
+}
+
+func TestColumn(t *testing.T) {
+	type field struct {
+		Name  string `  #3 @Ident`
+		Value string `| #5-8 @Ident`
+	}
+	type record struct {
+		Key     string   `#1 @Ident`
+		Comment *string  `[ #10-20 @Ident ]`
+		Fields  []*field `{ @@ }`
+	}
+	type grammar struct {
+		Records []*record `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString("a        c\n  n\n    x\nb\n       y", actual)
+	require.NoError(t, err)
+	comment := "c"
+	expected := &grammar{Records: []*record{
+		{Key: "a", Comment: &comment, Fields: []*field{{Name: "n"}, {Value: "x"}}},
+		{Key: "b", Fields: []*field{{Value: "y"}}},
+	}}
+	require.Equal(t, expected, actual)
+
+	err = p.ParseString("a\n x", &grammar{})
+	require.EqualError(t, err, `<source>:2:2: expected ( #1 <ident> ) but got "x"`)
+
+	_, err = Build(&struct {
+		A string `#8-4 @Ident`
+	}{})
+	require.EqualError(t, err, `A: invalid column range 8-4`)
+}
+
+func TestEpsilon(t *testing.T) {
+	type grammar struct {
+		Modifier string `( @"public" | @"private" | ε )`
+		Name     string `@Ident`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`private a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Modifier: "private", Name: "a"}, actual)
+
+	actual = &grammar{}
+	err = p.ParseString(`a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Name: "a"}, actual)
+
+	p = mustTestParser(t, &grammar{}, NoLookahead())
+	actual = &grammar{}
+	err = p.ParseString(`a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Name: "a"}, actual)
+
+	_, err = Build(&struct {
+		A string `( ε | @Ident )`
+	}{})
+	require.EqualError(t, err, `A: ε must be the last alternative of a disjunction`)
+
+	_, err = Build(&struct {
+		A []string `{ @Ident | ε }`
+	}{})
+	require.Error(t, err)
+}
+
+// Each alternative reparses the nested term before failing, so parsing backtracks exponentially
+// without memoization.
+type memoTerm struct {
+	Plus  *memoTerm `  "(" @@ ")" "+"`
+	Minus *memoTerm `| "(" @@ ")" "-"`
+	Name  string    `| @Ident`
+}
+
+func memoInput(depth int) string {
+	return strings.Repeat("(", depth) + "a" + strings.Repeat(")-", depth)
+}
+
+func BenchmarkMemoization(b *testing.B) {
+	p, err := Build(&memoTerm{}, NoLookahead())
+	require.NoError(b, err)
+	input := memoInput(12)
+	b.Run("Backtracking", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = p.ParseString(input, &memoTerm{})
+		}
+	})
+	b.Run("Memoized", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = p.ParseString(input, &memoTerm{}, WithMemoization())
+		}
+	})
+}
+
+func TestFindNode(t *testing.T) {
+	type argument struct {
+		Pos    lexer.Position
+		EndPos lexer.Position
+		Name   string `@Ident`
+	}
+	type call struct {
+		Pos       lexer.Position
+		EndPos    lexer.Position
+		Function  string      `@Ident "("`
+		Arguments []*argument `[ @@ { "," @@ } ] ")"`
+	}
+	type statement struct {
+		Call *call `@@ ";"`
+	}
+	type program struct {
+		Pos        lexer.Position
+		EndPos     lexer.Position
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &program{})
+	ast := &program{}
+	err := p.ParseString("f(a, b);\ng(c);", ast)
+	require.NoError(t, err)
+
+	path, node := FindNode(ast, 5)
+	require.Equal(t, []string{"program", "call", "argument"}, path)
+	require.Equal(t, ast.Statements[0].Call.Arguments[1], node)
+
+	path, node = FindNode(ast, 9)
+	require.Equal(t, []string{"program", "call"}, path)
+	require.Equal(t, ast.Statements[1].Call, node)
+
+	path, node = FindNode(ast, 100)
+	require.Nil(t, path)
+	require.Nil(t, node)
+}
+
+func TestCaptureIntoChannel(t *testing.T) {
+	type record struct {
+		Key   string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type grammar struct {
+		Records chan *record `{ @@ }`
+	}
+	p := mustTestParser(t, &grammar{})
+	collect := func(input string) ([]*record, error) {
+		actual := &grammar{Records: make(chan *record)}
+		errs := make(chan error, 1)
+		go func() { errs <- p.ParseString(input, actual) }()
+		records := []*record{}
+		for r := range actual.Records {
+			records = append(records, r)
+		}
+		return records, <-errs
+	}
+	records, err := collect(`a = 1; b = 2;`)
+	require.NoError(t, err)
+	require.Equal(t, []*record{{Key: "a", Value: 1}, {Key: "b", Value: 2}}, records)
+
+	// The channel is closed if parsing fails, after the elements preceding the error.
+	records, err = collect(`a = 1; b = ;`)
+	require.EqualError(t, err, `<source>:1:12: unexpected ";" (expected <int>)`)
+	require.Equal(t, []*record{{Key: "a", Value: 1}}, records)
+
+	err = p.ParseString(`a = 1;`, &grammar{})
+	require.EqualError(t, err, `<source>:1:1: participle.grammar.Records: channel is nil`)
+
+	type nested struct {
+		Values chan int `{ @Int }`
+	}
+	_, err = Build(&struct {
+		Nested *nested `@@`
+	}{})
+	require.EqualError(t, err, `nested.Values: channel fields can only be captured into by the root struct`)
+}
+
+func TestCaptureIntoChannelWhileBacktracking(t *testing.T) {
+	type grammar struct {
+		Names chan string `  { @Ident } ";" | { @Ident } "."`
+	}
+	for _, options := range [][]Option{{NoLookahead()}, {LongestMatch()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{Names: make(chan string, 10)}
+		err := p.ParseString(`a b .`, actual)
+		require.NoError(t, err)
+		names := []string{}
+		for name := range actual.Names {
+			names = append(names, name)
+		}
+		require.Equal(t, []string{"a", "b"}, names)
+	}
+}
+
+func TestSeparatedRepetition(t *testing.T) {
+	type term struct {
+		Name string `@Ident`
+	}
+	type expression struct {
+		Terms     []*term `{ @@ / ( "+" | "-" ) -> Operators }`
+		Operators []string
+	}
+	p := mustTestParser(t, &expression{})
+	actual := &expression{}
+	err := p.ParseString(`a + b - c`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &expression{
+		Terms:     []*term{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+		Operators: []string{"+", "-"},
+	}, actual)
+
+	actual = &expression{}
+	err = p.ParseString(`a`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &expression{Terms: []*term{{Name: "a"}}}, actual)
+
+	err = p.ParseString(``, &expression{})
+	require.NoError(t, err)
+
+	p = mustTestParser(t, &expression{}, UseLookahead())
+	actual = &expression{}
+	err = p.ParseString(`a - b`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &expression{Terms: []*term{{Name: "a"}, {Name: "b"}}, Operators: []string{"-"}}, actual)
+
+	p = mustTestParser(t, &expression{})
+	err = p.ParseString(`a + b -`, &expression{})
+	require.EqualError(t, err, `<source>:1:8: unexpected "<EOF>" (expected <ident>)`)
+
+	type list struct {
+		Values []int `"[" { @Int / "," } "]"`
+	}
+	p = mustTestParser(t, &list{})
+	actualList := &list{}
+	err = p.ParseString(`[1, 2, 3]`, actualList)
+	require.NoError(t, err)
+	require.Equal(t, &list{Values: []int{1, 2, 3}}, actualList)
+
+	_, err = Build(&struct {
+		A []string `{ @Ident / }`
+	}{})
+	require.EqualError(t, err, `A: expected separator after /`)
+}
+
+func TestRequire(t *testing.T) {
+	type function struct {
+		Name string `"func" @Ident "(" ")"`
+	}
+	type grammar struct {
+		Functions []*function `{ @@ }`
+	}
+	isMain := func(node interface{}) bool {
+		f, ok := node.(*function)
+		return ok && f.Name == "main"
+	}
+	p := mustTestParser(t, &grammar{}, Require(isMain, "missing main function"))
+	actual := &grammar{}
+	err := p.ParseString(`func init() func main()`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Functions: []*function{{Name: "init"}, {Name: "main"}}}, actual)
+
+	err = p.ParseString(`func init()`, &grammar{})
+	require.EqualError(t, err, `missing main function`)
+
+	visited := []interface{}{}
+	p = mustTestParser(t, &grammar{}, Require(func(node interface{}) bool {
+		visited = append(visited, node)
+		return false
+	}, "unsatisfiable"), Require(isMain, "missing main function"))
+	actual = &grammar{}
+	err = p.ParseString(`func main()`, actual)
+	require.EqualError(t, err, `unsatisfiable`)
+	require.Equal(t, []interface{}{actual, actual.Functions[0]}, visited)
+}
+
+func TestNegation(t *testing.T) {
+	type block struct {
+		Name string   `"begin" @Ident`
+		Body []string `@~( "end" | "begin" )`
+		Next *block   `[ @@ ]`
+		Tail []string `@~"end" "end"`
+	}
+	type grammar struct {
+		Blocks []*block `{ @@ }`
+		Rest   string   `@~"begin"`
+	}
+	p := mustTestParser(t, &grammar{})
+	actual := &grammar{}
+	err := p.ParseString(`begin a x + 1 begin b y end z end trailing tokens`, actual)
+	require.NoError(t, err)
+	expected := &grammar{
+		Blocks: []*block{{
+			Name: "a",
+			Body: []string{"x", "+", "1"},
+			Next: &block{Name: "b", Body: []string{"y"}},
+			Tail: []string{"z"},
+		}},
+		Rest: "trailingtokens",
+	}
+	require.Equal(t, expected, actual)
+
+	actual = &grammar{}
+	err = p.ParseString(`begin a end begin b`, actual)
+	require.EqualError(t, err, `<source>:1:20: unexpected "<EOF>" (expected "end")`)
+
+	_, err = Build(&struct {
+		Tokens []string `{ ~"end" } "end"`
+	}{})
+	require.Contains(t, err.Error(), `repetition body may match empty input: ( ~"end" )`)
+}
+
+func TestDeprecate(t *testing.T) {
+	type assignment struct {
+		Name  string `( "var" | @"let" ) @Ident "="`
+		Value int    `@Int`
+	}
+	type grammar struct {
+		Assignments []*assignment `{ @@ }`
+		Legacy      string        `[ "print" @Ident ]`
+	}
+	p := mustTestParser(t, &grammar{},
+		Deprecate("assignment", "assignment is deprecated"),
+		Deprecate("grammar.Legacy", "print is deprecated, use log"))
+	warnings := []Warning{}
+	err := p.ParseString("let a = 1\nlet b = 2 print a", &grammar{}, WithWarnings(&warnings))
+	require.NoError(t, err)
+	require.Equal(t, []Warning{
+		{Pos: lexer.Position{Line: 1, Column: 1}, Message: "assignment is deprecated"},
+		{Pos: lexer.Position{Offset: 10, Line: 2, Column: 1}, Message: "assignment is deprecated"},
+		{Pos: lexer.Position{Offset: 26, Line: 2, Column: 17}, Message: "print is deprecated, use log"},
+	}, warnings)
+	require.Equal(t, "<source>:2:17: print is deprecated, use log", warnings[2].String())
+
+	type statement struct {
+		Assignment *assignment `  @@ ";"`
+		Name       string      `| "let" @Ident "="`
+		Value      int         `  @Int "!"`
+	}
+	type statements struct {
+		Statements []*statement `{ @@ }`
+	}
+	p = mustTestParser(t, &statements{}, NoLookahead(), Deprecate("assignment", "assignment is deprecated"))
+	warnings = nil
+	err = p.ParseString(`let a = 1 !`, &statements{}, WithWarnings(&warnings))
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	_, err = Build(&grammar{}, Deprecate("grammar.Missing", ""))
+	require.EqualError(t, err, `deprecation of unknown struct or captured field "grammar.Missing"`)
+}
+
+func TestParseWithFallback(t *testing.T) {
+	type pair struct {
+		Key   string `@Ident "="`
+		Value int    `@Int ";"`
+	}
+	type strict struct {
+		Pairs []*pair `{ @@ }`
+	}
+	type lenient struct {
+		Tokens []string `{ @Ident | @Int | @"=" | @";" }`
+	}
+	primary := mustTestParser(t, &strict{})
+	fallback := mustTestParser(t, &lenient{})
+
+	v, err := ParseWithFallback(primary, fallback, strings.NewReader(`a = 1;`))
+	require.NoError(t, err)
+	require.IsType(t, &strict{}, v)
+	require.Equal(t, "a", v.(*strict).Pairs[0].Key)
+
+	v, err = ParseWithFallback(primary, fallback, strings.NewReader(`a = ;`))
+	require.NoError(t, err)
+	require.Equal(t, &lenient{Tokens: []string{"a", "=", ";"}}, v)
+
+	v, err = ParseWithFallback(primary, fallback, strings.NewReader(`a = "b"`))
+	require.Nil(t, v)
+	require.EqualError(t, err, `<source>:1:5: unexpected "b" (expected <int>) (fallback: <source>:1:5: expected ( <ident> | <int> | "=" | ";" ) but got "b")`)
+	require.IsType(t, &FallbackError{}, err)
+}
+
+func TestConvert(t *testing.T) {
+	type duration time.Duration
+	type version struct {
+		Major, Minor int
+	}
+	parseDuration := func(tokens []lexer.Token) (interface{}, error) {
+		d, err := time.ParseDuration(tokens[0].Value + tokens[1].Value)
+		if err != nil {
+			return nil, lexer.Errorf(tokens[1].Pos, "invalid duration unit %q", tokens[1].Value)
+		}
+		return duration(d), nil
+	}
+	parseVersion := ValuesConverter(func(values []string) (interface{}, error) {
+		major, _ := strconv.Atoi(values[0])
+		minor, _ := strconv.Atoi(values[2])
+		if major == 0 && minor == 0 {
+			return nil, fmt.Errorf("invalid version 0.0")
+		}
+		return version{Major: major, Minor: minor}, nil
+	})
+	type grammar struct {
+		Timeout  duration   `"timeout" @(Int Ident)`
+		Retries  []duration `{ "retry" @(Int Ident) }`
+		Version  version    `"version" @(Int "." Int)`
+		Requires *version   `[ "requires" @(Int "." Int) ]`
+	}
+	p := mustTestParser(t, &grammar{}, Convert(duration(0), parseDuration), Convert(version{}, parseVersion))
+	actual := &grammar{}
+	err := p.ParseString(`timeout 5 s retry 1 ms retry 2 ms version 1 . 2 requires 0 . 9`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Timeout:  duration(5 * time.Second),
+		Retries:  []duration{duration(time.Millisecond), duration(2 * time.Millisecond)},
+		Version:  version{Major: 1, Minor: 2},
+		Requires: &version{Minor: 9},
+	}, actual)
+
+	err = p.ParseString(`timeout 5 parsecs version 1 . 2`, &grammar{})
+	require.EqualError(t, err, `<source>:1:11: invalid duration unit "parsecs"`)
+	err = p.ParseString(`timeout 5 s version 0 . 0`, &grammar{})
+	require.EqualError(t, err, `<source>:1:21: invalid version 0.0`)
+}
+
+func TestRegisterStructConverter(t *testing.T) {
+	type rgb struct {
+		R, G, B uint8
+	}
+	type grammar struct {
+		Fill    rgb    `"fill" @Colour`
+		Palette []*rgb `{ "palette" @Colour }`
+	}
+	convert := func(tokens []lexer.Token, dst reflect.Value) error {
+		value, err := strconv.ParseUint(tokens[0].Value[1:], 16, 32)
+		if err != nil {
+			return lexer.Errorf(tokens[0].Pos, "invalid colour %q", tokens[0].Value)
+		}
+		for i, field := range []string{"R", "G", "B"} {
+			dst.FieldByName(field).SetUint((value >> (16 - 8*i)) & 0xff)
+		}
+		return nil
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Ident>[a-z]+)|(?P<Colour>#[0-9a-zA-Z]{6})`))
+	p := mustTestParser(t, &grammar{}, Lexer(lex), RegisterStructConverter(reflect.TypeOf(rgb{}), convert))
+	actual := &grammar{}
+	err := p.ParseString(`fill #FF00AA palette #000000 palette #10203f`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Fill:    rgb{R: 0xff, G: 0x00, B: 0xaa},
+		Palette: []*rgb{{}, {R: 0x10, G: 0x20, B: 0x3f}},
+	}, actual)
+
+	err = p.ParseString(`fill #GG0000`, &grammar{})
+	require.EqualError(t, err, `<source>:1:6: invalid colour "#GG0000"`)
+
+	_, err = Build(&grammar{}, RegisterStructConverter(reflect.TypeOf(0), convert))
+	require.EqualError(t, err, `RegisterStructConverter() requires a struct type, not int`)
+}
+
+// An immutable value type, which can only be constructed by parseCelsius().
+type celsius struct {
+	degrees float64
+}
+
+func parseCelsius(value string) (interface{}, error) {
+	degrees, err := strconv.ParseFloat(strings.TrimSuffix(value, "C"), 64)
+	if err != nil || degrees < -273.15 {
+		return nil, fmt.Errorf("invalid temperature %q", value)
+	}
+	return celsius{degrees}, nil
+}
+
+func (c celsius) String() string { return strconv.FormatFloat(c.degrees, 'f', -1, 64) + "C" }
+
+func TestRegisterFactory(t *testing.T) {
+	type grammar struct {
+		Low      celsius      `"low" @Temperature`
+		Readings []*celsius   `{ "reading" @Temperature }`
+		Label    fmt.Stringer `[ "label" @Temperature ]`
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Ident>[a-z]+)|(?P<Temperature>-?[0-9.]+C)`))
+	p := mustTestParser(t, &grammar{}, Lexer(lex),
+		RegisterFactory(reflect.TypeOf(celsius{}), parseCelsius),
+		RegisterFactory(reflect.TypeOf((*fmt.Stringer)(nil)).Elem(), parseCelsius))
+	actual := &grammar{}
+	err := p.ParseString(`low -5C reading 10.5C reading 0C label 20C`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{
+		Low:      celsius{-5},
+		Readings: []*celsius{{10.5}, {0}},
+		Label:    celsius{20},
+	}, actual)
+	require.Equal(t, "20C", actual.Label.String())
+
+	err = p.ParseString(`low -300C`, &grammar{})
+	require.EqualError(t, err, `<source>:1:5: invalid temperature "-300C"`)
+
+	p = mustTestParser(t, &grammar{}, Lexer(lex), RegisterFactory(reflect.TypeOf(celsius{}), func(value string) (interface{}, error) {
+		return value, nil
+	}))
+	err = p.ParseString(`low 5C`, &grammar{})
+	require.EqualError(t, err, `<source>:1:5: factory for participle.celsius returned string, which is not assignable to it`)
+
+	_, err = Build(&grammar{}, RegisterFactory(nil, parseCelsius))
+	require.EqualError(t, err, `RegisterFactory() requires a type`)
+}
+
+func TestOnRepeat(t *testing.T) {
+	type statement struct {
+		Name string `@Ident ";"`
+	}
+	type grammar struct {
+		Statements []*statement `{ @@ }`
+		Numbers    []int        `{ "," @Int }`
+		Last       string       `[ "!" @Ident ]`
+	}
+	statements := []interface{}{}
+	numbers := []interface{}{}
+	p := mustTestParser(t, &grammar{},
+		OnRepeat("grammar.Statements", func(element interface{}) { statements = append(statements, element) }),
+		OnRepeat("grammar.Numbers", func(element interface{}) { numbers = append(numbers, element) }))
+	actual := &grammar{}
+	err := p.ParseString(`a; b; , 1 , 2 ! c`, actual)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{actual.Statements[0], actual.Statements[1]}, statements)
+	require.Equal(t, []interface{}{1, 2}, numbers)
+
+	_, err = Build(&grammar{}, OnRepeat("grammar.Last", func(interface{}) {}))
+	require.EqualError(t, err, `OnRepeat() for "grammar.Last" requires a slice field`)
+	_, err = Build(&grammar{}, OnRepeat("grammar.Missing", func(interface{}) {}))
+	require.EqualError(t, err, `OnRepeat() for unknown captured field "grammar.Missing"`)
+}
+
+func TestMerge(t *testing.T) {
+	type config struct {
+		Host  string   `[ "host" "=" @String ]`
+		Port  int      `[ "port" "=" @Int ]`
+		Debug bool     `[ @"debug" ]`
+		Tags  []string `{ "tag" @Ident }`
+	}
+	defaults := func() *config {
+		return &config{Host: "localhost", Port: 80, Tags: []string{"a"}}
+	}
+	p := mustTestParser(t, &config{})
+	actual := defaults()
+	err := p.ParseString(`port = 8080`, actual, Merge())
+	require.NoError(t, err)
+	require.Equal(t, &config{Host: "localhost", Port: 8080, Tags: []string{"a"}}, actual)
+
+	// Captured fields are overwritten rather than appended to.
+	actual = defaults()
+	err = p.ParseString(`host = "example.com" debug tag b tag c`, actual, Merge())
+	require.NoError(t, err)
+	require.Equal(t, &config{Host: "example.com", Port: 80, Debug: true, Tags: []string{"b", "c"}}, actual)
+
+	// Without Merge() the target is replaced.
+	actual = defaults()
+	err = p.ParseString(`port = 8080`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &config{Port: 8080}, actual)
+
+	// A capture backtracked over restores the existing value.
+	type statement struct {
+		Name string `( @Ident ";" | @Ident "!" )`
+	}
+	p = mustTestParser(t, &statement{}, NoLookahead())
+	actualStatement := &statement{Name: "default"}
+	err = p.ParseString(`a !`, actualStatement, Merge())
+	require.NoError(t, err)
+	require.Equal(t, &statement{Name: "a"}, actualStatement)
+}
+
+func TestTrace(t *testing.T) {
+	type value struct {
+		Number *int    `  @Int`
+		Name   *string `| @Ident`
+	}
+	type assignment struct {
+		Name  string `@Ident "="`
+		Value *value `@@`
+	}
+	type grammar struct {
+		Assignments []*assignment `{ @@ ";" }`
+	}
+	p := mustTestParser(t, &grammar{})
+	trace := &strings.Builder{}
+	err := p.ParseString(`a = 1;`, &grammar{}, Trace(trace))
+	require.NoError(t, err)
+	require.Equal(t, `> grammar
+  > assignment
+    match Ident "a"
+    match "="
+    > value
+      match Int "1"
+    < value (ok)
+  < assignment (ok)
+  match ";"
+  > assignment
+  < assignment (no match)
+< grammar (ok)
+`, trace.String())
+
+	trace.Reset()
+	err = p.ParseString(`a = ;`, &grammar{}, Trace(trace))
+	require.Error(t, err)
+	require.Equal(t, `> grammar
+  > assignment
+    match Ident "a"
+    match "="
+    > value
+    < value (no match)
+  < assignment (error)
+< grammar (error)
+`, trace.String())
+}
+
+func TestFieldOptions(t *testing.T) {
+	type grammar struct {
+		Percent *int     `parser:"[ \"percent\" @Int ]" opts:"min=0,max=100"`
+		Ratio   float64  `parser:"[ \"ratio\" @Float ]" opts:"max=1.5"`
+		Name    string   `parser:"[ \"name\" @Ident ]" opts:"minlen=2, maxlen=4"`
+		Ports   []uint16 `parser:"{ \"port\" @Int }" opts:"min=1024"`
+		Tags    []string `parser:"{ \"tag\" @Ident }" opts:"maxlen=3"`
+	}
+	p := mustTestParser(t, &grammar{})
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{input: `percent 0 ratio 1.5 name ab port 1024 port 8080 tag abc`},
+		{input: `percent 100 name abcd`},
+		{input: `percent 101`, err: `<source>:1:9: 101 is greater than the maximum 100`},
+		{input: `ratio 1.75`, err: `<source>:1:7: 1.75 is greater than the maximum 1.5`},
+		{input: `name a`, err: `<source>:1:6: "a" is shorter than the minimum length 2`},
+		{input: `name abcde`, err: `<source>:1:6: "abcde" is longer than the maximum length 4`},
+		{input: `port 8080 port 80`, err: `<source>:1:16: 80 is less than the minimum 1024`},
+		{input: `tag ab tag abcd`, err: `<source>:1:12: "abcd" is longer than the maximum length 3`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			err := p.ParseString(test.input, &grammar{})
+			if test.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, test.err)
+			}
+		})
+	}
+
+	invalid := []struct {
+		grammar interface{}
+		err     string
+	}{
+		{&struct {
+			A int `parser:"@Int" opts:"min"`
+		}{}, `A: invalid opts "min", expected <option>=<value>`},
+		{&struct {
+			A int `parser:"@Int" opts:"max=x"`
+		}{}, `A: invalid opts "max=x": strconv.ParseFloat: parsing "x": invalid syntax`},
+		{&struct {
+			A string `parser:"@Ident" opts:"min=1"`
+		}{}, `A: opts "min" requires a numeric field`},
+		{&struct {
+			A int `parser:"@Int" opts:"maxlen=1"`
+		}{}, `A: opts "maxlen" requires a string field`},
+		{&struct {
+			A string `parser:"@Ident" opts:"minlen=-1"`
+		}{}, `A: invalid opts "minlen=-1", expected a non-negative length`},
+		{&struct {
+			A string `parser:"@Ident" opts:"pattern=x"`
+		}{}, `A: unknown opts "pattern"`},
+	}
+	for _, test := range invalid {
+		_, err := Build(test.grammar)
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestSubCaptures(t *testing.T) {
+	type dependency struct {
+		Name  string `@Ident`
+		Major int    `@Version /v(?P<major>\d+)\.(?P<minor>\d+)(?:\.(?P<patch>\d+))?(?:-(?P<Pre>[a-z]+))?/`
+		Minor int
+		Patch *int
+		Pre   string
+	}
+	type grammar struct {
+		Dependencies []*dependency `{ @@ / "," }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(\s+)|(?P<Version>v[0-9][0-9.]*(?:-[a-z]+)?)|(?P<Ident>[a-z]+)|(?P<Punct>,)`))
+	p := mustTestParser(t, &grammar{}, Lexer(lex))
+	actual := &grammar{}
+	err := p.ParseString(`foo v1.2.3, bar v10.0-beta`, actual)
+	require.NoError(t, err)
+	three := 3
+	require.Equal(t, &grammar{Dependencies: []*dependency{
+		{Name: "foo", Major: 1, Minor: 2, Patch: &three},
+		{Name: "bar", Major: 10, Minor: 0, Pre: "beta"},
+	}}, actual)
+
+	err = p.ParseString(`foo v1`, &grammar{})
+	require.EqualError(t, err, `<source>:1:5: "v1" does not match /v(?P<major>\d+)\.(?P<minor>\d+)(?:\.(?P<patch>\d+))?(?:-(?P<Pre>[a-z]+))?/`)
+
+	type path struct {
+		Dir  string `@String /(?P<dir>.*)\/(?P<base>[^\/]*)/`
+		Base string
+	}
+	actualPath := &path{}
+	err = mustTestParser(t, &path{}).ParseString(`"usr/local/bin"`, actualPath)
+	require.NoError(t, err)
+	require.Equal(t, &path{Dir: "usr/local", Base: "bin"}, actualPath)
+
+	type unknownGroup struct {
+		A string `@Ident /(?P<b>.*)/`
+	}
+	_, err = Build(&unknownGroup{})
+	require.EqualError(t, err, `A: group "b" of /(?P<b>.*)/: unknown field "B" in participle.unknownGroup`)
+	type invalid struct {
+		A string `@Ident /(?P<a>*)/`
+	}
+	_, err = Build(&invalid{})
+	require.EqualError(t, err, "A: invalid regular expression /(?P<a>*)/: error parsing regexp: missing argument to repetition operator: `*`")
+
+	// Without a named group, a / is the separator of a repetition.
+	type separated struct {
+		A []string `{ @Ident /"," } "." { @Int /"," }`
+	}
+	actualSeparated := &separated{}
+	err = mustTestParser(t, &separated{}).ParseString(`a, b. 1, 2`, actualSeparated)
+	require.NoError(t, err)
+	require.Equal(t, &separated{A: []string{"a", "b", "1", "2"}}, actualSeparated)
+}
+
+func TestCanonicalize(t *testing.T) {
+	type style struct {
+		Property string   `@("color" | "colour" | "size") ":"`
+		Values   []string `{ @Ident }`
+	}
+	type grammar struct {
+		Styles []*style `{ @@ ";" }`
+	}
+	p := mustTestParser(t, &grammar{},
+		Canonicalize("style.Property", map[string]string{"colour": "color"}),
+		Canonicalize("style.Values", map[string]string{"grey": "gray"}))
+	actual := &grammar{}
+	err := p.ParseString(`colour: grey; color: red; size: big;`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Styles: []*style{
+		{Property: "color", Values: []string{"gray"}},
+		{Property: "color", Values: []string{"red"}},
+		{Property: "size", Values: []string{"big"}},
+	}}, actual)
+
+	_, err = Build(&grammar{}, Canonicalize("style.Colour", map[string]string{}))
+	require.EqualError(t, err, `Canonicalize() for unknown captured field "style.Colour"`)
+
+	type counter struct {
+		Count int `{ @#"x" }`
+	}
+	_, err = Build(&counter{}, Canonicalize("counter.Count", map[string]string{}))
+	require.EqualError(t, err, `Canonicalize() for "counter.Count" requires a string field`)
+}
+
+func TestCountElided(t *testing.T) {
+	type function struct {
+		Name   string   `"func" @Ident`
+		Params []string `"(" { @Ident } ")"`
+		// Counts of the elided tokens preceding Name and each of Params.
+		BlankLines int
+		Gaps       []int
+	}
+	type grammar struct {
+		Functions []*function `{ @@ }`
+	}
+	lex := lexer.Must(lexer.Regexp(`(?P<Newline>\n)|(?P<Whitespace>[ \t]+)|(?P<Ident>[a-z]+)|(?P<Punct>[()])`))
+	p := mustTestParser(t, &grammar{}, Lexer(lex), Elide("Newline", "Whitespace"),
+		CountElided("function.Name", "BlankLines", "Newline"),
+		CountElided("function.Params", "Gaps"))
+	actual := &grammar{}
+	err := p.ParseString("func\n\n\tmain(a  b\nc)\nfunc f()", actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Functions: []*function{
+		{Name: "main", BlankLines: 2, Params: []string{"a", "b", "c"}, Gaps: []int{0, 1, 1}},
+		{Name: "f", BlankLines: 0},
+	}}, actual)
+
+	// Elided tokens are still counted once those of earlier tokens have been discarded.
+	actual = &grammar{}
+	err = p.ParseString("func f("+strings.Repeat("\nx", 500)+")", actual)
+	require.NoError(t, err)
+	require.Equal(t, 500, len(actual.Functions[0].Gaps))
+	for _, gap := range actual.Functions[0].Gaps {
+		require.Equal(t, 1, gap)
+	}
+
+	_, err = Build(&grammar{}, Lexer(lex), CountElided("function.Name", "Params"))
+	require.EqualError(t, err, `CountElided() for "function.Name" requires an integer or integer slice field "Params"`)
+	_, err = Build(&grammar{}, Lexer(lex), CountElided("function.Name", "BlankLines", "Comment"))
+	require.EqualError(t, err, `CountElided() for "function.Name": lexer does not support symbol "Comment"`)
+	_, err = Build(&grammar{}, Lexer(lex), CountElided("function.Body", "BlankLines"))
+	require.EqualError(t, err, `CountElided() for unknown captured field "function.Body"`)
+}
+
+func TestFencedBlocks(t *testing.T) {
+	type section struct {
+		Title string `"#" @Ident`
+		Code  string `[ @Fence ]`
+	}
+	type grammar struct {
+		Sections []*section `{ @@ }`
+	}
+	def, err := lexer.Fences(lexer.TextScannerLexer, "Fence", "```", "```")
+	require.NoError(t, err)
+	p := mustTestParser(t, &grammar{}, Lexer(def))
+	actual := &grammar{}
+	err = p.ParseString("# intro\n```\nfmt.Println(\"`\")\n```\n# end", actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Sections: []*section{
+		{Title: "intro", Code: "\nfmt.Println(\"`\")\n"},
+		{Title: "end"},
+	}}, actual)
+}
+
+func TestOptionalDefault(t *testing.T) {
+	type grammar struct {
+		Name  string `@Ident`
+		Value string `[ "=" @Int | default @"nil" ]`
+		Unit  string `[ @"ms" | @"s" | default ]`
+	}
+	for _, options := range [][]Option{nil, {NoLookahead()}} {
+		p := mustTestParser(t, &grammar{}, options...)
+		actual := &grammar{}
+		err := p.ParseString(`a = 1 ms`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Name: "a", Value: "1", Unit: "ms"}, actual)
+
+		actual = &grammar{}
+		err = p.ParseString(`a nil`, actual)
+		require.NoError(t, err)
+		require.Equal(t, &grammar{Name: "a", Value: "nil"}, actual)
+
+		err = p.ParseString(`a`, &grammar{})
+		require.EqualError(t, err, `<source>:1:2: unexpected "<EOF>" (expected "=" | "nil")`)
+	}
+
+	_, err := Build(&struct {
+		A bool `[ "a" | default "b" ] -> A`
+	}{})
+	require.EqualError(t, err, `A: optional presence can not be captured for an optional with a default`)
+}
+
+type testOp int
+
+const (
+	testAdd testOp = iota
+	testSub
+	testMul
+)
+
+func TestRegisterEnum(t *testing.T) {
+	type grammar struct {
+		Left  int      `@Int`
+		Op    testOp   `@( "+" | "-" | "*" )`
+		Right int      `@Int`
+		More  []testOp `{ @( "+" | "-" ) }`
+		Last  *testOp  `[ "!" @Ident ]`
+	}
+	ops := map[string]int{"+": int(testAdd), "-": int(testSub), "*": int(testMul)}
+	p := mustTestParser(t, &grammar{}, RegisterEnum(testOp(0), ops))
+	err := p.ParseString(`1 * 2 - + ! add`, &grammar{})
+	require.EqualError(t, err, `<source>:1:13: invalid testOp "add"`)
+	actual := &grammar{}
+	err = p.ParseString(`1 * 2 - +`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &grammar{Left: 1, Op: testMul, Right: 2, More: []testOp{testSub, testAdd}}, actual)
+
+	_, err = Build(&grammar{}, RegisterEnum(testOp(0), map[string]int{"+": 0, "-": 1}))
+	require.EqualError(t, err, `grammar.Op: literal "*" has no value registered with RegisterEnum() for participle.testOp`)
+	_, err = Build(&grammar{}, RegisterEnum("", ops))
+	require.EqualError(t, err, `RegisterEnum() requires a value of an integer type, not string`)
+}
+
+func TestDelegate(t *testing.T) {
+	type query struct {
+		Fields []string `"{" "select" @Ident { "," @Ident } "}"`
+	}
+	type statement struct {
+		Name  string `"query" @Ident`
+		Query *query `@Code`
+	}
+	type document struct {
+		Statements []*statement `{ @@ }`
+	}
+	sub := mustTestParser(t, &query{}, Lexer(lexer.Must(lexer.Regexp(`(?P<Ident>\w+)|(?P<Punct>[{},])|(\s+)`))))
+	p := mustTestParser(t, &document{},
+		Lexer(lexer.Must(lexer.Regexp(`(?P<Ident>\w+)|(?P<Code>\{[^}]*\})|(\s+)`))),
+		Delegate(query{}, sub))
+	actual := &document{}
+	err := p.ParseString("query a {select x,\n  y}", actual)
+	require.NoError(t, err)
+	require.Equal(t, &document{Statements: []*statement{{Name: "a", Query: &query{Fields: []string{"x", "y"}}}}}, actual)
+
+	source := "query a {select x}\nquery b {select\n  x, , y}"
+	err = p.ParseString(source, &document{})
+	require.EqualError(t, err, `<source>:3:6: unexpected "," (expected <ident>)`)
+	lerr := &lexer.Error{}
+	require.True(t, errors.As(err, &lerr))
+	require.Equal(t, ",", source[lerr.Pos.Offset:lerr.Pos.Offset+1])
+
+	err = p.ParseString("query a {select\n  x; y}", &document{})
+	require.EqualError(t, err, `<source>:2:4: invalid token ';'`)
+}
+
+func TestDelegateQuoted(t *testing.T) {
+	type query struct {
+		Fields []string `"select" @Ident { "," @Ident }`
+	}
+	type statement struct {
+		Name  string `"query" @Ident`
+		Query *query `@String`
+	}
+	sub := mustTestParser(t, &query{})
+	p := mustTestParser(t, &statement{}, Delegate(query{}, sub))
+	actual := &statement{}
+	err := p.ParseString(`query a "select x, y"`, actual)
+	require.NoError(t, err)
+	require.Equal(t, &statement{Name: "a", Query: &query{Fields: []string{"x", "y"}}}, actual)
+
+	err = p.ParseString(`query a "select x, , y"`, &statement{})
+	require.EqualError(t, err, `<source>:1:20: unexpected "," (expected <ident>)`)
+}
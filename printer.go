@@ -19,15 +19,42 @@ func nodePrinter(seen map[node]bool, v node) string {
 	seen[v] = true
 	switch n := v.(type) {
 	case *disjunction:
+		out := []string{}
+		for i, c := range n.nodes {
+			if n.flags != nil && n.flags[i] != "" {
+				out = append(out, "?"+n.flags[i]+" "+nodePrinter(seen, c))
+			} else {
+				out = append(out, nodePrinter(seen, c))
+			}
+		}
+		return strings.Join(out, "|")
+
+	case *exclusive:
+		return fmt.Sprintf("^(%s)", nodePrinter(seen, &n.disjunction))
+
+	case *union:
+		return fmt.Sprintf("union(type=%s, expr=%s)", n.typ, nodePrinter(seen, &n.disjunction))
+
+	case *unordered:
 		out := []string{}
 		for _, n := range n.nodes {
 			out = append(out, nodePrinter(seen, n))
 		}
-		return strings.Join(out, "|")
+		return fmt.Sprintf("<%s>", strings.Join(out, "|"))
+
+	case *record:
+		out := []string{}
+		for i, c := range n.nodes {
+			out = append(out, nodePrinter(seen, c)+n.cardinality[i].String())
+		}
+		return fmt.Sprintf("%%{%s}", strings.Join(out, "|"))
 
 	case *strct:
 		return fmt.Sprintf("strct(type=%s, expr=%s)", n.typ, nodePrinter(seen, n.expr))
 
+	case *precedence:
+		return fmt.Sprintf("precedence(type=%s, expr=%s)", n.expr.typ, nodePrinter(seen, n.grammar))
+
 	case *sequence:
 		out := []string{}
 		for c := n; c != nil; c = c.next {
@@ -38,6 +65,30 @@ func nodePrinter(seen map[node]bool, v node) string {
 	case *capture:
 		return fmt.Sprintf("@(field=%s, node=%s)", n.field.Name, nodePrinter(seen, n.node))
 
+	case *signedNumber:
+		return fmt.Sprintf("signed(%s)", nodePrinter(seen, n.number))
+
+	case *positiveLookahead:
+		return fmt.Sprintf("&(%s)", nodePrinter(seen, n.node))
+
+	case *versioned:
+		return fmt.Sprintf("since(%d, %s)", n.since, nodePrinter(seen, n.node))
+
+	case *cut:
+		return "!"
+
+	case *epsilon:
+		return "ε"
+
+	case *negation:
+		return fmt.Sprintf("~(%s)", nodePrinter(seen, n.stop.node))
+
+	case *column:
+		return fmt.Sprintf("#(%d-%d)", n.min, n.max)
+
+	case *keywordSet:
+		return fmt.Sprintf("$%s", n.name)
+
 	case *reference:
 		return fmt.Sprintf("%s", n.identifier)
 
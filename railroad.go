@@ -0,0 +1,189 @@
+package participle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RailroadKind is the kind of a RailroadNode.
+type RailroadKind string
+
+// Kinds of railroad diagram nodes.
+const (
+	// RailroadGrammar is the root of a diagram, with a RailroadRule child per grammar struct.
+	RailroadGrammar RailroadKind = "grammar"
+	// RailroadRule defines the grammar struct named by Text, with a single child.
+	RailroadRule RailroadKind = "rule"
+	// RailroadSequence matches each child in order.
+	RailroadSequence RailroadKind = "sequence"
+	// RailroadChoice matches one of its children.
+	RailroadChoice RailroadKind = "choice"
+	// RailroadOptional matches its single child zero or one times.
+	RailroadOptional RailroadKind = "optional"
+	// RailroadRepetition matches its single child zero or more times.
+	RailroadRepetition RailroadKind = "repetition"
+	// RailroadLookahead matches its single child without consuming input.
+	RailroadLookahead RailroadKind = "lookahead"
+	// RailroadTerminal matches a single token, either a quoted literal or a token type.
+	RailroadTerminal RailroadKind = "terminal"
+	// RailroadNonTerminal refers to the RailroadRule named by Text.
+	RailroadNonTerminal RailroadKind = "nonterminal"
+)
+
+// RailroadNode is a node in a railroad diagram of a grammar, suitable for rendering.
+type RailroadNode struct {
+	Kind     RailroadKind
+	Text     string
+	Children []RailroadNode
+}
+
+// Railroad returns a railroad diagram of the grammar.
+//
+// The root RailroadGrammar node has a RailroadRule for each struct in the grammar, with the
+// root struct first. References between structs are represented by RailroadNonTerminal nodes.
+func (p *Parser) Railroad() RailroadNode {
+	if err := p.resolve(); err != nil {
+		return RailroadNode{Kind: RailroadGrammar}
+	}
+	r := &railroadBuilder{seen: map[node]bool{}}
+	r.queue = append(r.queue, p.root)
+	out := RailroadNode{Kind: RailroadGrammar}
+	for len(r.queue) > 0 {
+		n := r.queue[0]
+		r.queue = r.queue[1:]
+		if r.seen[n] {
+			continue
+		}
+		r.seen[n] = true
+		switch n := n.(type) {
+		case *strct:
+			out.Children = append(out.Children, RailroadNode{
+				Kind:     RailroadRule,
+				Text:     ruleName(n.typ),
+				Children: []RailroadNode{r.build(n.expr)},
+			})
+		default:
+			out.Children = append(out.Children, RailroadNode{Kind: RailroadRule, Children: []RailroadNode{r.build(n)}})
+		}
+	}
+	return out
+}
+
+type railroadBuilder struct {
+	seen  map[node]bool
+	queue []node
+}
+
+func (r *railroadBuilder) build(n node) RailroadNode {
+	switch n := n.(type) {
+	case *strct:
+		r.queue = append(r.queue, n)
+		return RailroadNode{Kind: RailroadNonTerminal, Text: ruleName(n.typ)}
+
+	case *parseable:
+		return RailroadNode{Kind: RailroadNonTerminal, Text: n.t.Name()}
+
+	case *disjunction:
+		return r.choice(n.nodes)
+
+	case *exclusive:
+		return r.choice(n.nodes)
+
+	case *union:
+		return r.choice(n.nodes)
+
+	case *precedence:
+		return r.build(n.grammar)
+
+	case *unordered:
+		return RailroadNode{Kind: RailroadRepetition, Children: []RailroadNode{r.choice(n.nodes)}}
+
+	case *record:
+		return RailroadNode{Kind: RailroadRepetition, Children: []RailroadNode{r.choice(n.nodes)}}
+
+	case *sequence:
+		out := RailroadNode{Kind: RailroadSequence}
+		for c := n; c != nil; c = c.next {
+			child := r.build(c.node)
+			if child.Kind == RailroadSequence {
+				out.Children = append(out.Children, child.Children...)
+			} else {
+				out.Children = append(out.Children, child)
+			}
+		}
+		return out
+
+	case *capture:
+		return r.build(n.node)
+
+	case *optional:
+		return r.then(RailroadNode{Kind: RailroadOptional, Children: []RailroadNode{r.build(n.node)}}, n.next)
+
+	case *repetition:
+		return r.then(RailroadNode{Kind: RailroadRepetition, Children: []RailroadNode{r.build(n.node)}}, n.next)
+
+	case *literal:
+		return RailroadNode{Kind: RailroadTerminal, Text: strconv.Quote(n.s)}
+
+	case *reference:
+		if len(n.set) > 0 {
+			out := RailroadNode{Kind: RailroadChoice}
+			for _, identifier := range strings.Split(n.identifier, "|") {
+				out.Children = append(out.Children, RailroadNode{Kind: RailroadTerminal, Text: identifier})
+			}
+			return out
+		}
+		return RailroadNode{Kind: RailroadTerminal, Text: n.identifier}
+
+	case *keywordSet:
+		return RailroadNode{Kind: RailroadTerminal, Text: "$" + n.name}
+
+	case *signedNumber:
+		return r.build(n.grammar)
+
+	case *positiveLookahead:
+		return RailroadNode{Kind: RailroadLookahead, Children: []RailroadNode{r.build(n.node)}}
+
+	case *versioned:
+		return r.build(n.node)
+
+	case *cut:
+		// Cuts do not affect the language matched, so are drawn as an empty sequence.
+		return RailroadNode{Kind: RailroadSequence}
+
+	case *epsilon:
+		return RailroadNode{Kind: RailroadSequence}
+
+	case *negation:
+		return RailroadNode{Kind: RailroadRepetition, Children: []RailroadNode{{Kind: RailroadTerminal, Text: n.String()}}}
+
+	case *column:
+		// Drawn as a lookahead, as it asserts the position of the next token without consuming it.
+		return RailroadNode{Kind: RailroadLookahead, Children: []RailroadNode{{Kind: RailroadTerminal, Text: n.String()}}}
+
+	default:
+		panic(fmt.Sprintf("unsupported node type %T", n))
+	}
+}
+
+func (r *railroadBuilder) choice(nodes []node) RailroadNode {
+	out := RailroadNode{Kind: RailroadChoice}
+	for _, c := range nodes {
+		out.Children = append(out.Children, r.build(c))
+	}
+	return out
+}
+
+// Optional and repetition nodes own the remainder of their sequence.
+func (r *railroadBuilder) then(head RailroadNode, next node) RailroadNode {
+	if next == nil {
+		return head
+	}
+	tail := r.build(next)
+	if tail.Kind == RailroadSequence {
+		tail.Children = append([]RailroadNode{head}, tail.Children...)
+		return tail
+	}
+	return RailroadNode{Kind: RailroadSequence, Children: []RailroadNode{head, tail}}
+}
@@ -0,0 +1,42 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRailroad(t *testing.T) {
+	type railroadValue struct {
+		Str string `  @String`
+		Num int    `| @Int`
+	}
+	type railroadGrammar struct {
+		Key    string           `@Ident "="`
+		Values []*railroadValue `@@ { "," @@ }`
+		Flag   bool             `[ @"!" ]`
+	}
+	p, err := Build(&railroadGrammar{})
+	require.NoError(t, err)
+	terminal := func(s string) RailroadNode { return RailroadNode{Kind: RailroadTerminal, Text: s} }
+	expected := RailroadNode{Kind: RailroadGrammar, Children: []RailroadNode{
+		{Kind: RailroadRule, Text: "railroadGrammar", Children: []RailroadNode{
+			{Kind: RailroadSequence, Children: []RailroadNode{
+				terminal("Ident"),
+				terminal(`"="`),
+				{Kind: RailroadNonTerminal, Text: "railroadValue"},
+				{Kind: RailroadRepetition, Children: []RailroadNode{
+					{Kind: RailroadSequence, Children: []RailroadNode{
+						terminal(`","`),
+						{Kind: RailroadNonTerminal, Text: "railroadValue"},
+					}},
+				}},
+				{Kind: RailroadOptional, Children: []RailroadNode{terminal(`"!"`)}},
+			}},
+		}},
+		{Kind: RailroadRule, Text: "railroadValue", Children: []RailroadNode{
+			{Kind: RailroadChoice, Children: []RailroadNode{terminal("String"), terminal("Int")}},
+		}},
+	}}
+	require.Equal(t, expected, p.Railroad())
+}
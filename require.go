@@ -0,0 +1,49 @@
+package participle
+
+import (
+	"errors"
+	"reflect"
+)
+
+type requirement struct {
+	predicate func(interface{}) bool
+	message   string
+}
+
+// Require is an Option that validates each successfully parsed tree, returning an error with
+// message unless predicate is true for at least one node of it.
+//
+// The predicate is called with a pointer to each struct reachable from the root through exported
+// fields, including the root itself, until it returns true. For example, to require a main
+// function:
+//
+//	participle.Require(func(node interface{}) bool {
+//		f, ok := node.(*Function)
+//		return ok && f.Name == "main"
+//	}, "missing main function")
+func Require(predicate func(interface{}) bool, message string) Option {
+	return func(p *Parser) error {
+		p.requirements = append(p.requirements, requirement{predicate: predicate, message: message})
+		return nil
+	}
+}
+
+// Returns an error for the first requirement not satisfied by the tree rooted at v.
+func (p *Parser) checkRequirements(v reflect.Value) error {
+	for _, r := range p.requirements {
+		if !anyNode(v, r.predicate) {
+			return errors.New(r.message)
+		}
+	}
+	return nil
+}
+
+// Returns true if predicate is true for any struct reachable from v.
+func anyNode(v reflect.Value, predicate func(interface{}) bool) bool {
+	found := false
+	walkAST(v, func(v reflect.Value) bool {
+		found = found || predicate(addressOf(v))
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,393 @@
+package participle
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// EOFSymbol represents the end of the input in FOLLOW sets.
+const EOFSymbol = "EOF"
+
+// FirstSets returns the FIRST set of each grammar struct, keyed by rule name.
+//
+// The FIRST set of a rule is the set of tokens that can begin it. Literals are represented by
+// their quoted value, eg. `"("`, token types by their name, eg. `Ident`, and keyword sets by
+// `$<name>`. Types implementing Parseable are opaque and contribute no tokens.
+func (p *Parser) FirstSets() map[string][]string {
+	a, err := p.analyse()
+	if err != nil {
+		return nil
+	}
+	out := map[string][]string{}
+	for s, first := range a.first {
+		out[ruleName(s.typ)] = first.sorted()
+	}
+	return out
+}
+
+// FollowSets returns the FOLLOW set of each grammar struct, keyed by rule name.
+//
+// The FOLLOW set of a rule is the set of tokens that can immediately follow it, including
+// EOFSymbol if it may end the input. Tokens are represented as in FirstSets().
+func (p *Parser) FollowSets() map[string][]string {
+	a, err := p.analyse()
+	if err != nil {
+		return nil
+	}
+	a.computeFollow(p.root)
+	out := map[string][]string{}
+	for s, follow := range a.follow {
+		out[ruleName(s.typ)] = follow.sorted()
+	}
+	return out
+}
+
+// Returns an error if the body of any repetition can match empty input, as it would never terminate.
+func checkRepetitions(root node) error {
+	a := analyseGrammar(root)
+	production := ""
+	return visit(root, func(n node, next func() error) error {
+		switch n := n.(type) {
+		case *strct:
+			outer := production
+			production = ruleName(n.typ)
+			err := next()
+			production = outer
+			return err
+		case *repetition:
+			if _, nullable := a.firstOf(n.node); nullable {
+				return fmt.Errorf("%s: repetition body may match empty input: %s", production, n)
+			}
+		}
+		return next()
+	})
+}
+
+type symbolSet map[string]bool
+
+// Add all symbols in other, returning true if any were new.
+func (s symbolSet) addAll(other symbolSet) bool {
+	changed := false
+	for symbol := range other {
+		if !s[symbol] {
+			s[symbol] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s symbolSet) sorted() []string {
+	out := make([]string, 0, len(s))
+	for symbol := range s {
+		out = append(out, symbol)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Fixpoint computation of FIRST and FOLLOW sets over the grammar graph.
+type grammarAnalysis struct {
+	rules    []*strct
+	first    map[*strct]symbolSet
+	nullable map[*strct]bool
+	follow   map[*strct]symbolSet
+	// Tokens that can follow the node of each optional, ie. FIRST of its continuation.
+	continuation map[*optional]symbolSet
+	// The token matched by each terminal symbol.
+	tokens  map[string]lexer.Token
+	changed bool
+}
+
+func (p *Parser) analyse() (*grammarAnalysis, error) {
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return analyseGrammar(p.root), nil
+}
+
+// Compute the FIRST sets of the grammar rooted at root.
+func analyseGrammar(root node) *grammarAnalysis {
+	a := &grammarAnalysis{first: map[*strct]symbolSet{}, nullable: map[*strct]bool{}, tokens: map[string]lexer.Token{}}
+	_ = visit(root, func(n node, next func() error) error {
+		if s, ok := n.(*strct); ok {
+			a.rules = append(a.rules, s)
+			a.first[s] = symbolSet{}
+		}
+		return next()
+	})
+	for a.changed = true; a.changed; {
+		a.changed = false
+		for _, s := range a.rules {
+			first, nullable := a.firstOf(s.expr)
+			if a.first[s].addAll(first) {
+				a.changed = true
+			}
+			if nullable && !a.nullable[s] {
+				a.nullable[s] = true
+				a.changed = true
+			}
+		}
+	}
+	return a
+}
+
+// Returns the FIRST set of n, and whether n can match no tokens, from the current rule approximations.
+func (a *grammarAnalysis) firstOf(n node) (symbolSet, bool) {
+	switch n := n.(type) {
+	case *strct:
+		return a.first[n], a.nullable[n]
+
+	case *union:
+		return a.firstOfAny(n.nodes)
+
+	case *precedence:
+		return a.firstOf(n.grammar)
+
+	case *disjunction:
+		return a.firstOfAny(n.nodes)
+
+	case *exclusive:
+		return a.firstOfAny(n.nodes)
+
+	case *unordered:
+		first, _ := a.firstOfAny(n.nodes)
+		return first, true
+
+	case *record:
+		first, _ := a.firstOfAny(n.nodes)
+		nullable := true
+		for i, c := range n.nodes {
+			_, member := a.firstOf(c)
+			nullable = nullable && (member || n.cardinality[i].min == 0)
+		}
+		return first, nullable
+
+	case *sequence:
+		out := symbolSet{}
+		for c := n; c != nil; c = c.next {
+			first, nullable := a.firstOf(c.node)
+			out.addAll(first)
+			if !nullable {
+				return out, false
+			}
+		}
+		return out, true
+
+	case *capture:
+		return a.firstOf(n.node)
+
+	case *versioned:
+		return a.firstOf(n.node)
+
+	case *optional:
+		return a.firstOfSkippable(n.node, n.next)
+
+	case *repetition:
+		return a.firstOfSkippable(n.node, n.next)
+
+	case *positiveLookahead, *cut, *column, *epsilon:
+		return symbolSet{}, true
+
+	case *negation:
+		// Any token other than those of the stop expression, which cannot be represented.
+		return symbolSet{}, true
+
+	case *literal:
+		return a.terminal(strconv.Quote(n.s), lexer.Token{Type: n.t, Value: n.s}), false
+
+	case *reference:
+		return a.referenceSymbols(n), false
+
+	case *keywordSet:
+		return a.terminal("$"+n.name, lexer.Token{Type: n.typ}), false
+
+	case *signedNumber:
+		out := a.referenceSymbols(n.number)
+		out.addAll(a.terminal(`"-"`, lexer.Token{Type: lexer.EOF, Value: "-"}))
+		out.addAll(a.terminal(`"+"`, lexer.Token{Type: lexer.EOF, Value: "+"}))
+		return out, false
+
+	case *parseable:
+		return symbolSet{}, false
+
+	default:
+		panic(fmt.Sprintf("unsupported node type %T", n))
+	}
+}
+
+func (a *grammarAnalysis) firstOfAny(nodes []node) (symbolSet, bool) {
+	out := symbolSet{}
+	nullable := false
+	for _, c := range nodes {
+		first, empty := a.firstOf(c)
+		out.addAll(first)
+		nullable = nullable || empty
+	}
+	return out, nullable
+}
+
+// FIRST of an optional or repetition, which may be skipped in favour of the remainder of its sequence.
+func (a *grammarAnalysis) firstOfSkippable(n, next node) (symbolSet, bool) {
+	out, _ := a.firstOf(n)
+	out = copySymbols(out)
+	if next == nil {
+		return out, true
+	}
+	first, nullable := a.firstOf(next)
+	out.addAll(first)
+	return out, nullable
+}
+
+func (a *grammarAnalysis) referenceSymbols(r *reference) symbolSet {
+	out := symbolSet{}
+	types := append([]rune{r.typ}, r.set...)
+	for i, identifier := range strings.Split(r.identifier, "|") {
+		out.addAll(a.terminal(identifier, lexer.Token{Type: types[i]}))
+	}
+	return out
+}
+
+// Returns the set containing the terminal symbol, recording the token it matches.
+func (a *grammarAnalysis) terminal(symbol string, token lexer.Token) symbolSet {
+	if existing, ok := a.tokens[symbol]; ok && existing.Type != token.Type {
+		// Literals with the same value but different token types are not distinguished by symbol.
+		token.Type = lexer.EOF
+	}
+	a.tokens[symbol] = token
+	return symbolSet{symbol: true}
+}
+
+func copySymbols(s symbolSet) symbolSet {
+	out := symbolSet{}
+	out.addAll(s)
+	return out
+}
+
+func (a *grammarAnalysis) computeFollow(root node) {
+	a.follow = map[*strct]symbolSet{}
+	a.continuation = map[*optional]symbolSet{}
+	for _, s := range a.rules {
+		a.follow[s] = symbolSet{}
+	}
+	if p, ok := root.(*precedence); ok {
+		root = p.expr
+	}
+	if s, ok := root.(*strct); ok {
+		a.follow[s][EOFSymbol] = true
+	}
+	for a.changed = true; a.changed; {
+		a.changed = false
+		for _, s := range a.rules {
+			a.walkFollow(s.expr, copySymbols(a.follow[s]))
+		}
+	}
+}
+
+// Propagate the set of tokens that can follow n to the rules it references.
+func (a *grammarAnalysis) walkFollow(n node, follow symbolSet) {
+	switch n := n.(type) {
+	case *strct:
+		if a.follow[n].addAll(follow) {
+			a.changed = true
+		}
+
+	case *union:
+		for _, c := range n.nodes {
+			a.walkFollow(c, follow)
+		}
+
+	case *precedence:
+		a.walkFollow(n.grammar, follow)
+
+	case *disjunction:
+		for _, c := range n.nodes {
+			a.walkFollow(c, follow)
+		}
+
+	case *exclusive:
+		for _, c := range n.nodes {
+			a.walkFollow(c, follow)
+		}
+
+	case *unordered:
+		a.walkMembers(n.nodes, follow)
+
+	case *record:
+		a.walkMembers(n.nodes, follow)
+
+	case *sequence:
+		nodes := []node{}
+		for c := n; c != nil; c = c.next {
+			nodes = append(nodes, c.node)
+		}
+		for i := len(nodes) - 1; i >= 0; i-- {
+			a.walkFollow(nodes[i], follow)
+			follow = a.precede(nodes[i], follow)
+		}
+
+	case *capture:
+		a.walkFollow(n.node, follow)
+
+	case *versioned:
+		a.walkFollow(n.node, follow)
+
+	case *positiveLookahead:
+		a.walkFollow(n.node, follow)
+
+	case *negation:
+		a.walkFollow(n.stop, follow)
+
+	case *optional:
+		if n.next != nil {
+			a.walkFollow(n.next, follow)
+			follow = a.precede(n.next, follow)
+		}
+		if a.continuation[n] == nil {
+			a.continuation[n] = symbolSet{}
+		}
+		if a.continuation[n].addAll(follow) {
+			a.changed = true
+		}
+		a.walkFollow(n.node, follow)
+
+	case *repetition:
+		if n.next != nil {
+			a.walkFollow(n.next, follow)
+			follow = a.precede(n.next, follow)
+		}
+		first, _ := a.firstOf(n.node)
+		follow = copySymbols(follow)
+		follow.addAll(first)
+		a.walkFollow(n.node, follow)
+
+	case *literal, *reference, *keywordSet, *signedNumber, *parseable, *cut, *column, *epsilon:
+
+	default:
+		panic(fmt.Sprintf("unsupported node type %T", n))
+	}
+}
+
+// Members of unordered groups and records may be followed by any other member.
+func (a *grammarAnalysis) walkMembers(nodes []node, follow symbolSet) {
+	first, _ := a.firstOfAny(nodes)
+	follow = copySymbols(follow)
+	follow.addAll(first)
+	for _, c := range nodes {
+		a.walkFollow(c, follow)
+	}
+}
+
+// Returns the tokens that can follow whatever precedes n, given the tokens that can follow n.
+func (a *grammarAnalysis) precede(n node, follow symbolSet) symbolSet {
+	first, nullable := a.firstOf(n)
+	out := copySymbols(first)
+	if nullable {
+		out.addAll(follow)
+	}
+	return out
+}
@@ -0,0 +1,22 @@
+package participle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstFollowSets(t *testing.T) {
+	p, err := Build(&setsProgram{})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"setsProgram": {`"("`, "Int"},
+		"setsExpr":    {`"("`, "Int"},
+		"setsTerm":    {`"("`, "Int"},
+	}, p.FirstSets())
+	require.Equal(t, map[string][]string{
+		"setsProgram": {EOFSymbol},
+		"setsExpr":    {`")"`, `";"`},
+		"setsTerm":    {`")"`, `"+"`, `";"`, "Ident"},
+	}, p.FollowSets())
+}
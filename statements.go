@@ -0,0 +1,133 @@
+package participle
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// A StatementScanner parses the statements of a grammar consisting of a single repetition, eg.
+// `Statements []*Statement "{ @@ }"`, one at a time as its input arrives, eg. in a REPL:
+//
+//	scanner, err := participle.NewStatementScanner(parser)
+//	for line := range lines {
+//		scanner.Write([]byte(line))
+//		for scanner.Scan() {
+//			evaluate(scanner.Statement().(*Statement))
+//		}
+//		if errors.Is(scanner.Err(), participle.ErrIncomplete) {
+//			// Prompt for a continuation line.
+//		} else if scanner.Err() != nil {
+//			scanner.Reset()
+//		}
+//	}
+//
+// Each statement is removed from the buffered input once it has been parsed, while a statement
+// that the input ends part way through is left to be completed by later writes. Positions are
+// relative to all of the input written since the scanner was created.
+type StatementScanner struct {
+	parser  *Parser
+	options []ParseOption
+	root    *repetition
+	// Index of the slice field of the root struct that each statement is captured into.
+	field []int
+	// Input not yet parsed, starting at pos.
+	buffer    []byte
+	pos       lexer.Position
+	statement interface{}
+	err       error
+}
+
+// NewStatementScanner returns a StatementScanner parsing the statements of parser's grammar with
+// options, which must capture each match of its root repetition into a single slice field.
+func NewStatementScanner(parser *Parser, options ...ParseOption) (*StatementScanner, error) {
+	if err := parser.resolve(); err != nil {
+		return nil, err
+	}
+	root := rootRepetition(parser.root)
+	if root == nil {
+		return nil, fmt.Errorf("NewStatementScanner() requires the grammar %s to be a single repetition, eg. \"{ @@ }\"", parser.typ)
+	}
+	fields := map[string][]int{}
+	_ = visit(root.node, func(n node, next func() error) error {
+		switch n := n.(type) {
+		case *strct:
+			// Captures within a statement belong to it.
+			return nil
+		case *capture:
+			if n.field.Type.Kind() == reflect.Slice {
+				fields[n.field.Name] = n.field.Index
+			}
+		}
+		return next()
+	})
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("NewStatementScanner() requires the repetition of %s to capture into a single slice field", parser.typ)
+	}
+	s := &StatementScanner{parser: parser, options: options, root: root, pos: lexer.Position{Line: 1, Column: 1}}
+	for _, index := range fields {
+		s.field = index
+	}
+	return s, nil
+}
+
+// Write appends p to the input.
+func (s *StatementScanner) Write(p []byte) (int, error) {
+	s.buffer = append(s.buffer, p...)
+	return len(p), nil
+}
+
+// Scan parses the next statement from the input, returning false if there is no complete
+// statement or parsing fails.
+//
+// Err() then reports why: nil if the input ends between statements, an error matching
+// ErrIncomplete with errors.Is() if it ends part way through a statement, or the parse error.
+func (s *StatementScanner) Scan() bool {
+	s.statement, s.err = nil, nil
+	v := reflect.New(s.parser.typ.Elem())
+	options := append(s.options[:len(s.options):len(s.options)], WithBasePosition(s.pos), func(ctx *parseContext) {
+		ctx.statement = s.root
+		ctx.recovery = nil
+	})
+	lex, err := s.parser.parse(bytes.NewReader(s.buffer), v.Interface(), options, true)
+	defer s.parser.release(lex)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	next, err := lex.Peek(0)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	statements := v.Elem().FieldByIndex(s.field)
+	if statements.Len() == 0 {
+		if !next.EOF() {
+			s.err = lexer.Errorf(next.Pos, "unexpected token %q", next)
+		}
+		return false
+	}
+	s.statement = statements.Index(statements.Len() - 1).Interface()
+	s.buffer = s.buffer[next.Pos.Offset-s.pos.Offset:]
+	s.pos = next.Pos
+	return true
+}
+
+// Statement returns the statement parsed by the last successful call to Scan().
+func (s *StatementScanner) Statement() interface{} {
+	return s.statement
+}
+
+// Err returns the reason the last call to Scan() returned false, if any.
+func (s *StatementScanner) Err() error {
+	return s.err
+}
+
+// Reset discards any input not yet parsed, eg. after a parse error.
+func (s *StatementScanner) Reset() {
+	s.pos = s.pos.Advance(string(s.buffer), lexer.TabWidthOf(s.parser.lex))
+	s.buffer = s.buffer[:0]
+	s.statement, s.err = nil, nil
+}
@@ -0,0 +1,65 @@
+package participle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+func TestStatementScanner(t *testing.T) {
+	type statement struct {
+		Pos   lexer.Position
+		Name  string   `"let" @Ident "="`
+		Value []string `"(" { @Ident } ")" ";"`
+	}
+	type program struct {
+		Statements []*statement `{ @@ }`
+	}
+	p := mustTestParser(t, &program{})
+	scanner, err := NewStatementScanner(p)
+	require.NoError(t, err)
+
+	scan := func(input string) ([]*statement, error) {
+		_, err := scanner.Write([]byte(input))
+		require.NoError(t, err)
+		statements := []*statement{}
+		for scanner.Scan() {
+			statements = append(statements, scanner.Statement().(*statement))
+		}
+		return statements, scanner.Err()
+	}
+	statements, err := scan("let a = (b) ; let b")
+	require.True(t, errors.Is(err, ErrIncomplete), "%v", err)
+	require.Equal(t, []*statement{
+		{Pos: lexer.Position{Offset: 0, Line: 1, Column: 1}, Name: "a", Value: []string{"b"}},
+	}, statements)
+
+	statements, err = scan(" = (c\n")
+	require.True(t, errors.Is(err, ErrIncomplete), "%v", err)
+	require.Empty(t, statements)
+
+	statements, err = scan("d);\nlet c = ();\n")
+	require.NoError(t, err)
+	require.Equal(t, []*statement{
+		{Pos: lexer.Position{Offset: 14, Line: 1, Column: 15}, Name: "b", Value: []string{"c", "d"}},
+		{Pos: lexer.Position{Offset: 29, Line: 3, Column: 1}, Name: "c"},
+	}, statements)
+
+	statements, err = scan("let = ;")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrIncomplete))
+	require.Empty(t, statements)
+	scanner.Reset()
+
+	statements, err = scan("let e = (f);")
+	require.NoError(t, err)
+	require.Equal(t, []*statement{
+		{Pos: lexer.Position{Offset: 48, Line: 4, Column: 8}, Name: "e", Value: []string{"f"}},
+	}, statements)
+
+	_, err = NewStatementScanner(mustTestParser(t, &statement{}))
+	require.Error(t, err)
+}
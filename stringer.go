@@ -35,21 +35,59 @@ func (s *stringerVisitor) visit(n node, depth int, disjunctions bool) {
 			if i > 0 {
 				fmt.Fprint(s, " | ")
 			}
+			if n.flags != nil && n.flags[i] != "" {
+				fmt.Fprintf(s, "?%s ", n.flags[i])
+			}
 			s.visit(c, depth, disjunctions || len(n.nodes) > 1)
 		}
 		if disjunctions {
 			fmt.Fprintf(s, ")")
 		}
 
+	case *exclusive:
+		fmt.Fprint(s, "^")
+		s.visit(&n.disjunction, depth, true)
+
+	case *union:
+		s.visit(&n.disjunction, depth, disjunctions)
+
+	case *precedence:
+		s.visit(n.grammar, depth, disjunctions)
+
+	case *unordered:
+		fmt.Fprint(s, "< ")
+		for i, c := range n.nodes {
+			if i > 0 {
+				fmt.Fprint(s, " | ")
+			}
+			s.visit(c, depth, true)
+		}
+		fmt.Fprint(s, " >")
+
+	case *record:
+		fmt.Fprint(s, "%{ ")
+		for i, c := range n.nodes {
+			if i > 0 {
+				fmt.Fprint(s, " | ")
+			}
+			s.visit(c, depth, true)
+			fmt.Fprint(s, n.cardinality[i])
+		}
+		fmt.Fprint(s, " }")
+
 	case *strct:
 		s.visit(n.expr, depth, disjunctions)
 
 	case *sequence:
-		for c, i := n, 0; c != nil && depth-i > 0; c, i = c.next, i+1 {
+		for c, i := n, 0; c != nil && depth-i > 0; c = c.next {
 			if c != n {
 				fmt.Fprint(s, " ")
 			}
 			s.visit(c.node, depth-i, disjunctions)
+			// A column says little on its own, so is described along with the term it constrains.
+			if _, ok := c.node.(*column); !ok {
+				i++
+			}
 		}
 
 	case *parseable:
@@ -64,10 +102,50 @@ func (s *stringerVisitor) visit(n node, depth int, disjunctions bool) {
 			} else {
 				s.visit(n.node, depth, disjunctions)
 			}
+			if n.groups != nil {
+				fmt.Fprintf(s, " %s", n.groups)
+			}
 		}
 
 	case *reference:
-		fmt.Fprintf(s, "<%s>", strings.ToLower(n.identifier))
+		identifiers := strings.Split(strings.ToLower(n.identifier), "|")
+		if len(identifiers) == 1 {
+			fmt.Fprintf(s, "<%s>", identifiers[0])
+		} else {
+			fmt.Fprintf(s, "(<%s>)", strings.Join(identifiers, "> | <"))
+		}
+
+	case *signedNumber:
+		fmt.Fprint(s, `[ "-" | "+" ] `)
+		s.visit(n.number, depth, disjunctions)
+
+	case *keywordSet:
+		fmt.Fprintf(s, "$%s", n.name)
+
+	case *positiveLookahead:
+		fmt.Fprint(s, "&")
+		s.visit(n.node, depth, true)
+
+	case *versioned:
+		// Versions are given by field tags, so are not part of the grammar.
+		s.visit(n.node, depth, disjunctions)
+
+	case *cut:
+		fmt.Fprint(s, "!")
+
+	case *epsilon:
+		fmt.Fprint(s, "ε")
+
+	case *negation:
+		fmt.Fprint(s, "~")
+		s.visit(n.stop.node, depth, true)
+
+	case *column:
+		if n.min == n.max {
+			fmt.Fprintf(s, "#%d", n.min)
+		} else {
+			fmt.Fprintf(s, "#%d-%d", n.min, n.max)
+		}
 
 	case *optional:
 		fmt.Fprint(s, "[ ")
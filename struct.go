@@ -3,6 +3,7 @@ package participle
 import (
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/alecthomas/participle/lexer"
 )
@@ -13,6 +14,9 @@ type structLexer struct {
 	field   int
 	indexes [][]int
 	lexer   lexer.PeekingLexer
+	// The tag of the current field, and the offset within it of the input to lexer.
+	tag    string
+	offset int
 }
 
 func lexStruct(s reflect.Type) (*structLexer, error) {
@@ -25,8 +29,7 @@ func lexStruct(s reflect.Type) (*structLexer, error) {
 		indexes: indexes,
 	}
 	if len(slex.indexes) > 0 {
-		tag := fieldLexerTag(slex.Field().StructField)
-		slex.lexer = lexer.Upgrade(lexer.LexString(tag))
+		slex.setTag(fieldLexerTag(slex.Field().StructField), 0)
 	}
 	return slex, nil
 }
@@ -39,6 +42,9 @@ func (s *structLexer) NumField() int {
 type structLexerField struct {
 	reflect.StructField
 	Index []int
+	// If set, the name of the method on the struct pointer that is passed the captured value
+	// instead of it being assigned to a field. Type is the type of the method's argument.
+	setter string
 }
 
 // Field returns the field associated with the current token.
@@ -90,11 +96,58 @@ func (s *structLexer) Next() (lexer.Token, error) {
 		return lexer.EOFToken(token.Pos), nil
 	}
 	s.field++
-	tag := fieldLexerTag(s.Field().StructField)
-	s.lexer = lexer.Upgrade(lexer.LexString(tag))
+	s.setTag(fieldLexerTag(s.Field().StructField), 0)
 	return s.Next()
 }
 
+// Lex the tag of the current field from offset.
+func (s *structLexer) setTag(tag string, offset int) {
+	s.tag = tag
+	s.offset = offset
+	s.lexer = lexer.Upgrade(lexer.LexString(tag[offset:]))
+}
+
+// PeekDelimited returns the text following the next token of the current field, if it is
+// delimiter, up to the next occurrence of delimiter in the tag of the field. Any escaped delimiters,
+// eg. "\/", are unescaped.
+func (s *structLexer) PeekDelimited(delimiter string) (string, bool) {
+	text, _, ok := s.delimited(delimiter)
+	return strings.ReplaceAll(text, "\\"+delimiter, delimiter), ok
+}
+
+// SkipDelimited consumes the delimited text returned by PeekDelimited(), including its delimiters.
+func (s *structLexer) SkipDelimited(delimiter string) {
+	if _, end, ok := s.delimited(delimiter); ok {
+		s.setTag(s.tag, end)
+	}
+}
+
+// Returns the text delimited by the next token of the current field, and the offset in the tag
+// following the closing delimiter.
+func (s *structLexer) delimited(delimiter string) (string, int, bool) {
+	// The lexer of the current field is peeked, so that the text never spans fields.
+	open, err := s.lexer.Peek(0)
+	if err != nil || open.EOF() || open.Value != delimiter {
+		return "", 0, false
+	}
+	start := s.offset + open.Pos.Offset + len(open.Value)
+	text, ok := scanDelimited(s.tag[start:], delimiter)
+	return text, start + len(text) + len(delimiter), ok
+}
+
+// Returns the text of s up to the first occurrence of delimiter not preceded by a backslash.
+func scanDelimited(s, delimiter string) (string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case strings.HasPrefix(s[i:], delimiter):
+			return s[:i], true
+		}
+	}
+	return "", false
+}
+
 func fieldLexerTag(field reflect.StructField) string {
 	if tag, ok := field.Tag.Lookup("parser"); ok {
 		return tag
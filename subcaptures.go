@@ -0,0 +1,83 @@
+package participle
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// Sub-captures assign the named groups of a regular expression, matched against the value
+// captured by "@<expression> /<regex>/", to the fields of the same names, eg.
+//
+//	Major int `@Version /(?P<major>\d+)\.(?P<minor>\d+)/`
+//	Minor int
+//
+// The regular expression must match the whole value. A group whose name begins with a lower case
+// letter is assigned to the field of the same name beginning with an upper case letter, and a
+// group that does not participate in the match leaves its field unchanged.
+type subCaptures struct {
+	re *regexp.Regexp
+	// The field each group of re is assigned to, or nil for unnamed groups.
+	fields []*structLexerField
+}
+
+// Returns true if pattern contains a named group, and so is a regular expression of sub-captures
+// rather than the separator of a repetition.
+func hasNamedGroup(pattern string) bool {
+	return strings.Contains(pattern, "(?P<") || strings.Contains(pattern, "(?<")
+}
+
+// Parse the regular expression pattern, resolving its named groups to fields of s.
+func parseSubCaptures(s reflect.Type, pattern string) (*subCaptures, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression /%s/: %s", pattern, err)
+	}
+	out := &subCaptures{re: re, fields: make([]*structLexerField, len(re.SubexpNames()))}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(name)
+		field, err := lookupTarget(s, string(unicode.ToUpper(r))+name[size:])
+		if err != nil {
+			return nil, fmt.Errorf("group %q of /%s/: %s", name, pattern, err)
+		}
+		out.fields[i] = &field
+	}
+	return out, nil
+}
+
+// Match the captured values, captured at pos, and assign the groups to the fields of strct, given
+// the fields of it captured so far, if tracked.
+func (s *subCaptures) set(pos lexer.Position, strct reflect.Value, values []reflect.Value, join stringJoin, numbers *numberFormat, captured map[string]bool) error {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, v.String())
+	}
+	value := strings.Join(parts, "")
+	match := s.re.FindStringSubmatchIndex(value)
+	if match == nil {
+		return lexer.Errorf(pos, "%q does not match %s", value, s)
+	}
+	for i, field := range s.fields {
+		if field == nil || match[2*i] < 0 {
+			continue
+		}
+		group := []reflect.Value{reflect.ValueOf(value[match[2*i]:match[2*i+1]])}
+		if err := setField(pos, strct, *field, group, join.of(*field, captured), numbers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *subCaptures) String() string {
+	pattern := s.re.String()
+	return "/" + pattern[len(`^(?:`):len(pattern)-len(`)$`)] + "/"
+}
@@ -0,0 +1,59 @@
+package participle
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// Trace writes a trace of a single parse to w, for debugging a grammar.
+//
+// Each grammar struct attempted is written when it is entered, eg. "> Statement", and when it is
+// exited, eg. "< Statement (ok)", with the tokens matched within it, eg. `match Ident "foo"`,
+// and the structs it attempts indented beneath. Structs that do not match exit with "(no match)"
+// and those that fail with "(error)". Tokens matched by a branch that is later backtracked over
+// are written too, so the trace shows every step of the parse.
+func Trace(w io.Writer) ParseOption {
+	return func(p *parseContext) {
+		p.trace = &tracer{w: w}
+	}
+}
+
+type tracer struct {
+	w       io.Writer
+	symbols map[rune]string
+	depth   int
+}
+
+func (t *tracer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(t.w, "%s%s\n", strings.Repeat("  ", t.depth), fmt.Sprintf(format, args...))
+}
+
+func (t *tracer) enter(name string) {
+	t.printf("> %s", name)
+	t.depth++
+}
+
+func (t *tracer) exit(name string, out []reflect.Value, err error) {
+	t.depth--
+	switch {
+	case err != nil:
+		t.printf("< %s (error)", name)
+	case out == nil:
+		t.printf("< %s (no match)", name)
+	default:
+		t.printf("< %s (ok)", name)
+	}
+}
+
+func (t *tracer) token(token lexer.Token) {
+	if symbol, ok := t.symbols[token.Type]; ok {
+		t.printf("match %s %q", symbol, token.Value)
+	} else {
+		// Eg. punctuation from the default lexer, whose token types are unnamed.
+		t.printf("match %q", token.Value)
+	}
+}
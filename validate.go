@@ -0,0 +1,162 @@
+package participle
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+// A validator checks captured values against the "opts" tag of their field, eg.
+//
+//	Percent int `parser:"@Int" opts:"min=0,max=100"`
+//
+// min and max bound the values of numeric fields, while minlen and maxlen bound the length in
+// characters of string fields. Each element of a slice field is validated as it is captured.
+type validator struct {
+	min, max       *float64
+	minLen, maxLen *int
+}
+
+// Parse the "opts" tag of field into a validator of its values and a collection maintaining its
+// elements, either of which is nil if the tag has no options for it.
+func parseFieldOptions(field structLexerField) (*validator, *collection, error) {
+	tag, ok := field.Tag.Lookup("opts")
+	if !ok {
+		return nil, nil, nil
+	}
+	if field.setter != "" || field.Type.Kind() == reflect.Chan {
+		return nil, nil, fmt.Errorf("opts can not be applied to field %s, which is not assigned directly", field.Name)
+	}
+	t := field.Type
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	t = indirectType(t)
+	v := &validator{}
+	validated := false
+	c := &collection{}
+	key := ""
+	for _, option := range strings.Split(tag, ",") {
+		parts := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		if name := parts[0]; len(parts) == 1 && (name == "set" || name == "sorted") {
+			if field.Type.Kind() != reflect.Slice {
+				return nil, nil, fmt.Errorf("opts %q requires a slice field", name)
+			}
+			c.set = c.set || name == "set"
+			c.sorted = c.sorted || name == "sorted"
+			continue
+		}
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("invalid opts %q, expected <option>=<value>", option)
+		}
+		name, value := parts[0], parts[1]
+		if name == "key" {
+			key = value
+			continue
+		}
+		validated = true
+		switch name {
+		case "min", "max":
+			if !isNumeric(t) || t == bigIntType || t == bigFloatType {
+				return nil, nil, fmt.Errorf("opts %q requires a numeric field", name)
+			}
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid opts %q: %s", option, err)
+			}
+			if name == "min" {
+				v.min = &n
+			} else {
+				v.max = &n
+			}
+		case "minlen", "maxlen":
+			if t.Kind() != reflect.String {
+				return nil, nil, fmt.Errorf("opts %q requires a string field", name)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, nil, fmt.Errorf("invalid opts %q, expected a non-negative length", option)
+			}
+			if name == "minlen" {
+				v.minLen = &n
+			} else {
+				v.maxLen = &n
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown opts %q", name)
+		}
+	}
+	if key != "" {
+		if !c.set && !c.sorted {
+			return nil, nil, fmt.Errorf("opts \"key\" requires \"set\" or \"sorted\"")
+		}
+		f, ok := t.FieldByName(key)
+		if t.Kind() != reflect.Struct || !ok {
+			return nil, nil, fmt.Errorf("opts \"key\" requires a field %s of the elements of %s", key, field.Name)
+		}
+		if !isOrdered(f.Type) {
+			return nil, nil, fmt.Errorf("opts \"key\" requires a string, numeric or boolean field, not %s", f.Type)
+		}
+		c.key = f.Index
+	}
+	if !validated {
+		v = nil
+	}
+	if !c.set && !c.sorted {
+		c = nil
+	}
+	return v, c, nil
+}
+
+// Validate the value of f captured at pos, or of each element of a slice from index from.
+func (v *validator) validate(pos lexer.Position, f reflect.Value, from int) error {
+	if f.Kind() != reflect.Slice {
+		return v.check(pos, f)
+	}
+	for i := from; i < f.Len(); i++ {
+		if err := v.check(pos, f.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) check(pos lexer.Position, value reflect.Value) error {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	var n float64
+	switch value.Kind() {
+	case reflect.String:
+		length := utf8.RuneCountInString(value.String())
+		if v.minLen != nil && length < *v.minLen {
+			return lexer.Errorf(pos, "%q is shorter than the minimum length %d", value.String(), *v.minLen)
+		}
+		if v.maxLen != nil && length > *v.maxLen {
+			return lexer.Errorf(pos, "%q is longer than the maximum length %d", value.String(), *v.maxLen)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	default:
+		return nil
+	}
+	if v.min != nil && n < *v.min {
+		return lexer.Errorf(pos, "%v is less than the minimum %v", value.Interface(), *v.min)
+	}
+	if v.max != nil && n > *v.max {
+		return lexer.Errorf(pos, "%v is greater than the maximum %v", value.Interface(), *v.max)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package participle
+
+import "fmt"
+
+// Visit all nodes in the grammar graph reachable from n, once each.
+//
+// Calling next() from within the visitor recurses into the children of the current node,
+// allowing the visitor to perform work both before and after its children are visited. Returning
+// without calling next() prunes the children.
+func visit(n node, visitor func(n node, next func() error) error) error {
+	seen := map[node]bool{}
+	var recurse func(n node) error
+	recurse = func(n node) error {
+		if n == nil || seen[n] {
+			return nil
+		}
+		seen[n] = true
+		return visitor(n, func() error {
+			for _, child := range children(n) {
+				if err := recurse(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return recurse(n)
+}
+
+// Visit all nodes in the grammar graph reachable from root, once each, with the innermost struct
+// containing them. A struct is visited with itself, and nodes outside any struct are not visited.
+func visitWithin(root node, visitor func(owner *strct, n node) error) error {
+	var owner *strct
+	return visit(root, func(n node, next func() error) error {
+		if s, ok := n.(*strct); ok {
+			outer := owner
+			owner = s
+			defer func() { owner = outer }()
+		}
+		if owner != nil {
+			if err := visitor(owner, n); err != nil {
+				return err
+			}
+		}
+		return next()
+	})
+}
+
+// Visit all captures in the grammar graph reachable from root, once each, with the name of the
+// innermost struct containing them.
+func visitCaptures(root node, visitor func(rule string, c *capture) error) error {
+	return visitWithin(root, func(owner *strct, n node) error {
+		if c, ok := n.(*capture); ok {
+			return visitor(ruleName(owner.typ), c)
+		}
+		return nil
+	})
+}
+
+// Returns the immediate children of a node.
+func children(n node) []node {
+	switch n := n.(type) {
+	case *disjunction:
+		return n.nodes
+	case *exclusive:
+		return n.nodes
+	case *union:
+		return n.nodes
+	case *precedence:
+		return []node{n.grammar}
+	case *unordered:
+		return n.nodes
+	case *record:
+		return n.nodes
+	case *strct:
+		return []node{n.expr}
+	case *sequence:
+		out := []node{}
+		for c := n; c != nil; c = c.next {
+			out = append(out, c.node)
+		}
+		return out
+	case *capture:
+		return []node{n.node}
+	case *optional:
+		return []node{n.node, n.next}
+	case *repetition:
+		return []node{n.node, n.next}
+	case *signedNumber:
+		return []node{n.number}
+	case *positiveLookahead:
+		return []node{n.node}
+	case *versioned:
+		return []node{n.node}
+	case *negation:
+		return []node{n.stop}
+	case *parseable, *reference, *keywordSet, *literal, *cut, *column, *epsilon:
+		return nil
+	default:
+		panic(fmt.Sprintf("unsupported node type %T", n))
+	}
+}